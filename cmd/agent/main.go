@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
 	"fmt"
 	"net/http"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -13,6 +13,7 @@ import (
 	"github.com/stepanov-ds/ya-metrics/internal/collector"
 	"github.com/stepanov-ds/ya-metrics/internal/config/agent"
 	"github.com/stepanov-ds/ya-metrics/internal/sender"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
 
 var (
@@ -29,7 +30,37 @@ func main() {
 	agent.ConfigAgent()
 	var headers http.Header = make(map[string][]string)
 	headers.Add("Content-Type", "application/json")
-	sender := sender.NewHTTPSender(time.Second*10, headers, "http://"+*agent.EndpointAgent, *agent.RateLimit, agent.ReadPublicKey(*agent.CryptoKey).PublicKey.(*rsa.PublicKey))
+	// Continue the trace of the process that started the agent, if any
+	if tp, ok := os.LookupEnv("TRACEPARENT"); ok {
+		headers.Set(utils.TraceparentHeader, tp)
+	}
+	if *agent.Token != "" {
+		headers.Add("Authorization", "Bearer "+*agent.Token)
+	}
+	tlsConfig, err := agent.TLSConfig(*agent.TLSCA, *agent.TLSCert, *agent.TLSKey)
+	if err != nil {
+		fmt.Println("invalid TLS configuration:", err.Error())
+		os.Exit(1)
+	}
+	var client *http.Client
+	if tlsConfig != nil {
+		client = sender.NewTLSClient(time.Second*10, tlsConfig)
+	}
+	sender := sender.NewHTTPSender(time.Second*10, headers, agent.BaseURL(), *agent.RateLimit, agent.ReadPublicKey(*agent.CryptoKey).PublicKey)
+	if client != nil {
+		sender.Client = client
+	}
+	if !utils.SupportedEncoding(*agent.Compression) {
+		fmt.Println("unsupported compression:", *agent.Compression)
+		os.Exit(1)
+	}
+	sender.Compression = *agent.Compression
+
+	go func() {
+		if err := sender.SendMetadata(collector.BuiltinMetadata()); err != nil {
+			println(err.Error())
+		}
+	}()
 
 	collector1 := collector.NewCollector(&sync.Map{})
 	wg.Add(1)
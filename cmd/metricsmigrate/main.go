@@ -0,0 +1,100 @@
+// Command metricsmigrate copies the metrics of the server between its
+// storage backends: from the snapshot file into PostgreSQL and back.
+//
+//	go run ./cmd/metricsmigrate -f filestore.out -d postgres://... -to db
+//	go run ./cmd/metricsmigrate -f filestore.out -d postgres://... -to file -dry-run
+//
+// The server must be stopped while metrics are migrated: updates it stores
+// during the migration may be overwritten. A rerun is safe and writes only
+// what changed since the previous run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/migrate"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Migration targets, see the -to flag.
+const (
+	toDB   = "db"
+	toFile = "file"
+)
+
+func main() {
+	logger.Initialize("info")
+
+	// Like the server, the file and the DSN can be set via env vars
+	// "FILE_STORAGE_PATH" and "DATABASE_DSN".
+	file := flag.String("f", envOr("FILE_STORAGE_PATH", "filestore.out"), "snapshot file of the server")
+	dsn := flag.String("d", envOr("DATABASE_DSN", ""), "database DSN")
+	to := flag.String("to", toDB, "target backend: db copies the file into the database, file the database into the file")
+	var opts migrate.Options
+	flag.BoolVar(&opts.Replace, "replace", false, "delete metrics of the target missing from the source")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "report the changes without writing them")
+	flag.BoolVar(&opts.Verify, "verify", false, "compare the target with the source after the migration")
+	flag.Parse()
+
+	if *dsn == "" {
+		logger.Log.Fatal("main", zap.String("error", "database DSN is required, set -d or DATABASE_DSN"))
+	}
+	if *to != toDB && *to != toFile {
+		logger.Log.Fatal("main", zap.String("error", "-to must be db or file"))
+	}
+
+	ctx := context.Background()
+	pool := storage.NewDBPool(ctx, *dsn)
+	if pool == nil {
+		logger.Log.Fatal("main", zap.String("error", "cannot create database pool"))
+	}
+	defer pool.Close()
+	if err := pool.Ping(ctx); err != nil {
+		logger.Log.Fatal("main", zap.String("error while connecting to database", err.Error()))
+	}
+	db := storage.NewDBStorage(ctx, pool)
+
+	// The target file may not exist yet, the source file must
+	snapshot, err := migrate.ReadFile(*file, *to == toFile)
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("error while reading snapshot file", err.Error()))
+	}
+
+	var src, dst storage.Storage = snapshot, db
+	if *to == toFile {
+		src, dst = db, snapshot
+	}
+	report, err := migrate.Run(ctx, src, dst, opts)
+	if err != nil && !errors.Is(err, migrate.ErrMismatch) {
+		logger.Log.Fatal("main", zap.String("error while migrating", err.Error()))
+	}
+	if *to == toFile && !opts.DryRun && report.Changed() {
+		if err := migrate.WriteFile(*file, snapshot); err != nil {
+			logger.Log.Fatal("main", zap.String("error while writing snapshot file", err.Error()))
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	logger.Log.Info("main", zap.String("to", *to), zap.Bool("dry_run", opts.DryRun),
+		zap.Int("created", len(report.Created)), zap.Int("updated", len(report.Updated)),
+		zap.Int("deleted", len(report.Deleted)), zap.Int("unchanged", report.Unchanged))
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("verification failed", err.Error()), zap.Strings("mismatched", report.Mismatched))
+	}
+}
+
+// envOr returns the env var key, or def if it is not set.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
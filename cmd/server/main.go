@@ -8,22 +8,39 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepanov-ds/ya-metrics/internal/audit"
 	"github.com/stepanov-ds/ya-metrics/internal/config/server"
 	"github.com/stepanov-ds/ya-metrics/internal/handlers/router"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/middlewares"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
 	"go.uber.org/zap"
 )
 
 func main() {
 	logger.Initialize("info")
 	server.ConfigServer()
+	if err := logger.Configure(logger.Options{Level: *server.LogLevel, Format: *server.LogFormat, Output: *server.LogOutput}); err != nil {
+		logger.Log.Fatal("main", zap.String("invalid log configuration", err.Error()))
+	}
+	logging, err := router.RequestLogging()
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("invalid log sampling", err.Error()))
+	}
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	// Client IPs key the rate limits, series limits and audit records, so
+	// forwarding headers are believed only from the configured proxies
+	if err := r.SetTrustedProxies(server.TrustedProxyList()); err != nil {
+		logger.Log.Fatal("main", zap.String("invalid trusted proxies", err.Error()))
+	}
 	var st storage.Storage
 	var p *pgxpool.Pool
 
@@ -33,7 +50,13 @@ func main() {
 	if server.IsDB {
 		st = storage.NewDBStorage(ctx, storage.NewDBPool(ctx, *server.DatabaseDSN))
 		p = st.(*storage.DBStorage).Pool.(*pgxpool.Pool)
+		if p != nil {
+			storage.RegisterPoolStats(telemetry.Default, p.Stat)
+		}
 		defer st.(*storage.DBStorage).Pool.(*pgxpool.Pool).Close()
+		if *server.CacheTTL > 0 {
+			st = storage.NewCacheStorage(st, *server.CacheTTL, *server.CacheSize)
+		}
 	} else {
 		if *server.Restore {
 			st = server.RestoreStorage()
@@ -42,28 +65,187 @@ func main() {
 		}
 		go server.StoreInFile(st.(*storage.MemStorage))
 	}
-	router.Route(r, st, p, server.ReadPrivateKey(*server.CryptoKey))
+	if *server.HistoryRetention > 0 {
+		st = storage.NewHistoryStorage(st, *server.HistoryRetention)
+	}
+	if *server.MaxSeries > 0 || *server.MaxSeriesPerClient > 0 {
+		st = storage.NewCardinalityStorage(st, *server.MaxSeries, *server.MaxSeriesPerClient)
+	}
+	if *server.SelfMetricsInterval > 0 {
+		// Written below the audit decorator: the server's own metrics are not client updates
+		self := st
+		go telemetry.Export(ctx, telemetry.Default, *server.SelfMetricsInterval, func(ctx context.Context, name string, value float64) {
+			self.SetMetric(ctx, name, value, false)
+		})
+	}
+	if auditor := newAuditor(); auditor != nil {
+		defer auditor.Close()
+		st = storage.NewAuditStorage(st, auditor)
+	}
+	if *server.MetadataFile != "" {
+		if err := metadata.Default.LoadFile(*server.MetadataFile); err != nil {
+			logger.Log.Error("main", zap.String("error while loading metadata file", err.Error()))
+		}
+	}
+	keys, err := server.LoadKeyring(*server.CryptoKey)
+	if err != nil {
+		logger.Log.Error("main", zap.String("error while loading private keys", err.Error()))
+	}
+	signing, err := server.LoadSigningKeys(*server.Key, *server.HashKeysFile)
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("error while loading signing keys", err.Error()))
+	}
+	tokens, err := server.LoadTokens(server.ConfigTokens, *server.TokensFile)
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("error while loading API tokens", err.Error()))
+	}
+	go reloadOnSIGHUP(ctx, keys, signing, tokens)
+	sec := router.Security{Keys: keys, Signing: signing, Tokens: tokens, RejectLegacyV1: !*server.AcceptLegacyV1}
+	if sec.ReadEncryption, err = middlewares.ParseEncryptionPolicy(*server.EncryptionRead); err != nil {
+		logger.Log.Fatal("main", zap.String("invalid read encryption policy", err.Error()))
+	}
+	if sec.WriteEncryption, err = middlewares.ParseEncryptionPolicy(*server.EncryptionWrite); err != nil {
+		logger.Log.Fatal("main", zap.String("invalid write encryption policy", err.Error()))
+	}
+	if *server.ReplayWindow > 0 {
+		sec.Replay = middlewares.NewReplayGuard(*server.ReplayWindow, *server.ReplayCacheSize)
+	}
+	key, err := router.RateLimitKey(*server.RateLimitBy)
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("invalid rate limit key", err.Error()))
+	}
+	if *server.MaxSeriesPerClient > 0 {
+		sec.Client = key
+	}
+	if *server.RateLimitRPS > 0 || *server.RateLimitMetrics > 0 {
+		sec.Limiter = middlewares.NewRateLimiter(middlewares.RateLimitOptions{
+			RequestsPerSecond: *server.RateLimitRPS,
+			Burst:             *server.RateLimitBurst,
+			MetricsPerMinute:  *server.RateLimitMetrics,
+			Key:               key,
+		})
+	}
+	router.Route(r, st, p, sec)
 	logger.Log.Info("main", zap.String("working with DB", strconv.FormatBool(server.IsDB)))
 
+	tlsConfig, err := server.TLSConfig(*server.TLSCert, *server.TLSKey, *server.TLSClientCA)
+	if err != nil {
+		logger.Log.Fatal("main", zap.String("invalid TLS configuration", err.Error()))
+	}
 	srv := &http.Server{
-		Addr:    *server.EndpointServer,
-		Handler: r.Handler(),
+		Addr:      *server.EndpointServer,
+		Handler:   r.Handler(),
+		TLSConfig: tlsConfig,
 	}
+	adminSrv, adminFailed := serveAdmin(st, p, sec, logging)
 
 	quit := make(chan os.Signal, 1)
 	idleConnsClosed := make(chan struct{})
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	go func() {
-		<-quit
+		// A failed admin listener stops the server the way a signal does,
+		// so the deferred cleanup of main still runs.
+		select {
+		case <-quit:
+		case err := <-adminFailed:
+			logger.Log.Error("main", zap.String("admin listener failed", err.Error()))
+		}
 		if err := srv.Shutdown(context.Background()); err != nil {
 			logger.Log.Info("main", zap.Error(err))
 		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				logger.Log.Info("main", zap.Error(err))
+			}
+		}
 		close(idleConnsClosed)
 	}()
 
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	serve := srv.ListenAndServe
+	if tlsConfig != nil {
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil && err != http.ErrServerClosed {
 		logger.Log.Info("main", zap.Error(err))
 	}
 	<-idleConnsClosed
 }
+
+// serveAdmin starts the admin listener on server.AdminAddress with the
+// health check, backup and pprof routes. It returns nil if no admin address is set.
+// If the listener fails, the error is sent on the returned channel.
+//
+// The admin listener serves plain HTTP and is meant to be bound to a
+// loopback or private interface. Request bodies are bounded before they
+// are logged, by server.MaxImportSize or server.MaxBodySize if larger, as
+// the token is only checked by the routes.
+func serveAdmin(st storage.Storage, pool *pgxpool.Pool, sec router.Security, logging middlewares.LoggingOptions) (*http.Server, <-chan error) {
+	if *server.AdminAddress == "" {
+		return nil, nil
+	}
+	a := gin.New()
+	// Validated by main for the public engine
+	_ = a.SetTrustedProxies(server.TrustedProxyList())
+	a.Use(gin.Recovery(), middlewares.RequestID(), middlewares.Instrument(),
+		middlewares.BufferBody(max(*server.MaxImportSize, *server.MaxBodySize)), middlewares.LogRequests(logging))
+	router.RouteAdmin(a, st, pool, sec)
+	router.NoRoute(a)
+
+	srv := &http.Server{
+		Addr:    *server.AdminAddress,
+		Handler: a.Handler(),
+	}
+	failed := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			failed <- err
+		}
+	}()
+	return srv, failed
+}
+
+// newAuditor creates the Auditor delivering to the configured audit sinks.
+// It returns nil if neither an audit file nor an audit URL is set.
+func newAuditor() *audit.Auditor {
+	var sinks []audit.Sink
+	if *server.AuditFile != "" {
+		f, err := audit.OpenFileSink(*server.AuditFile)
+		if err != nil {
+			logger.Log.Fatal("main", zap.String("error while opening audit file", err.Error()))
+		}
+		sinks = append(sinks, f)
+	}
+	if *server.AuditURL != "" {
+		sinks = append(sinks, audit.NewHTTPSink(*server.AuditURL, 5*time.Second))
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	return audit.NewAuditor(*server.AuditQueueSize, sinks...)
+}
+
+// reloadOnSIGHUP reloads the private keys, signing keys and API tokens every
+// time the process gets SIGHUP, so they can be rotated without a restart.
+//
+// A failed reload keeps what was loaded before, and the keys and tokens given
+// in the config are loaded ahead of their files, so a broken file never turns
+// verification or authentication off, neither on startup nor on reload.
+func reloadOnSIGHUP(ctx context.Context, reloaders ...interface{ Reload() error }) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			for _, r := range reloaders {
+				if err := r.Reload(); err != nil {
+					logger.Log.Error("reloadOnSIGHUP", zap.String("error while reloading keys", err.Error()))
+				}
+			}
+			logger.Log.Info("reloadOnSIGHUP", zap.String("status", "keys reloaded"))
+		}
+	}
+}
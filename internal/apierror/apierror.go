@@ -0,0 +1,128 @@
+// Package apierror defines the JSON error envelope returned by the metrics server.
+//
+// Every failed request is answered with a body of the form
+//
+//	{"error":{"code":"invalid_value","message":"...","index":2}}
+//
+// where code is a stable machine-readable identifier, message is a human-readable
+// explanation and index (batch requests only) points to the offending item.
+package apierror
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Machine-readable error codes shared by handlers and middlewares.
+const (
+	// CodeInvalidBody means the request body could not be read or parsed.
+	CodeInvalidBody = "invalid_body"
+	// CodeUnknownMetricType means the metric type is neither gauge nor counter.
+	CodeUnknownMetricType = "unknown_metric_type"
+	// CodeInvalidValue means the metric value could not be parsed for its type.
+	CodeInvalidValue = "invalid_value"
+	// CodeMissingValue means the metric value was not provided.
+	CodeMissingValue = "missing_value"
+	// CodeMissingName means the metric name was not provided.
+	CodeMissingName = "missing_name"
+	// CodeInvalidName means the metric name is too long or has forbidden characters.
+	CodeInvalidName = "invalid_name"
+	// CodeTypeMismatch means the metric type differs from its declaration.
+	CodeTypeMismatch = "type_mismatch"
+	// CodeInvalidMetadata means a metric declaration is malformed.
+	CodeInvalidMetadata = "invalid_metadata"
+	// CodeMetricNotFound means the requested metric does not exist or has another type.
+	CodeMetricNotFound = "metric_not_found"
+	// CodeMethodNotAllowed means the HTTP method is not supported by the endpoint.
+	CodeMethodNotAllowed = "method_not_allowed"
+	// CodeHashMismatch means the HashSHA256 header does not match the request body.
+	CodeHashMismatch = "hash_mismatch"
+	// CodeStaleRequest means the request timestamp or nonce is missing or outside the clock-skew window.
+	CodeStaleRequest = "stale_request"
+	// CodeReplayed means the request nonce has already been used.
+	CodeReplayed = "replayed"
+	// CodeUnauthorized means the bearer token is missing or unknown.
+	CodeUnauthorized = "unauthorized"
+	// CodeForbidden means the bearer token does not grant the scope the endpoint requires.
+	CodeForbidden = "forbidden"
+	// CodeDecryptFailed means the encrypted payload could not be decrypted.
+	CodeDecryptFailed = "decrypt_failed"
+	// CodeEncryptionUnsupported means the endpoint does not accept encrypted requests.
+	CodeEncryptionUnsupported = "encryption_unsupported"
+	// CodeDecompressFailed means the compressed body could not be decompressed.
+	CodeDecompressFailed = "decompress_failed"
+	// CodeBodyTooLarge means the request body exceeds the size limit once decompressed.
+	CodeBodyTooLarge = "body_too_large"
+	// CodeHistoryDisabled means the server keeps no metric history to derive values from.
+	CodeHistoryDisabled = "history_disabled"
+	// CodeInvalidWindow means the window of a derived value is malformed or too long.
+	CodeInvalidWindow = "invalid_window"
+	// CodeInvalidFunction means the derivation function is unknown or does not fit the metric type.
+	CodeInvalidFunction = "invalid_function"
+	// CodeInsufficientSamples means the window has too few samples to derive a value.
+	CodeInsufficientSamples = "insufficient_samples"
+	// CodeInvalidQuery means an aggregation query could not be parsed.
+	CodeInvalidQuery = "invalid_query"
+	// CodeRateLimited means the client exceeded its request or metric rate; see Retry-After.
+	CodeRateLimited = "rate_limited"
+	// CodeSeriesLimit means the metric would create a series over the limit of the server or the client.
+	CodeSeriesLimit = "series_limit"
+	// CodeNotFound means no route matches the request.
+	CodeNotFound = "not_found"
+	// CodeInternal means the server failed to process a valid request.
+	CodeInternal = "internal"
+)
+
+// Error describes a single failure reported to the client.
+type Error struct {
+	Index   *int   `json:"index,omitempty"` // позиция элемента в пакетном запросе
+	Code    string `json:"code"`            // машиночитаемый код ошибки
+	Message string `json:"message"`         // описание ошибки
+}
+
+// Response is the top-level JSON envelope that wraps an Error.
+type Response struct {
+	Error Error `json:"error"`
+}
+
+// BatchResponse is returned with 207 Multi-Status when a batch request was
+// only partly applied.
+type BatchResponse struct {
+	Applied  []int   `json:"applied"`  // индексы сохранённых элементов
+	Rejected []Error `json:"rejected"` // ошибки отклонённых элементов
+}
+
+// New creates an Error with the given code and message.
+func New(code, message string) Error {
+	return Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// NewItem creates an Error that refers to the item at position index of a batch request.
+func NewItem(index int, code, message string) Error {
+	e := New(code, message)
+	e.Index = &index
+	return e
+}
+
+// Error implements the error interface.
+func (e Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ContextKey is the gin context key under which AbortWithError records the
+// error code of the response, for the request metrics.
+const ContextKey = "apierror.code"
+
+// Abort stops the middleware chain and responds with status and the error envelope.
+func Abort(c *gin.Context, status int, code, message string) {
+	AbortWithError(c, status, New(code, message))
+}
+
+// AbortWithError stops the middleware chain and responds with status and e wrapped
+// into the error envelope.
+func AbortWithError(c *gin.Context, status int, e Error) {
+	c.Set(ContextKey, e.Code)
+	c.AbortWithStatusJSON(status, Response{Error: e})
+}
@@ -0,0 +1,64 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbort(t *testing.T) {
+	tests := []struct {
+		handler      gin.HandlerFunc
+		name         string
+		expectedBody string
+		status       int
+	}{
+		{
+			name: "plain error",
+			handler: func(c *gin.Context) {
+				Abort(c, http.StatusBadRequest, CodeInvalidValue, "bad value")
+			},
+			status:       http.StatusBadRequest,
+			expectedBody: `{"error":{"code":"invalid_value","message":"bad value"}}`,
+		},
+		{
+			name: "batch item error",
+			handler: func(c *gin.Context) {
+				AbortWithError(c, http.StatusBadRequest, NewItem(2, CodeUnknownMetricType, "unknown"))
+			},
+			status:       http.StatusBadRequest,
+			expectedBody: `{"error":{"index":2,"code":"unknown_metric_type","message":"unknown"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			nextCalled := false
+			r.GET("/", tt.handler, func(c *gin.Context) {
+				nextCalled = true
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			assert.False(t, nextCalled, "chain must be aborted")
+
+			var resp Response
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	e := New(CodeHashMismatch, "hash does not match")
+	assert.Equal(t, "hash_mismatch: hash does not match", e.Error())
+	assert.Nil(t, e.Index)
+}
@@ -0,0 +1,175 @@
+// Package audit records accepted metric updates and delivers them to sinks.
+//
+// An Auditor queues Events in a bounded channel and a single goroutine
+// hands them to every Sink in batches. When the queue is full new events are
+// dropped and counted, so auditing never blocks ingestion.
+package audit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"go.uber.org/zap"
+)
+
+// maxBatch bounds the number of events handed to a sink at once.
+const maxBatch = 256
+
+// Event describes the metrics accepted from one request.
+type Event struct {
+	Time      time.Time       `json:"ts"`                   // время приёма запроса
+	RequestID string          `json:"request_id,omitempty"` // идентификатор запроса
+	ClientIP  string          `json:"ip,omitempty"`         // адрес клиента
+	Agent     string          `json:"agent,omitempty"`      // имя токена клиента
+	Method    string          `json:"method,omitempty"`     // HTTP метод запроса
+	Path      string          `json:"path,omitempty"`       // путь запроса
+	Metrics   []utils.Metrics `json:"metrics"`              // принятые значения в том виде, в каком они пришли
+}
+
+// Sink delivers audit events to their destination.
+type Sink interface {
+	// Write delivers a batch of events in order.
+	Write(events []Event) error
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+// Auditor queues events and delivers them to its sinks asynchronously.
+type Auditor struct {
+	queue   chan Event
+	sinks   []Sink
+	done    chan struct{}
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewAuditor creates an Auditor with a queue of queueSize events and starts
+// delivering them to sinks.
+func NewAuditor(queueSize int, sinks ...Sink) *Auditor {
+	a := &Auditor{
+		queue: make(chan Event, queueSize),
+		sinks: sinks,
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Log queues e for delivery without blocking. If the queue is full or the
+// Auditor is closed the event is dropped and counted.
+func (a *Auditor) Log(e Event) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.queue <- e:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (a *Auditor) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Failed returns the number of events a sink failed to deliver.
+func (a *Auditor) Failed() uint64 {
+	return a.failed.Load()
+}
+
+// Close stops accepting events, delivers the queued ones and closes the sinks.
+func (a *Auditor) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	<-a.done
+	var firstErr error
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	logger.Log.Info("Auditor", zap.Uint64("dropped", a.Dropped()), zap.Uint64("failed", a.Failed()))
+	return firstErr
+}
+
+// run delivers queued events until the queue is closed, batching the
+// events that are already waiting.
+func (a *Auditor) run() {
+	defer close(a.done)
+	batch := make([]Event, 0, maxBatch)
+	for e := range a.queue {
+		batch = append(batch[:0], e)
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case e, ok := <-a.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+		for _, s := range a.sinks {
+			if err := s.Write(batch); err != nil {
+				a.failed.Add(uint64(len(batch)))
+				logger.Log.Warn("Auditor", zap.String("error while writing audit events", err.Error()))
+			}
+		}
+	}
+}
+
+type contextKey struct{}
+
+// Pending collects the metrics stored while serving one request.
+type Pending struct {
+	mu    sync.Mutex
+	event Event
+}
+
+// NewContext returns a copy of ctx carrying a Pending for e; metrics stored
+// with the returned context are added to it.
+func NewContext(ctx context.Context, e Event) (context.Context, *Pending) {
+	p := &Pending{event: e}
+	return context.WithValue(ctx, contextKey{}, p), p
+}
+
+// FromContext returns the Pending carried by ctx, or nil.
+func FromContext(ctx context.Context) *Pending {
+	p, _ := ctx.Value(contextKey{}).(*Pending)
+	return p
+}
+
+// Add appends m to the event.
+func (p *Pending) Add(m utils.Metrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.event.Metrics = append(p.event.Metrics, m)
+}
+
+// Event returns the event with the metrics added so far.
+func (p *Pending) Event() Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.event
+	e.Metrics = append([]utils.Metrics(nil), p.event.Metrics...)
+	return e
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memSink keeps the events it gets; it fails while err is set and blocks
+// while block is open.
+type memSink struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+	block  chan struct{}
+	closed bool
+}
+
+func (s *memSink) Write(events []Event) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func gauge(id string, v float64) utils.Metrics {
+	return utils.Metrics{ID: id, MType: "gauge", Value: &v}
+}
+
+func TestAuditor_DeliversToAllSinks(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	first, second := &memSink{}, &memSink{}
+	a := NewAuditor(16, first, second)
+
+	for i := 0; i < 10; i++ {
+		a.Log(Event{ClientIP: "10.0.0.1", Metrics: []utils.Metrics{gauge("g", float64(i))}})
+	}
+	require.NoError(t, a.Close())
+
+	for _, s := range []*memSink{first, second} {
+		require.Len(t, s.events, 10)
+		assert.Equal(t, 9.0, *s.events[9].Metrics[0].Value)
+		assert.True(t, s.closed)
+	}
+	assert.Zero(t, a.Dropped())
+
+	a.Log(Event{})
+	assert.Equal(t, uint64(1), a.Dropped())
+}
+
+func TestAuditor_DropsWhenFull(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	sink := &memSink{block: make(chan struct{})}
+	a := NewAuditor(2, sink)
+
+	// The first event may already be taken by the delivery goroutine
+	for i := 0; i < 10; i++ {
+		a.Log(Event{Metrics: []utils.Metrics{gauge("g", 1)}})
+	}
+	assert.GreaterOrEqual(t, a.Dropped(), uint64(7))
+
+	close(sink.block)
+	require.NoError(t, a.Close())
+	assert.Equal(t, uint64(10), a.Dropped()+uint64(len(sink.events)))
+}
+
+func TestAuditor_CountsFailures(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	sink := &memSink{err: errors.New("unavailable")}
+	a := NewAuditor(16, sink)
+	a.Log(Event{})
+	a.Log(Event{})
+	require.NoError(t, a.Close())
+	assert.Equal(t, uint64(2), a.Failed())
+}
+
+func TestPending(t *testing.T) {
+	ctx, p := NewContext(context.Background(), Event{ClientIP: "10.0.0.1"})
+	assert.Same(t, p, FromContext(ctx))
+	assert.Nil(t, FromContext(context.Background()))
+
+	p.Add(gauge("a", 1))
+	e := p.Event()
+	p.Add(gauge("b", 2))
+	assert.Len(t, e.Metrics, 1)
+	assert.Len(t, p.Event().Metrics, 2)
+	assert.Equal(t, "10.0.0.1", e.ClientIP)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	when := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		s, err := OpenFileSink(path)
+		require.NoError(t, err)
+		require.NoError(t, s.Write([]Event{{Time: when, Agent: "agent-1", Metrics: []utils.Metrics{gauge("g", float64(i))}}}))
+		require.NoError(t, s.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var lines []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		lines = append(lines, e)
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, "agent-1", lines[1].Agent)
+	assert.Equal(t, 1.0, *lines[1].Metrics[0].Value)
+	assert.True(t, when.Equal(lines[0].Time))
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewHTTPSink(srv.URL, time.Second)
+	require.NoError(t, s.Write([]Event{{Path: "/updates"}, {Path: "/update"}}))
+	require.Len(t, got, 2)
+	assert.Equal(t, "/update", got[1].Path)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewHTTPSink(failing.URL, time.Second).Write([]Event{{}}))
+}
@@ -0,0 +1,82 @@
+// Package audit records accepted metric updates and delivers them to sinks.
+//
+// This file contains the JSON-lines file sink and the HTTP sink.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// FileSink appends events to a file, one JSON object per line.
+type FileSink struct {
+	file *os.File
+	buf  bytes.Buffer
+}
+
+// OpenFileSink opens path for appending, creating it if needed.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: f}, nil
+}
+
+// Write appends events to the file with a single write call.
+func (s *FileSink) Write(events []Event) error {
+	s.buf.Reset()
+	enc := json.NewEncoder(&s.buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	_, err := s.file.Write(s.buf.Bytes())
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPSink posts events to an HTTP endpoint as a JSON array.
+//
+// A batch is sent once; if the endpoint is unreachable or does not answer
+// with 2xx the batch is lost and counted as failed by the Auditor.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSink creates an HTTPSink posting to url with the given request timeout.
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// Write posts events to s.URL.
+func (s *HTTPSink) Write(events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close does nothing.
+func (s *HTTPSink) Close() error {
+	return nil
+}
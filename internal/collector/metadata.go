@@ -0,0 +1,82 @@
+// Package collector implements logic for collecting application metrics.
+//
+// This file contains built-in metadata for the metrics gathered by Collector.
+package collector
+
+import (
+	"runtime"
+	"strconv"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+)
+
+// Units used by the built-in metadata.
+const (
+	UnitBytes   = "bytes"
+	UnitNanos   = "ns"
+	UnitPercent = "percent"
+	UnitRatio   = "ratio"
+	UnitCount   = "count"
+)
+
+// runtimeMetadata describes the metrics gathered by CollectMetrics.
+var runtimeMetadata = []metadata.Metadata{
+	{ID: "Alloc", MType: "gauge", Unit: UnitBytes, Help: "Bytes of allocated heap objects."},
+	{ID: "BuckHashSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of memory in profiling bucket hash tables."},
+	{ID: "Frees", MType: "gauge", Unit: UnitCount, Help: "Cumulative count of heap objects freed."},
+	{ID: "GCCPUFraction", MType: "gauge", Unit: UnitRatio, Help: "Fraction of CPU time used by the GC since the program started."},
+	{ID: "GCSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of memory in garbage collection metadata."},
+	{ID: "HeapAlloc", MType: "gauge", Unit: UnitBytes, Help: "Bytes of allocated heap objects."},
+	{ID: "HeapIdle", MType: "gauge", Unit: UnitBytes, Help: "Bytes in idle (unused) heap spans."},
+	{ID: "HeapInuse", MType: "gauge", Unit: UnitBytes, Help: "Bytes in in-use heap spans."},
+	{ID: "HeapObjects", MType: "gauge", Unit: UnitCount, Help: "Number of allocated heap objects."},
+	{ID: "HeapReleased", MType: "gauge", Unit: UnitBytes, Help: "Bytes of physical memory returned to the OS."},
+	{ID: "HeapSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of heap memory obtained from the OS."},
+	{ID: "LastGC", MType: "gauge", Unit: UnitNanos, Help: "Time the last garbage collection finished, in nanoseconds since the Unix epoch."},
+	{ID: "Lookups", MType: "gauge", Unit: UnitCount, Help: "Number of pointer lookups performed by the runtime."},
+	{ID: "MCacheInuse", MType: "gauge", Unit: UnitBytes, Help: "Bytes of allocated mcache structures."},
+	{ID: "MCacheSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of memory obtained from the OS for mcache structures."},
+	{ID: "MSpanInuse", MType: "gauge", Unit: UnitBytes, Help: "Bytes of allocated mspan structures."},
+	{ID: "MSpanSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of memory obtained from the OS for mspan structures."},
+	{ID: "Mallocs", MType: "gauge", Unit: UnitCount, Help: "Cumulative count of heap objects allocated."},
+	{ID: "NextGC", MType: "gauge", Unit: UnitBytes, Help: "Target heap size of the next GC cycle."},
+	{ID: "NumForcedGC", MType: "gauge", Unit: UnitCount, Help: "Number of GC cycles forced by calling GC."},
+	{ID: "NumGC", MType: "gauge", Unit: UnitCount, Help: "Number of completed GC cycles."},
+	{ID: "OtherSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of miscellaneous off-heap runtime allocations."},
+	{ID: "PauseTotalNs", MType: "gauge", Unit: UnitNanos, Help: "Cumulative time spent in GC stop-the-world pauses."},
+	{ID: "StackInuse", MType: "gauge", Unit: UnitBytes, Help: "Bytes in stack spans."},
+	{ID: "StackSys", MType: "gauge", Unit: UnitBytes, Help: "Bytes of stack memory obtained from the OS."},
+	{ID: "Sys", MType: "gauge", Unit: UnitBytes, Help: "Total bytes of memory obtained from the OS."},
+	{ID: "TotalAlloc", MType: "gauge", Unit: UnitBytes, Help: "Cumulative bytes allocated for heap objects."},
+	{ID: "PollCount", MType: "counter", Unit: UnitCount, Help: "Number of times the agent collected runtime metrics."},
+	{ID: "RandomValue", MType: "gauge", Help: "Random value updated on every poll."},
+}
+
+// systemMetadata describes the fixed metrics gathered by CollectNewMetrics.
+var systemMetadata = []metadata.Metadata{
+	{ID: "TotalMemory", MType: "gauge", Unit: UnitBytes, Help: "Total amount of system memory."},
+	{ID: "FreeMemory", MType: "gauge", Unit: UnitBytes, Help: "Amount of free system memory."},
+}
+
+// BuiltinMetadata returns declarations for every metric the Collector gathers,
+// including one CPUutilization metric per logical CPU.
+func BuiltinMetadata() []metadata.Metadata {
+	result := make([]metadata.Metadata, 0, len(runtimeMetadata)+len(systemMetadata))
+	result = append(result, runtimeMetadata...)
+	result = append(result, systemMetadata...)
+
+	cpus, err := cpu.Counts(true)
+	if err != nil || cpus == 0 {
+		cpus = runtime.NumCPU()
+	}
+	for i := 0; i < cpus; i++ {
+		result = append(result, metadata.Metadata{
+			ID:    "CPUutilization" + strconv.Itoa(i),
+			MType: "gauge",
+			Unit:  UnitPercent,
+			Help:  "Utilization of logical CPU " + strconv.Itoa(i) + ".",
+		})
+	}
+	return result
+}
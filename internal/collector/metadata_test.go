@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinMetadata_CoversCollectedMetrics(t *testing.T) {
+	declared := make(map[string]string)
+	for _, md := range BuiltinMetadata() {
+		assert.NoError(t, md.Validate(), "declaration of %q is invalid", md.ID)
+		declared[md.ID] = md.MType
+	}
+
+	c := NewCollector(&sync.Map{})
+	c.CollectMetrics()
+	c.CollectNewMetrics()
+	for name, m := range c.GetAllMetrics() {
+		mType, ok := declared[name]
+		if assert.True(t, ok, "metric %q has no built-in metadata", name) {
+			assert.Equal(t, m.MType, mType, "metric %q is declared with another type", name)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/config"
 )
 
 var (
@@ -30,9 +32,28 @@ var (
 	// Key holds an optional signing key used to calculate hash of the metric payload.
 	// Can be set via flag "-k" or env var "KEY".
 	Key = flag.String("k", "", "key")
+	// KeyID names Key in the "Key-Id" header, so the server can look up a per-agent key.
+	// Can be set via flag "-key-id" or env var "KEY_ID".
+	KeyID = flag.String("key-id", "", "signing key id")
 	// RateLimit defines maximum number of concurrent requests to the server.
 	// Can be set via flag "-l" or env var "RATE_LIMIT".
 	RateLimit = flag.Int("l", 1, "rate limit")
+	// HTTPS makes the agent talk to the server over TLS.
+	// Can be set via flag "-https" or env var "HTTPS".
+	HTTPS = flag.Bool("https", false, "use https")
+	// TLSCA is a PEM bundle of CAs used to verify the server certificate instead of the system roots.
+	// Can be set via flag "-tls-ca" or env var "TLS_CA".
+	TLSCA = flag.String("tls-ca", "", "CA bundle for server certificate verification")
+	// TLSCert and TLSKey are PEM files of the client certificate presented to the server.
+	// Can be set via flags "-tls-cert"/"-tls-key" or env vars "TLS_CERT"/"TLS_KEY".
+	TLSCert = flag.String("tls-cert", "", "TLS client certificate file")
+	TLSKey  = flag.String("tls-key", "", "TLS client private key file")
+	// Compression is the Content-Encoding of compressed requests: gzip, deflate, zstd or br.
+	// Can be set via flag "-compression" or env var "COMPRESSION".
+	Compression = flag.String("compression", "gzip", "request compression: gzip, deflate, zstd or br")
+	// Token is the bearer token sent in the "Authorization" header.
+	// Can be set via flag "-token" or env var "TOKEN".
+	Token = flag.String("token", "", "API bearer token")
 
 	Loaded = false
 )
@@ -71,7 +92,14 @@ func ConfigAgent() error {
 	println("ReportInterval=", *ReportInterval)
 	println("PollInterval=", *PollInterval)
 	println("Key=", *Key)
+	println("KeyID=", *KeyID)
 	println("RateLimit=", *RateLimit)
+	println("HTTPS=", *HTTPS)
+	println("TLSCA=", *TLSCA)
+	println("TLSCert=", *TLSCert)
+	println("TLSKey=", *TLSKey)
+	println("Compression=", *Compression)
+	println("Token set=", *Token != "")
 	return nil
 }
 
@@ -80,6 +108,12 @@ type Config struct {
 	CryptoKey      string `json:"crypto_key,omitempty"`
 	ReportInterval string `json:"report_interval,omitempty"`
 	PollInterval   string `json:"poll_interval,omitempty"`
+	TLSCA          string `json:"tls_ca,omitempty"`
+	TLSCert        string `json:"tls_cert,omitempty"`
+	TLSKey         string `json:"tls_key,omitempty"`
+	Compression    string `json:"compression,omitempty"`
+	Token          string `json:"token,omitempty"`
+	HTTPS          bool   `json:"https,omitempty"`
 }
 
 func loadFromEnv() {
@@ -106,6 +140,10 @@ func loadFromEnv() {
 	if found {
 		Key = &k
 	}
+	kid, found := os.LookupEnv("KEY_ID")
+	if found {
+		KeyID = &kid
+	}
 	rl, found := os.LookupEnv("RATE_LIMIT")
 	if found {
 		i, err := strconv.Atoi(rl)
@@ -117,6 +155,33 @@ func loadFromEnv() {
 	if found {
 		CryptoKey = &cr
 	}
+	h, found := os.LookupEnv("HTTPS")
+	if found {
+		b, err := strconv.ParseBool(h)
+		if err == nil {
+			HTTPS = &b
+		}
+	}
+	ca, found := os.LookupEnv("TLS_CA")
+	if found {
+		TLSCA = &ca
+	}
+	tc, found := os.LookupEnv("TLS_CERT")
+	if found {
+		TLSCert = &tc
+	}
+	tk, found := os.LookupEnv("TLS_KEY")
+	if found {
+		TLSKey = &tk
+	}
+	comp, found := os.LookupEnv("COMPRESSION")
+	if found {
+		Compression = &comp
+	}
+	tok, found := os.LookupEnv("TOKEN")
+	if found {
+		Token = &tok
+	}
 }
 
 func LoadConfigFile() error {
@@ -166,6 +231,12 @@ func LoadConfigFile() error {
 		}
 		*PollInterval = int(dur.Seconds())
 		*CryptoKey = cfg.CryptoKey
+		config.SetFromFile(HTTPS, "https", cfg.HTTPS)
+		config.SetFromFile(TLSCA, "tls-ca", cfg.TLSCA)
+		config.SetFromFile(TLSCert, "tls-cert", cfg.TLSCert)
+		config.SetFromFile(TLSKey, "tls-key", cfg.TLSKey)
+		config.SetFromFile(Compression, "compression", cfg.Compression)
+		config.SetFromFile(Token, "token", cfg.Token)
 		Loaded = true
 	}
 	checkLoaded(ab, bb, cb, db, a, b, c, d)
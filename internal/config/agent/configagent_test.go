@@ -14,7 +14,14 @@ func resetFlags() {
 	ReportInterval = flag.Int("r", 10, "report interval")
 	PollInterval = flag.Int("p", 2, "poll interval")
 	Key = flag.String("k", "", "key")
+	KeyID = flag.String("key-id", "", "signing key id")
 	RateLimit = flag.Int("l", 1, "rate limit")
+	HTTPS = flag.Bool("https", false, "use https")
+	TLSCA = flag.String("tls-ca", "", "CA bundle for server certificate verification")
+	TLSCert = flag.String("tls-cert", "", "TLS client certificate file")
+	TLSKey = flag.String("tls-key", "", "TLS client private key file")
+	Compression = flag.String("compression", "gzip", "request compression")
+	Token = flag.String("token", "", "API bearer token")
 }
 
 func setEnv(t *testing.T, key, value string) {
@@ -188,3 +195,19 @@ func TestConfigAgent_BadConfig4(t *testing.T) {
 		assert.Fail(t, "ConfigAgent() error shoud be not nil")
 	}
 }
+
+func TestConfigAgent_Token(t *testing.T) {
+	resetFlags()
+	*ConfigPath = ""
+	unsetEnv(t, "TOKEN")
+	os.Args = []string{"cmd", "-token=flag_token"}
+	ConfigAgent()
+	assert.Equal(t, "flag_token", *Token)
+
+	resetFlags()
+	setEnv(t, "TOKEN", "env_token")
+	defer unsetEnv(t, "TOKEN")
+	os.Args = []string{"cmd", "-token=flag_token"}
+	ConfigAgent()
+	assert.Equal(t, "env_token", *Token)
+}
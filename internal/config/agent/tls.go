@@ -0,0 +1,67 @@
+// Package agent implements configuration logic for the metrics agent.
+//
+// This file builds the TLS configuration used to reach the server.
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSConfig builds the client TLS configuration from a PEM CA bundle used to
+// verify the server and an optional client certificate with its key.
+//
+// Returns nil without error if nothing is set, so the system roots and no
+// client certificate are used.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pemData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both tls-cert and tls-key must be set for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s with key %s: %w", certFile, keyFile, err)
+		}
+		if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+			return nil, fmt.Errorf("client certificate %s expired at %s", certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// BaseURL returns the server URL built from EndpointAgent.
+//
+// An address that already has a scheme is used as is. Otherwise https is
+// chosen when HTTPS is set or any TLS option is given, and http if not.
+func BaseURL() string {
+	if strings.Contains(*EndpointAgent, "://") {
+		return *EndpointAgent
+	}
+	if *HTTPS || *TLSCA != "" || *TLSCert != "" {
+		return "https://" + *EndpointAgent
+	}
+	return "http://" + *EndpointAgent
+}
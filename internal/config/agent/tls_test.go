@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig_ServerCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	cfg, err := TLSConfig(caFile, "", "")
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a pem"), 0o600))
+
+	cfg, err := TLSConfig("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	_, err = TLSConfig(garbage, "", "")
+	assert.ErrorContains(t, err, "no PEM certificates")
+
+	_, err = TLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+	assert.ErrorContains(t, err, "read CA bundle")
+
+	_, err = TLSConfig("", garbage, "")
+	assert.ErrorContains(t, err, "both tls-cert and tls-key")
+
+	_, err = TLSConfig("", garbage, garbage)
+	assert.ErrorContains(t, err, "load client certificate")
+}
+
+func TestBaseURL(t *testing.T) {
+	resetFlags()
+	assert.Equal(t, "http://localhost:8080", BaseURL())
+
+	*HTTPS = true
+	assert.Equal(t, "https://localhost:8080", BaseURL())
+
+	*HTTPS = false
+	*TLSCA = "ca.pem"
+	assert.Equal(t, "https://localhost:8080", BaseURL())
+
+	*EndpointAgent = "http://metrics:8080"
+	assert.Equal(t, "http://metrics:8080", BaseURL())
+}
@@ -0,0 +1,23 @@
+// Package config holds the helpers shared by the server and agent
+// configuration packages.
+package config
+
+import "flag"
+
+// SetFromFile assigns the config file value to dst unless it is empty or
+// the flag was given on the command line, so flags keep precedence over the file.
+func SetFromFile[T comparable](dst *T, flagName string, value T) {
+	var zero T
+	if value == zero {
+		return
+	}
+	isSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == flagName {
+			isSet = true
+		}
+	})
+	if !isSet {
+		*dst = value
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFromFile(t *testing.T) {
+	old := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = old })
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	given := flag.String("given", "flag", "")
+	unset := flag.String("unset", "default", "")
+	empty := flag.String("empty", "default", "")
+	require.NoError(t, flag.CommandLine.Parse([]string{"-given=flag"}))
+
+	SetFromFile(given, "given", "file")
+	SetFromFile(unset, "unset", "file")
+	SetFromFile(empty, "empty", "")
+
+	assert.Equal(t, "flag", *given, "flags take precedence over the file")
+	assert.Equal(t, "file", *unset)
+	assert.Equal(t, "default", *empty, "empty file values are ignored")
+}
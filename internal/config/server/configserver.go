@@ -12,8 +12,10 @@ import (
 	"flag"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/stepanov-ds/ya-metrics/internal/config"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"go.uber.org/zap"
 )
@@ -42,10 +44,175 @@ var (
 	// Key holds an optional signing key used to verify metric payloads.
 	// Can be set via flag "-k" or env var "KEY".
 	Key = flag.String("k", "", "key")
-	// Crypto hold a private key
+	// HashKeysFile points to a JSON array of SigningKey objects accepted in
+	// addition to Key; it allows key rotation and per-agent keys.
+	// Can be set via flag "-hash-keys-file" or env var "HASH_KEYS_FILE"; reloaded on SIGHUP.
+	HashKeysFile = flag.String("hash-keys-file", "", "HMAC signing keys file")
+	// CryptoKey lists the private keys used to decrypt request bodies: a
+	// comma-separated list of PEM files and directories (see LoadKeyring).
+	// Can be set via flag "-y"/"-crypto-key" or env var "CRYPTO_KEY"; reloaded on SIGHUP.
 	CryptoKey  = flag.String("y", "private_key.pem", "crypto key")
 	ConfigFile = flag.String("c", "", "config file")
-	Loaded     = false
+	// UpdatesMode selects how /updates treats invalid items: "atomic" rejects
+	// the whole batch, "partial" stores valid items and answers 207 Multi-Status.
+	// Can be set via flag "-updates-mode" or env var "UPDATES_MODE".
+	UpdatesMode = flag.String("updates-mode", UpdatesModeAtomic, "updates mode: atomic or partial")
+	// MetadataFile points to a JSON array of metric declarations loaded on startup.
+	// Can be set via flag "-metadata-file" or env var "METADATA_FILE".
+	MetadataFile = flag.String("metadata-file", "", "metric metadata file")
+	// HistoryRetention defines how long samples are kept to derive rates and deltas.
+	// A value of 0 disables metric history.
+	// Can be set via flag "-history-retention" or env var "HISTORY_RETENTION".
+	HistoryRetention = flag.Duration("history-retention", 0, "metric history retention")
+	// ReplayWindow is the allowed clock skew of signed and encrypted requests.
+	// When positive, such requests must carry a timestamp and a nonce and
+	// duplicates are rejected; 0 disables replay protection.
+	// Can be set via flag "-replay-window" or env var "REPLAY_WINDOW".
+	ReplayWindow = flag.Duration("replay-window", 0, "allowed clock skew of signed requests, 0 disables replay protection")
+	// ReplayCacheSize bounds the number of remembered request nonces.
+	// Can be set via flag "-replay-cache-size" or env var "REPLAY_CACHE_SIZE".
+	ReplayCacheSize = flag.Int("replay-cache-size", 100000, "max remembered request nonces")
+	// MaxBodySize limits the size of a request body in bytes, both as received
+	// and after decompression; larger bodies are rejected with 413.
+	// Can be set via flag "-max-body-size" or env var "MAX_BODY_SIZE".
+	MaxBodySize = flag.Int64("max-body-size", 10<<20, "max decompressed request body size in bytes")
+	// MaxImportSize limits the size of a dump loaded by /admin/import in
+	// bytes, in place of MaxBodySize; larger dumps are rejected with 413.
+	// Without an admin listener, the dump as received is still bounded by
+	// MaxBodySize.
+	// Can be set via flag "-max-import-size" or env var "MAX_IMPORT_SIZE".
+	MaxImportSize = flag.Int64("max-import-size", 256<<20, "max decompressed import dump size in bytes")
+	// EncryptionRead and EncryptionWrite are the encryption policies of the
+	// read and write endpoints when a private key is set: "require" accepts
+	// only encrypted bodies, "allow" decrypts the requests sent with the
+	// encrypted Content-Type, "off" accepts only plaintext.
+	// Can be set via flags "-encryption-read"/"-encryption-write" or env vars
+	// "ENCRYPTION_READ"/"ENCRYPTION_WRITE".
+	EncryptionRead  = flag.String("encryption-read", "allow", "encryption policy of read endpoints: require, allow or off")
+	EncryptionWrite = flag.String("encryption-write", "require", "encryption policy of write endpoints: require, allow or off")
+	// AcceptLegacyV1 defines whether encrypted bodies of envelope version 1
+	// (RSA PKCS#1 v1.5), or without a version, are still accepted. It is on
+	// for agents that predate version 2 and will be turned off by default.
+	// Can be set via flag "-accept-legacy-v1" or env var "ACCEPT_LEGACY_V1".
+	AcceptLegacyV1 = flag.Bool("accept-legacy-v1", true, "accept v1 (PKCS#1 v1.5) encrypted bodies")
+	// TLSCert and TLSKey are PEM files of the server certificate and its key.
+	// When both are set the server serves HTTPS.
+	// Can be set via flags "-tls-cert"/"-tls-key" or env vars "TLS_CERT"/"TLS_KEY".
+	TLSCert = flag.String("tls-cert", "", "TLS certificate file")
+	TLSKey  = flag.String("tls-key", "", "TLS private key file")
+	// TLSClientCA is a PEM bundle of CAs; when set, clients must present a certificate signed by one of them.
+	// Can be set via flag "-tls-client-ca" or env var "TLS_CLIENT_CA".
+	TLSClientCA = flag.String("tls-client-ca", "", "CA bundle for client certificate verification")
+	// AdminAddress is an optional "host:port" of a separate listener serving
+	// the health checks and pprof over plain HTTP; when set they are removed
+	// from EndpointServer.
+	// Can be set via flag "-admin-address" or env var "ADMIN_ADDRESS".
+	AdminAddress = flag.String("admin-address", "", "admin endpoint for health checks and pprof")
+	// TokensFile points to a JSON array of Token objects accepted as bearer
+	// tokens in addition to the "tokens" of the config file. When no token
+	// is configured the API does not require authentication.
+	// Can be set via flag "-tokens-file" or env var "TOKENS_FILE"; reloaded on SIGHUP.
+	TokensFile = flag.String("tokens-file", "", "API tokens file")
+	// AuditFile is a file the audit events of accepted updates are appended
+	// to as JSON lines. Can be set via flag "-audit-file" or env var "AUDIT_FILE".
+	AuditFile = flag.String("audit-file", "", "audit log file")
+	// AuditURL is an HTTP endpoint the audit events are posted to.
+	// Can be set via flag "-audit-url" or env var "AUDIT_URL".
+	AuditURL = flag.String("audit-url", "", "audit log endpoint")
+	// AuditQueueSize bounds the number of audit events waiting for delivery;
+	// events beyond it are dropped.
+	// Can be set via flag "-audit-queue-size" or env var "AUDIT_QUEUE_SIZE".
+	AuditQueueSize = flag.Int("audit-queue-size", 1024, "max audit events waiting for delivery")
+	// LogLevel, LogFormat and LogOutput configure the server log: the minimum
+	// level, "json" or "console" and "stderr", "stdout" or a file path.
+	// Can be set via flags "-log-level"/"-log-format"/"-log-output" or env vars
+	// "LOG_LEVEL"/"LOG_FORMAT"/"LOG_OUTPUT".
+	LogLevel  = flag.String("log-level", "info", "log level")
+	LogFormat = flag.String("log-format", "json", "log format: json or console")
+	LogOutput = flag.String("log-output", "stderr", "log output: stderr, stdout or a file")
+	// LogHeaders and LogBodies enable logging of request headers, with
+	// credentials redacted, and of request and response bodies, cut to
+	// LogBodyLimit bytes.
+	// Can be set via flags "-log-headers"/"-log-bodies"/"-log-body-limit" or env vars
+	// "LOG_HEADERS"/"LOG_BODIES"/"LOG_BODY_LIMIT".
+	LogHeaders   = flag.Bool("log-headers", false, "log request headers")
+	LogBodies    = flag.Bool("log-bodies", false, "log request and response bodies")
+	LogBodyLimit = flag.Int("log-body-limit", 1024, "max logged bytes of a body")
+	// LogSample lists routes logged only in part, as route=rate pairs such
+	// as "/updates=0.01"; failed requests are always logged.
+	// Can be set via flag "-log-sample" or env var "LOG_SAMPLE".
+	LogSample = flag.String("log-sample", "", "sampled routes, e.g. /updates=0.01")
+	// SelfMetricsInterval defines how often the server writes its own
+	// metrics into the storage under the "_server." prefix; 0 disables it.
+	// They are always served by the admin endpoint /internal/metrics.
+	// Can be set via flag "-self-metrics-interval" or env var "SELF_METRICS_INTERVAL".
+	SelfMetricsInterval = flag.Duration("self-metrics-interval", 0, "interval of writing server metrics into storage, 0 disables it")
+	// RateLimitBy selects the client key of the rate limits: "ip", "token"
+	// (the name of the bearer token) or "agent" (the ID of the signing key
+	// a request was verified with, then the token name). Requests without
+	// the key fall back to the IP. Only /updates verifies a signature, and
+	// only once the request rate is checked.
+	// Can be set via flag "-rate-limit-by" or env var "RATE_LIMIT_BY".
+	RateLimitBy = flag.String("rate-limit-by", RateLimitByIP, "rate limit key: ip, token or agent")
+	// TrustedProxies lists, separated by commas, the addresses or CIDRs of
+	// the reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// believed. Otherwise the client IP is the address of the peer.
+	// Can be set via flag "-trusted-proxies" or env var "TRUSTED_PROXIES".
+	TrustedProxies = flag.String("trusted-proxies", "", "comma-separated addresses or CIDRs of trusted reverse proxies")
+	// RateLimitRPS and RateLimitBurst limit the requests of a client per
+	// second, with RateLimitBurst requests allowed at once; 0 disables the
+	// limit, a zero burst means RateLimitRPS rounded up.
+	// Can be set via flags "-rate-limit-rps"/"-rate-limit-burst" or env vars
+	// "RATE_LIMIT_RPS"/"RATE_LIMIT_BURST".
+	RateLimitRPS   = flag.Float64("rate-limit-rps", 0, "requests per second per client, 0 disables the limit")
+	RateLimitBurst = flag.Int("rate-limit-burst", 0, "requests per client allowed at once")
+	// RateLimitMetrics limits the metrics a client may store per minute;
+	// 0 disables the limit.
+	// Can be set via flag "-rate-limit-metrics" or env var "RATE_LIMIT_METRICS".
+	RateLimitMetrics = flag.Int("rate-limit-metrics", 0, "metrics per minute per client, 0 disables the limit")
+	// MaxSeries limits the distinct metrics the server stores; updates that
+	// would create a new metric over the limit are rejected. 0 disables it.
+	// Can be set via flag "-max-series" or env var "MAX_SERIES".
+	MaxSeries = flag.Int("max-series", 0, "distinct metrics stored, 0 disables the limit")
+	// MaxSeriesPerClient limits the distinct metrics one client may create;
+	// clients are identified as selected by RateLimitBy. 0 disables it.
+	// Can be set via flag "-max-series-per-client" or env var "MAX_SERIES_PER_CLIENT".
+	MaxSeriesPerClient = flag.Int("max-series-per-client", 0, "distinct metrics created per client, 0 disables the limit")
+	// CacheTTL defines how long metrics read from the database are cached;
+	// 0 disables the cache. It is not used in file mode.
+	// Can be set via flag "-cache-ttl" or env var "CACHE_TTL".
+	CacheTTL = flag.Duration("cache-ttl", 0, "TTL of the metric cache in front of the database, 0 disables it")
+	// CacheSize limits the number of metrics held by the cache.
+	// Can be set via flag "-cache-size" or env var "CACHE_SIZE".
+	CacheSize = flag.Int("cache-size", 10000, "metrics held by the cache in front of the database")
+	// ConfigTokens holds the bearer tokens listed in the config file.
+	ConfigTokens []Token
+	Loaded       = false
+)
+
+// Client keys of the rate limits, see RateLimitBy.
+const (
+	RateLimitByIP    = "ip"
+	RateLimitByToken = "token"
+	RateLimitByAgent = "agent"
+)
+
+// TrustedProxyList returns the entries of TrustedProxies, or nil if it is empty.
+func TrustedProxyList() []string {
+	var proxies []string
+	for _, p := range strings.Split(*TrustedProxies, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+const (
+	// UpdatesModeAtomic rejects a whole /updates batch if any item is invalid.
+	UpdatesModeAtomic = "atomic"
+	// UpdatesModePartial stores valid /updates items and reports rejected ones.
+	UpdatesModePartial = "partial"
 )
 
 // ConfigServer parses command-line flags and environment variables
@@ -89,17 +256,91 @@ func ConfigServer() error {
 		zap.String("DatabaseDSN", *DatabaseDSN),
 		zap.Bool("IsDB", IsDB),
 		zap.String("Key", *Key),
+		zap.String("HashKeysFile", *HashKeysFile),
+		zap.String("UpdatesMode", *UpdatesMode),
+		zap.String("MetadataFile", *MetadataFile),
+		zap.Duration("HistoryRetention", *HistoryRetention),
+		zap.Duration("ReplayWindow", *ReplayWindow),
+		zap.Int("ReplayCacheSize", *ReplayCacheSize),
+		zap.Int64("MaxBodySize", *MaxBodySize),
+		zap.Int64("MaxImportSize", *MaxImportSize),
+		zap.String("EncryptionRead", *EncryptionRead),
+		zap.String("EncryptionWrite", *EncryptionWrite),
+		zap.Bool("AcceptLegacyV1", *AcceptLegacyV1),
+		zap.String("TLSCert", *TLSCert),
+		zap.String("TLSKey", *TLSKey),
+		zap.String("TLSClientCA", *TLSClientCA),
+		zap.String("AdminAddress", *AdminAddress),
+		zap.String("TokensFile", *TokensFile),
+		zap.Int("ConfigTokens", len(ConfigTokens)),
+		zap.String("AuditFile", *AuditFile),
+		zap.String("AuditURL", *AuditURL),
+		zap.Int("AuditQueueSize", *AuditQueueSize),
+		zap.String("LogLevel", *LogLevel),
+		zap.String("LogFormat", *LogFormat),
+		zap.String("LogOutput", *LogOutput),
+		zap.Bool("LogHeaders", *LogHeaders),
+		zap.Bool("LogBodies", *LogBodies),
+		zap.Int("LogBodyLimit", *LogBodyLimit),
+		zap.String("LogSample", *LogSample),
+		zap.Duration("SelfMetricsInterval", *SelfMetricsInterval),
+		zap.String("RateLimitBy", *RateLimitBy),
+		zap.String("TrustedProxies", *TrustedProxies),
+		zap.Float64("RateLimitRPS", *RateLimitRPS),
+		zap.Int("RateLimitBurst", *RateLimitBurst),
+		zap.Int("RateLimitMetrics", *RateLimitMetrics),
+		zap.Int("MaxSeries", *MaxSeries),
+		zap.Int("MaxSeriesPerClient", *MaxSeriesPerClient),
+		zap.Duration("CacheTTL", *CacheTTL),
+		zap.Int("CacheSize", *CacheSize),
 	)
 	return nil
 }
 
 type Config struct {
-	Address       string `json:"address,omitempty"`
-	StoreFile     string `json:"store_file,omitempty"`
-	DatabaseDSN   string `json:"database_dsn,omitempty"`
-	CryptoKey     string `json:"crypto_key,omitempty"`
-	StoreInterval string `json:"store_interval,omitempty"`
-	Restore       bool   `json:"restore,omitempty"`
+	Address            string  `json:"address,omitempty"`
+	StoreFile          string  `json:"store_file,omitempty"`
+	DatabaseDSN        string  `json:"database_dsn,omitempty"`
+	CryptoKey          string  `json:"crypto_key,omitempty"`
+	StoreInterval      string  `json:"store_interval,omitempty"`
+	UpdatesMode        string  `json:"updates_mode,omitempty"`
+	MetadataFile       string  `json:"metadata_file,omitempty"`
+	HistoryRetention   string  `json:"history_retention,omitempty"`
+	HashKeysFile       string  `json:"hash_keys_file,omitempty"`
+	ReplayWindow       string  `json:"replay_window,omitempty"`
+	ReplayCacheSize    int     `json:"replay_cache_size,omitempty"`
+	MaxBodySize        int64   `json:"max_body_size,omitempty"`
+	MaxImportSize      int64   `json:"max_import_size,omitempty"`
+	EncryptionRead     string  `json:"encryption_read,omitempty"`
+	EncryptionWrite    string  `json:"encryption_write,omitempty"`
+	AcceptLegacyV1     *bool   `json:"accept_legacy_v1,omitempty"`
+	TLSCert            string  `json:"tls_cert,omitempty"`
+	TLSKey             string  `json:"tls_key,omitempty"`
+	TLSClientCA        string  `json:"tls_client_ca,omitempty"`
+	AdminAddress       string  `json:"admin_address,omitempty"`
+	TokensFile         string  `json:"tokens_file,omitempty"`
+	Tokens             []Token `json:"tokens,omitempty"`
+	AuditFile          string  `json:"audit_file,omitempty"`
+	AuditURL           string  `json:"audit_url,omitempty"`
+	AuditQueueSize     int     `json:"audit_queue_size,omitempty"`
+	LogLevel           string  `json:"log_level,omitempty"`
+	LogFormat          string  `json:"log_format,omitempty"`
+	LogOutput          string  `json:"log_output,omitempty"`
+	LogBodyLimit       int     `json:"log_body_limit,omitempty"`
+	LogSample          string  `json:"log_sample,omitempty"`
+	LogHeaders         bool    `json:"log_headers,omitempty"`
+	LogBodies          bool    `json:"log_bodies,omitempty"`
+	SelfMetrics        string  `json:"self_metrics_interval,omitempty"`
+	RateLimitBy        string  `json:"rate_limit_by,omitempty"`
+	TrustedProxies     string  `json:"trusted_proxies,omitempty"`
+	RateLimitRPS       float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst     int     `json:"rate_limit_burst,omitempty"`
+	RateLimitMetrics   int     `json:"rate_limit_metrics,omitempty"`
+	MaxSeries          int     `json:"max_series,omitempty"`
+	MaxSeriesPerClient int     `json:"max_series_per_client,omitempty"`
+	CacheTTL           string  `json:"cache_ttl,omitempty"`
+	CacheSize          int     `json:"cache_size,omitempty"`
+	Restore            bool    `json:"restore,omitempty"`
 }
 
 func loadFromEnv() {
@@ -142,6 +383,204 @@ func loadFromEnv() {
 	if found {
 		CryptoKey = &cr
 	}
+	um, found := os.LookupEnv("UPDATES_MODE")
+	if found {
+		UpdatesMode = &um
+	}
+	mf, found := os.LookupEnv("METADATA_FILE")
+	if found {
+		MetadataFile = &mf
+	}
+	hr, found := os.LookupEnv("HISTORY_RETENTION")
+	if found {
+		d, err := time.ParseDuration(hr)
+		if err == nil && d >= 0 {
+			HistoryRetention = &d
+		}
+	}
+	hkf, found := os.LookupEnv("HASH_KEYS_FILE")
+	if found {
+		HashKeysFile = &hkf
+	}
+	rw, found := os.LookupEnv("REPLAY_WINDOW")
+	if found {
+		d, err := time.ParseDuration(rw)
+		if err == nil && d >= 0 {
+			ReplayWindow = &d
+		}
+	}
+	rcs, found := os.LookupEnv("REPLAY_CACHE_SIZE")
+	if found {
+		i, err := strconv.Atoi(rcs)
+		if err == nil && i > 0 {
+			ReplayCacheSize = &i
+		}
+	}
+	mbs, found := os.LookupEnv("MAX_BODY_SIZE")
+	if found {
+		i, err := strconv.ParseInt(mbs, 10, 64)
+		if err == nil && i > 0 {
+			MaxBodySize = &i
+		}
+	}
+	mis, found := os.LookupEnv("MAX_IMPORT_SIZE")
+	if found {
+		i, err := strconv.ParseInt(mis, 10, 64)
+		if err == nil && i > 0 {
+			MaxImportSize = &i
+		}
+	}
+	alv, found := os.LookupEnv("ACCEPT_LEGACY_V1")
+	if found {
+		b, err := strconv.ParseBool(alv)
+		if err == nil {
+			AcceptLegacyV1 = &b
+		}
+	}
+	er, found := os.LookupEnv("ENCRYPTION_READ")
+	if found {
+		EncryptionRead = &er
+	}
+	ew, found := os.LookupEnv("ENCRYPTION_WRITE")
+	if found {
+		EncryptionWrite = &ew
+	}
+	tc, found := os.LookupEnv("TLS_CERT")
+	if found {
+		TLSCert = &tc
+	}
+	tk, found := os.LookupEnv("TLS_KEY")
+	if found {
+		TLSKey = &tk
+	}
+	tca, found := os.LookupEnv("TLS_CLIENT_CA")
+	if found {
+		TLSClientCA = &tca
+	}
+	aa, found := os.LookupEnv("ADMIN_ADDRESS")
+	if found {
+		AdminAddress = &aa
+	}
+	tf, found := os.LookupEnv("TOKENS_FILE")
+	if found {
+		TokensFile = &tf
+	}
+	af, found := os.LookupEnv("AUDIT_FILE")
+	if found {
+		AuditFile = &af
+	}
+	au, found := os.LookupEnv("AUDIT_URL")
+	if found {
+		AuditURL = &au
+	}
+	aqs, found := os.LookupEnv("AUDIT_QUEUE_SIZE")
+	if found {
+		i, err := strconv.Atoi(aqs)
+		if err == nil && i > 0 {
+			AuditQueueSize = &i
+		}
+	}
+	ll, found := os.LookupEnv("LOG_LEVEL")
+	if found {
+		LogLevel = &ll
+	}
+	lf, found := os.LookupEnv("LOG_FORMAT")
+	if found {
+		LogFormat = &lf
+	}
+	lo, found := os.LookupEnv("LOG_OUTPUT")
+	if found {
+		LogOutput = &lo
+	}
+	lh, found := os.LookupEnv("LOG_HEADERS")
+	if found {
+		b, err := strconv.ParseBool(lh)
+		if err == nil {
+			LogHeaders = &b
+		}
+	}
+	lb, found := os.LookupEnv("LOG_BODIES")
+	if found {
+		b, err := strconv.ParseBool(lb)
+		if err == nil {
+			LogBodies = &b
+		}
+	}
+	lbl, found := os.LookupEnv("LOG_BODY_LIMIT")
+	if found {
+		i, err := strconv.Atoi(lbl)
+		if err == nil && i > 0 {
+			LogBodyLimit = &i
+		}
+	}
+	ls, found := os.LookupEnv("LOG_SAMPLE")
+	if found {
+		LogSample = &ls
+	}
+	smi, found := os.LookupEnv("SELF_METRICS_INTERVAL")
+	if found {
+		d, err := time.ParseDuration(smi)
+		if err == nil && d >= 0 {
+			SelfMetricsInterval = &d
+		}
+	}
+	rlb, found := os.LookupEnv("RATE_LIMIT_BY")
+	if found {
+		RateLimitBy = &rlb
+	}
+	tp, found := os.LookupEnv("TRUSTED_PROXIES")
+	if found {
+		TrustedProxies = &tp
+	}
+	rlr, found := os.LookupEnv("RATE_LIMIT_RPS")
+	if found {
+		f, err := strconv.ParseFloat(rlr, 64)
+		if err == nil && f >= 0 {
+			RateLimitRPS = &f
+		}
+	}
+	rlbu, found := os.LookupEnv("RATE_LIMIT_BURST")
+	if found {
+		i, err := strconv.Atoi(rlbu)
+		if err == nil && i >= 0 {
+			RateLimitBurst = &i
+		}
+	}
+	rlm, found := os.LookupEnv("RATE_LIMIT_METRICS")
+	if found {
+		i, err := strconv.Atoi(rlm)
+		if err == nil && i >= 0 {
+			RateLimitMetrics = &i
+		}
+	}
+	ms, found := os.LookupEnv("MAX_SERIES")
+	if found {
+		i, err := strconv.Atoi(ms)
+		if err == nil && i >= 0 {
+			MaxSeries = &i
+		}
+	}
+	mspc, found := os.LookupEnv("MAX_SERIES_PER_CLIENT")
+	if found {
+		i, err := strconv.Atoi(mspc)
+		if err == nil && i >= 0 {
+			MaxSeriesPerClient = &i
+		}
+	}
+	ct, found := os.LookupEnv("CACHE_TTL")
+	if found {
+		d, err := time.ParseDuration(ct)
+		if err == nil && d >= 0 {
+			CacheTTL = &d
+		}
+	}
+	cs, found := os.LookupEnv("CACHE_SIZE")
+	if found {
+		i, err := strconv.Atoi(cs)
+		if err == nil && i > 0 {
+			CacheSize = &i
+		}
+	}
 }
 
 func LoadConfigFile() error {
@@ -172,11 +611,73 @@ func LoadConfigFile() error {
 			IsDB = true
 		}
 		*CryptoKey = cfg.CryptoKey
+		config.SetFromFile(UpdatesMode, "updates-mode", cfg.UpdatesMode)
+		config.SetFromFile(MetadataFile, "metadata-file", cfg.MetadataFile)
+		if cfg.HistoryRetention != "" {
+			dur, err = time.ParseDuration(cfg.HistoryRetention)
+			if err != nil {
+				return err
+			}
+			config.SetFromFile(HistoryRetention, "history-retention", dur)
+		}
+		config.SetFromFile(HashKeysFile, "hash-keys-file", cfg.HashKeysFile)
+		if cfg.ReplayWindow != "" {
+			dur, err = time.ParseDuration(cfg.ReplayWindow)
+			if err != nil {
+				return err
+			}
+			config.SetFromFile(ReplayWindow, "replay-window", dur)
+		}
+		config.SetFromFile(ReplayCacheSize, "replay-cache-size", cfg.ReplayCacheSize)
+		config.SetFromFile(MaxBodySize, "max-body-size", cfg.MaxBodySize)
+		config.SetFromFile(MaxImportSize, "max-import-size", cfg.MaxImportSize)
+		config.SetFromFile(EncryptionRead, "encryption-read", cfg.EncryptionRead)
+		config.SetFromFile(EncryptionWrite, "encryption-write", cfg.EncryptionWrite)
+		config.SetFromFile(&AcceptLegacyV1, "accept-legacy-v1", cfg.AcceptLegacyV1)
+		config.SetFromFile(TLSCert, "tls-cert", cfg.TLSCert)
+		config.SetFromFile(TLSKey, "tls-key", cfg.TLSKey)
+		config.SetFromFile(TLSClientCA, "tls-client-ca", cfg.TLSClientCA)
+		config.SetFromFile(AdminAddress, "admin-address", cfg.AdminAddress)
+		config.SetFromFile(TokensFile, "tokens-file", cfg.TokensFile)
+		ConfigTokens = cfg.Tokens
+		config.SetFromFile(AuditFile, "audit-file", cfg.AuditFile)
+		config.SetFromFile(AuditURL, "audit-url", cfg.AuditURL)
+		config.SetFromFile(AuditQueueSize, "audit-queue-size", cfg.AuditQueueSize)
+		config.SetFromFile(LogLevel, "log-level", cfg.LogLevel)
+		config.SetFromFile(LogFormat, "log-format", cfg.LogFormat)
+		config.SetFromFile(LogOutput, "log-output", cfg.LogOutput)
+		config.SetFromFile(LogHeaders, "log-headers", cfg.LogHeaders)
+		config.SetFromFile(LogBodies, "log-bodies", cfg.LogBodies)
+		config.SetFromFile(LogBodyLimit, "log-body-limit", cfg.LogBodyLimit)
+		config.SetFromFile(LogSample, "log-sample", cfg.LogSample)
+		if cfg.SelfMetrics != "" {
+			dur, err = time.ParseDuration(cfg.SelfMetrics)
+			if err != nil {
+				return err
+			}
+			config.SetFromFile(SelfMetricsInterval, "self-metrics-interval", dur)
+		}
+		config.SetFromFile(RateLimitBy, "rate-limit-by", cfg.RateLimitBy)
+		config.SetFromFile(TrustedProxies, "trusted-proxies", cfg.TrustedProxies)
+		config.SetFromFile(RateLimitRPS, "rate-limit-rps", cfg.RateLimitRPS)
+		config.SetFromFile(RateLimitBurst, "rate-limit-burst", cfg.RateLimitBurst)
+		config.SetFromFile(RateLimitMetrics, "rate-limit-metrics", cfg.RateLimitMetrics)
+		config.SetFromFile(MaxSeries, "max-series", cfg.MaxSeries)
+		config.SetFromFile(MaxSeriesPerClient, "max-series-per-client", cfg.MaxSeriesPerClient)
+		if cfg.CacheTTL != "" {
+			dur, err = time.ParseDuration(cfg.CacheTTL)
+			if err != nil {
+				return err
+			}
+			config.SetFromFile(CacheTTL, "cache-ttl", dur)
+		}
+		config.SetFromFile(CacheSize, "cache-size", cfg.CacheSize)
 		Loaded = true
 	}
 	checkLoaded(a, b, c, d, e, f, ab, bb, cb, db, eb, fb)
 	return nil
 }
+
 func storeParsed() (string, int, string, string, string, bool, bool, bool, bool, bool, bool, bool) {
 	var a, c, d, e string
 	var b int
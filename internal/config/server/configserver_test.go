@@ -5,6 +5,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stretchr/testify/assert"
@@ -20,6 +21,43 @@ func resetFlags() {
 	Restore = flag.Bool("r", true, "restore")
 	DatabaseDSN = flag.String("d", "", "database_DSN")
 	Key = flag.String("k", "", "key")
+	HashKeysFile = flag.String("hash-keys-file", "", "HMAC signing keys file")
+	ReplayWindow = flag.Duration("replay-window", 0, "allowed clock skew of signed requests")
+	ReplayCacheSize = flag.Int("replay-cache-size", 100000, "max remembered request nonces")
+	MaxBodySize = flag.Int64("max-body-size", 10<<20, "max decompressed request body size in bytes")
+	MaxImportSize = flag.Int64("max-import-size", 256<<20, "max decompressed import dump size in bytes")
+	EncryptionRead = flag.String("encryption-read", "allow", "encryption policy of read endpoints: require, allow or off")
+	EncryptionWrite = flag.String("encryption-write", "require", "encryption policy of write endpoints: require, allow or off")
+	AcceptLegacyV1 = flag.Bool("accept-legacy-v1", true, "accept v1 (PKCS#1 v1.5) encrypted bodies")
+	UpdatesMode = flag.String("updates-mode", UpdatesModeAtomic, "updates mode")
+	MetadataFile = flag.String("metadata-file", "", "metric metadata file")
+	HistoryRetention = flag.Duration("history-retention", 0, "metric history retention")
+	TLSCert = flag.String("tls-cert", "", "TLS certificate file")
+	TLSKey = flag.String("tls-key", "", "TLS private key file")
+	TLSClientCA = flag.String("tls-client-ca", "", "CA bundle for client certificate verification")
+	AdminAddress = flag.String("admin-address", "", "admin endpoint for health checks and pprof")
+	TokensFile = flag.String("tokens-file", "", "API tokens file")
+	AuditFile = flag.String("audit-file", "", "audit log file")
+	AuditURL = flag.String("audit-url", "", "audit log endpoint")
+	AuditQueueSize = flag.Int("audit-queue-size", 1024, "max audit events waiting for delivery")
+	LogLevel = flag.String("log-level", "info", "log level")
+	LogFormat = flag.String("log-format", "json", "log format: json or console")
+	LogOutput = flag.String("log-output", "stderr", "log output: stderr, stdout or a file")
+	LogHeaders = flag.Bool("log-headers", false, "log request headers")
+	LogBodies = flag.Bool("log-bodies", false, "log request and response bodies")
+	LogBodyLimit = flag.Int("log-body-limit", 1024, "max logged bytes of a body")
+	LogSample = flag.String("log-sample", "", "sampled routes, e.g. /updates=0.01")
+	SelfMetricsInterval = flag.Duration("self-metrics-interval", 0, "interval of writing server metrics into storage")
+	RateLimitBy = flag.String("rate-limit-by", RateLimitByIP, "rate limit key: ip, token or agent")
+	TrustedProxies = flag.String("trusted-proxies", "", "comma-separated addresses or CIDRs of trusted reverse proxies")
+	RateLimitRPS = flag.Float64("rate-limit-rps", 0, "requests per second per client, 0 disables the limit")
+	RateLimitBurst = flag.Int("rate-limit-burst", 0, "requests per client allowed at once")
+	RateLimitMetrics = flag.Int("rate-limit-metrics", 0, "metrics per minute per client, 0 disables the limit")
+	MaxSeries = flag.Int("max-series", 0, "distinct metrics stored, 0 disables the limit")
+	MaxSeriesPerClient = flag.Int("max-series-per-client", 0, "distinct metrics created per client, 0 disables the limit")
+	CacheTTL = flag.Duration("cache-ttl", 0, "TTL of the metric cache in front of the database, 0 disables it")
+	CacheSize = flag.Int("cache-size", 10000, "metrics held by the cache in front of the database")
+	ConfigTokens = nil
 	IsDB = false
 }
 
@@ -182,3 +220,179 @@ func TestConfigServer_Config(t *testing.T) {
 	assert.False(t, IsDB)
 	assert.Empty(t, *Key)
 }
+
+func TestConfigServer_UpdatesMode(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "UPDATES_MODE")
+
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, UpdatesModeAtomic, *UpdatesMode)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-updates-mode=partial"}
+	ConfigServer()
+	assert.Equal(t, UpdatesModePartial, *UpdatesMode)
+
+	resetFlags()
+	setEnv(t, "UPDATES_MODE", "partial")
+	defer unsetEnv(t, "UPDATES_MODE")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, UpdatesModePartial, *UpdatesMode)
+}
+
+func TestConfigServer_Encryption(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "ENCRYPTION_READ")
+	unsetEnv(t, "ENCRYPTION_WRITE")
+
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, "allow", *EncryptionRead)
+	assert.Equal(t, "require", *EncryptionWrite)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-encryption-read=off", "-encryption-write=allow"}
+	ConfigServer()
+	assert.Equal(t, "off", *EncryptionRead)
+	assert.Equal(t, "allow", *EncryptionWrite)
+
+	resetFlags()
+	setEnv(t, "ENCRYPTION_WRITE", "off")
+	defer unsetEnv(t, "ENCRYPTION_WRITE")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, "off", *EncryptionWrite)
+}
+
+func TestConfigServer_AcceptLegacyV1(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "ACCEPT_LEGACY_V1")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.True(t, *AcceptLegacyV1)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-accept-legacy-v1=false"}
+	ConfigServer()
+	assert.False(t, *AcceptLegacyV1)
+
+	resetFlags()
+	setEnv(t, "ACCEPT_LEGACY_V1", "false")
+	defer unsetEnv(t, "ACCEPT_LEGACY_V1")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.False(t, *AcceptLegacyV1)
+}
+
+func TestConfigServer_Logging(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "LOG_LEVEL")
+	unsetEnv(t, "LOG_BODIES")
+	unsetEnv(t, "LOG_SAMPLE")
+
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, "info", *LogLevel)
+	assert.False(t, *LogBodies)
+	assert.Empty(t, *LogSample)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-log-level=debug", "-log-bodies", "-log-sample=/updates=0.1"}
+	ConfigServer()
+	assert.Equal(t, "debug", *LogLevel)
+	assert.True(t, *LogBodies)
+	assert.Equal(t, "/updates=0.1", *LogSample)
+
+	resetFlags()
+	setEnv(t, "LOG_BODIES", "true")
+	defer unsetEnv(t, "LOG_BODIES")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.True(t, *LogBodies)
+}
+
+func TestConfigServer_SelfMetrics(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "SELF_METRICS_INTERVAL")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Zero(t, *SelfMetricsInterval)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-self-metrics-interval=30s"}
+	ConfigServer()
+	assert.Equal(t, 30*time.Second, *SelfMetricsInterval)
+
+	resetFlags()
+	setEnv(t, "SELF_METRICS_INTERVAL", "1m")
+	defer unsetEnv(t, "SELF_METRICS_INTERVAL")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, time.Minute, *SelfMetricsInterval)
+}
+
+func TestConfigServer_RateLimit(t *testing.T) {
+	resetFlags()
+	for _, key := range []string{"RATE_LIMIT_BY", "RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "RATE_LIMIT_METRICS", "TRUSTED_PROXIES"} {
+		unsetEnv(t, key)
+	}
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, RateLimitByIP, *RateLimitBy)
+	assert.Nil(t, TrustedProxyList())
+	assert.Zero(t, *RateLimitRPS)
+	assert.Zero(t, *RateLimitMetrics)
+
+	resetFlags()
+	os.Args = []string{"cmd", "-rate-limit-by=token", "-rate-limit-rps=2.5", "-rate-limit-burst=5", "-trusted-proxies=10.0.0.1, 192.168.0.0/16"}
+	ConfigServer()
+	assert.Equal(t, RateLimitByToken, *RateLimitBy)
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.0/16"}, TrustedProxyList())
+	assert.Equal(t, 2.5, *RateLimitRPS)
+	assert.Equal(t, 5, *RateLimitBurst)
+
+	resetFlags()
+	setEnv(t, "RATE_LIMIT_METRICS", "6000")
+	defer unsetEnv(t, "RATE_LIMIT_METRICS")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Equal(t, 6000, *RateLimitMetrics)
+}
+
+func TestConfigServer_MaxSeries(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "MAX_SERIES")
+	unsetEnv(t, "MAX_SERIES_PER_CLIENT")
+	os.Args = []string{"cmd", "-max-series=1000"}
+	ConfigServer()
+	assert.Equal(t, 1000, *MaxSeries)
+	assert.Zero(t, *MaxSeriesPerClient)
+
+	resetFlags()
+	setEnv(t, "MAX_SERIES_PER_CLIENT", "50")
+	defer unsetEnv(t, "MAX_SERIES_PER_CLIENT")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Zero(t, *MaxSeries)
+	assert.Equal(t, 50, *MaxSeriesPerClient)
+}
+
+func TestConfigServer_Cache(t *testing.T) {
+	resetFlags()
+	unsetEnv(t, "CACHE_TTL")
+	unsetEnv(t, "CACHE_SIZE")
+	os.Args = []string{"cmd"}
+	ConfigServer()
+	assert.Zero(t, *CacheTTL)
+	assert.Equal(t, 10000, *CacheSize)
+
+	resetFlags()
+	setEnv(t, "CACHE_TTL", "5s")
+	defer unsetEnv(t, "CACHE_TTL")
+	os.Args = []string{"cmd", "-cache-size=100"}
+	ConfigServer()
+	assert.Equal(t, 5*time.Second, *CacheTTL)
+	assert.Equal(t, 100, *CacheSize)
+}
@@ -0,0 +1,198 @@
+// Package server implements configuration logic for the metrics server.
+//
+// This file contains Keyring — the set of private keys used to decrypt
+// request bodies, which can be reloaded at runtime.
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// PrivateKey is a decryption key of the server.
+//
+// Exactly one of RSA and ECDH is set; EC (P-256) and X25519 keys are
+// stored as ECDH keys.
+type PrivateKey struct {
+	RSA  *rsa.PrivateKey
+	ECDH *ecdh.PrivateKey
+	ID   string // идентификатор ключа, см. utils.KeyID
+	File string // файл, из которого загружен ключ
+}
+
+// Keyring holds the private keys loaded from a list of files and directories.
+//
+// It is safe for concurrent use; Reload replaces the keys atomically.
+type Keyring struct {
+	keys  []PrivateKey
+	paths []string
+	mu    sync.RWMutex
+}
+
+// NewKeyring creates a Keyring with the given keys, mainly for tests.
+func NewKeyring(keys ...PrivateKey) *Keyring {
+	return &Keyring{keys: keys}
+}
+
+// LoadKeyring loads the keys listed in paths, a comma-separated list of PEM
+// files and directories. Every *.pem and *.key file of a directory is read.
+//
+// The returned Keyring is never nil, so it can be reloaded later even if the
+// initial load fails.
+func LoadKeyring(paths string) (*Keyring, error) {
+	k := &Keyring{}
+	for _, p := range strings.Split(paths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			k.paths = append(k.paths, p)
+		}
+	}
+	return k, k.Reload()
+}
+
+// Reload reads all keys again. On error the current keys are kept.
+func (k *Keyring) Reload() error {
+	var keys []PrivateKey
+	for _, p := range k.paths {
+		loaded, err := loadKeyPath(p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, loaded...)
+	}
+	if len(keys) == 0 {
+		return errors.New("no private keys found in " + strings.Join(k.paths, ","))
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// Keys returns the loaded keys in load order.
+func (k *Keyring) Keys() []PrivateKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys
+}
+
+// Lookup returns the key with the given ID.
+func (k *Keyring) Lookup(id string) (PrivateKey, bool) {
+	for _, key := range k.Keys() {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return PrivateKey{}, false
+}
+
+// loadKeyPath loads a key file or every key file of a directory.
+func loadKeyPath(p string) ([]PrivateKey, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadKeyFile(p)
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".pem" || ext == ".key") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var keys []PrivateKey
+	for _, name := range names {
+		loaded, err := loadKeyFile(filepath.Join(p, name))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded...)
+	}
+	return keys, nil
+}
+
+// loadKeyFile parses every private key block of a PEM file.
+// Other blocks, such as certificates, are skipped.
+func loadKeyFile(file string) ([]PrivateKey, error) {
+	rest, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []PrivateKey
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		key, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		key.File = file
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no PEM private key found", file)
+	}
+	return keys, nil
+}
+
+// parsePrivateKey parses a PKCS#1, SEC1 or PKCS#8 block and computes its key ID.
+func parsePrivateKey(block *pem.Block) (PrivateKey, error) {
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return PrivateKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	var key PrivateKey
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.RSA = k
+		key.ID, err = utils.KeyID(&k.PublicKey)
+	case *ecdsa.PrivateKey:
+		if key.ECDH, err = k.ECDH(); err == nil {
+			key.ID, err = utils.KeyID(key.ECDH.PublicKey())
+		}
+	case *ecdh.PrivateKey:
+		key.ECDH = k
+		key.ID, err = utils.KeyID(k.PublicKey())
+	default:
+		return PrivateKey{}, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	return key, err
+}
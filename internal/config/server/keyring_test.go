@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, file string, blocks ...*pem.Block) {
+	t.Helper()
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(b)...)
+	}
+	require.NoError(t, os.WriteFile(file, data, 0o600))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkcs8RSA, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pkcs8X25519, err := x509.MarshalPKCS8PrivateKey(x25519)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, "a-pkcs1.pem"), &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	writePEM(t, filepath.Join(dir, "b-pkcs8.key"), &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8RSA})
+	writePEM(t, filepath.Join(dir, "c-ec.pem"),
+		&pem.Block{Type: "CERTIFICATE", Bytes: []byte("skipped")},
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1},
+	)
+	writePEM(t, filepath.Join(dir, "d-x25519.pem"), &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8X25519})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0o600))
+
+	keys, err := LoadKeyring(dir + ", ../../../private_key.pem")
+	require.NoError(t, err)
+	require.Len(t, keys.Keys(), 5)
+
+	rsaID, err := utils.KeyID(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, rsaID, keys.Keys()[0].ID)
+	assert.Equal(t, rsaID, keys.Keys()[1].ID, "PKCS#1 and PKCS#8 encodings of a key share the ID")
+
+	ecID, err := utils.KeyID(&ecKey.PublicKey)
+	require.NoError(t, err)
+	ec, found := keys.Lookup(ecID)
+	require.True(t, found, "the ID of an EC key must match the ID computed from its certificate key")
+	assert.NotNil(t, ec.ECDH)
+
+	assert.NotNil(t, keys.Keys()[3].ECDH)
+	assert.NotNil(t, keys.Keys()[4].RSA)
+}
+
+func TestKeyring_ReloadKeepsKeysOnError(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "key.pem")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	keys, err := LoadKeyring(file)
+	require.NoError(t, err)
+	require.Len(t, keys.Keys(), 1)
+
+	require.NoError(t, os.WriteFile(file, []byte("garbage"), 0o600))
+	assert.ErrorContains(t, keys.Reload(), "no PEM private key")
+	assert.Len(t, keys.Keys(), 1)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other)})
+	require.NoError(t, keys.Reload())
+	otherID, err := utils.KeyID(&other.PublicKey)
+	require.NoError(t, err)
+	_, found := keys.Lookup(otherID)
+	assert.True(t, found)
+}
+
+func TestLoadKeyring_Errors(t *testing.T) {
+	keys, err := LoadKeyring("")
+	assert.Error(t, err)
+	assert.NotNil(t, keys)
+	assert.Empty(t, keys.Keys())
+
+	_, err = LoadKeyring(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "ed25519.pem")
+	writePEM(t, file, &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: []byte("x")})
+	_, err = LoadKeyring(file)
+	assert.ErrorContains(t, err, "unsupported PEM block")
+}
@@ -3,21 +3,36 @@
 // It supports:
 // - Loading metrics from a file on startup
 // - Periodically saving current metrics to a file
+// - Reporting snapshot progress to the readiness checks
 package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"sync"
 
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"go.uber.org/zap"
 )
 
+// minSnapshotAge is the smallest age at which the last snapshot is stale.
+const minSnapshotAge = time.Minute
+
+// persistence tracks the snapshots of the storage file for the readiness checks.
+var persistence struct {
+	loopStarted  atomic.Int64 // время запуска StoreInFile, unix ns
+	lastSnapshot atomic.Int64 // время последнего успешного сохранения, unix ns
+}
+
 // RestoreStorage loads metrics from the storage file (if exists) and returns an initialized MemStorage.
 //
 // If the file is missing or contains invalid data, it returns a new empty storage.
@@ -43,23 +58,80 @@ func RestoreStorage() *storage.MemStorage {
 // storeInFile saves all current metrics from MemStorage to the storage file in JSON format.
 //
 // If marshaling or writing fails, logs an error using zap.Logger.
+// The duration of the save and its failures are recorded in telemetry.Default.
 func storeInFile(s *storage.MemStorage) {
+	start := time.Now()
+	defer func() {
+		telemetry.SnapshotDuration.Observe(telemetry.Since(start))
+	}()
 	jsonData, err := json.Marshal(s.GetAllMetrics())
 	if err != nil {
+		telemetry.SnapshotFailures.Inc()
 		logger.Log.Error("storeInFile", zap.String("error while marshal metrics", err.Error()))
+		return
 	}
 	err = os.WriteFile(*FileStorePath, jsonData, os.FileMode(os.O_RDWR)|os.FileMode(os.O_CREATE)|os.FileMode(os.O_TRUNC))
 	if err != nil {
+		telemetry.SnapshotFailures.Inc()
 		logger.Log.Error("storeInFile", zap.String("error while writing file", err.Error()))
+		return
 	}
+	persistence.lastSnapshot.Store(time.Now().UnixNano())
 }
 
 // StoreInFile starts a background loop that periodically saves metrics to disk.
 //
 // Interval is defined by StoreInterval (in seconds).
 func StoreInFile(s *storage.MemStorage) {
+	persistence.loopStarted.Store(time.Now().UnixNano())
 	for {
 		time.Sleep(time.Second * time.Duration(*StoreInterval))
 		storeInFile(s)
 	}
 }
+
+// CheckFileStore fails if the storage file cannot be written.
+//
+// An existing file is opened for writing, leaving its content intact. A
+// missing file is not created, as an empty file would break the next
+// restore; a temporary file is created and removed in its directory instead.
+func CheckFileStore() error {
+	info, err := os.Stat(*FileStorePath)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("%s is a directory", *FileStorePath)
+	case err == nil:
+		f, err := os.OpenFile(*FileStorePath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(*FileStorePath), filepath.Base(*FileStorePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// CheckSnapshot fails if StoreInFile is not running or if no snapshot was
+// saved for two store intervals, and at least a minute, since the last one
+// or since the loop started.
+func CheckSnapshot() error {
+	started := persistence.loopStarted.Load()
+	if started == 0 {
+		return errors.New("snapshots are not running")
+	}
+	last := max(persistence.lastSnapshot.Load(), started)
+	maxAge := max(2*time.Second*time.Duration(*StoreInterval), minSnapshotAge)
+	if age := time.Since(time.Unix(0, last)); age > maxAge {
+		if persistence.lastSnapshot.Load() == 0 {
+			return fmt.Errorf("no snapshot saved in %s", age.Round(time.Second))
+		}
+		return fmt.Errorf("last snapshot saved %s ago", age.Round(time.Second))
+	}
+	return nil
+}
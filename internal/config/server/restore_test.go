@@ -4,11 +4,14 @@ import (
 	"context"
 	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRestore(t *testing.T) {
@@ -36,3 +39,51 @@ func TestRestore(t *testing.T) {
 		assert.Equal(t, metricOrigin, metricRestored)
 	}
 }
+
+func TestCheckFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	FileStorePath = &path
+	assert.NoError(t, CheckFileStore())
+	_, err := os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist, "a missing file must not be created")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe file must be removed")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"a":{}}`), 0o644))
+	assert.NoError(t, CheckFileStore())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":{}}`, string(content))
+
+	missing := filepath.Join(t.TempDir(), "missing", "metrics.json")
+	FileStorePath = &missing
+	assert.Error(t, CheckFileStore())
+}
+
+func TestCheckSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	FileStorePath = &path
+	interval := 60
+	StoreInterval = &interval
+	t.Cleanup(func() {
+		persistence.loopStarted.Store(0)
+		persistence.lastSnapshot.Store(0)
+	})
+
+	persistence.loopStarted.Store(0)
+	persistence.lastSnapshot.Store(0)
+	assert.ErrorContains(t, CheckSnapshot(), "not running")
+
+	persistence.loopStarted.Store(time.Now().UnixNano())
+	assert.NoError(t, CheckSnapshot(), "a loop that just started is fresh")
+
+	persistence.loopStarted.Store(time.Now().Add(-3 * time.Minute).UnixNano())
+	assert.ErrorContains(t, CheckSnapshot(), "no snapshot saved")
+
+	storeInFile(storage.NewMemStorage(&sync.Map{}))
+	assert.NoError(t, CheckSnapshot())
+
+	persistence.lastSnapshot.Store(time.Now().Add(-3 * time.Minute).UnixNano())
+	assert.ErrorContains(t, CheckSnapshot(), "last snapshot saved")
+}
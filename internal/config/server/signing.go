@@ -0,0 +1,137 @@
+// Package server implements configuration logic for the metrics server.
+//
+// This file contains SigningKeys — the set of HMAC keys accepted for the
+// HashSHA256 request signature.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// SigningKey is an HMAC-SHA256 key that is valid from NotBefore until NotAfter.
+//
+// Zero times leave the corresponding side of the interval open. A key with
+// an ID is used only for requests that name it in the "Key-Id" header, which
+// is how per-agent keys are set up; keys without an ID are tried for
+// requests without the header.
+type SigningKey struct {
+	NotBefore time.Time `json:"not_before,omitempty"` // начало действия ключа
+	NotAfter  time.Time `json:"not_after,omitempty"`  // окончание действия ключа
+	ID        string    `json:"id,omitempty"`         // значение заголовка Key-Id
+	Key       string    `json:"key"`                  // секрет HMAC
+}
+
+// Active reports whether the key is valid at now.
+func (k SigningKey) Active(now time.Time) bool {
+	return (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || now.Before(k.NotAfter))
+}
+
+// SigningKeys holds the static key given by Key and the keys read from a
+// JSON file with an array of SigningKey objects.
+//
+// It is safe for concurrent use; Reload replaces the keys atomically.
+type SigningKeys struct {
+	static string
+	file   string
+	keys   []SigningKey
+	mu     sync.RWMutex
+}
+
+// LoadSigningKeys creates SigningKeys from the static key and the keys file.
+// Either may be empty. The result is never nil, even on error.
+func LoadSigningKeys(static, file string) (*SigningKeys, error) {
+	s := &SigningKeys{static: static, file: file}
+	if static != "" {
+		s.keys = []SigningKey{{Key: static}}
+	}
+	return s, s.Reload()
+}
+
+// Reload reads the keys file again. On error the current keys are kept.
+func (s *SigningKeys) Reload() error {
+	var keys []SigningKey
+	if s.static != "" {
+		keys = append(keys, SigningKey{Key: s.static})
+	}
+	if s.file != "" {
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return err
+		}
+		var fromFile []SigningKey
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return fmt.Errorf("%s: %w", s.file, err)
+		}
+		if err := validateSigningKeys(fromFile); err != nil {
+			return fmt.Errorf("%s: %w", s.file, err)
+		}
+		keys = append(keys, fromFile...)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// validateSigningKeys rejects empty keys, inverted intervals and duplicate IDs.
+func validateSigningKeys(keys []SigningKey) error {
+	seen := make(map[string]bool)
+	for i, k := range keys {
+		switch {
+		case k.Key == "":
+			return fmt.Errorf("key %d: key is empty", i)
+		case !k.NotBefore.IsZero() && !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore):
+			return fmt.Errorf("key %d: not_after is not after not_before", i)
+		case k.ID != "" && seen[k.ID]:
+			return fmt.Errorf("key %d: duplicate id %q", i, k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return nil
+}
+
+// Empty reports whether no key is configured, in which case requests are not verified.
+func (s *SigningKeys) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) == 0
+}
+
+// ErrUnknownKeyID means the "Key-Id" of a request names no active key.
+var ErrUnknownKeyID = errors.New("unknown or inactive Key-Id")
+
+// Verify finds the key that produced the hex HMAC sum of body.
+//
+// If keyID is set only the active key with that ID is checked, otherwise
+// every active key without an ID is tried.
+func (s *SigningKeys) Verify(keyID string, body []byte, sum string, now time.Time) (SigningKey, error) {
+	s.mu.RLock()
+	keys := s.keys
+	s.mu.RUnlock()
+
+	knownID := false
+	for _, k := range keys {
+		if k.ID != keyID || !k.Active(now) {
+			continue
+		}
+		knownID = true
+		expected := utils.CalculateHashWithKey(body, k.Key)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(sum)) == 1 {
+			return k, nil
+		}
+	}
+	if keyID != "" && !knownID {
+		return SigningKey{}, ErrUnknownKeyID
+	}
+	return SigningKey{}, errors.New("HashSHA256 header does not match request body")
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKey_Active(t *testing.T) {
+	now := time.Now()
+	assert.True(t, SigningKey{}.Active(now))
+	assert.True(t, SigningKey{NotBefore: now, NotAfter: now.Add(time.Second)}.Active(now))
+	assert.False(t, SigningKey{NotBefore: now.Add(time.Second)}.Active(now))
+	assert.False(t, SigningKey{NotAfter: now}.Active(now))
+}
+
+func TestSigningKeys_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"id":"a","key":"one"}]`), 0o600))
+
+	keys, err := LoadSigningKeys("static", file)
+	require.NoError(t, err)
+	body := []byte("body")
+
+	_, err = keys.Verify("a", body, utils.CalculateHashWithKey(body, "one"), time.Now())
+	assert.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"id":"a","key":"one"},{"id":"a","key":"two"}]`), 0o600))
+	assert.ErrorContains(t, keys.Reload(), "duplicate id")
+	_, err = keys.Verify("a", body, utils.CalculateHashWithKey(body, "one"), time.Now())
+	assert.NoError(t, err, "a failed reload must keep the current keys")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"id":"b","key":"two"}]`), 0o600))
+	require.NoError(t, keys.Reload())
+	_, err = keys.Verify("a", body, utils.CalculateHashWithKey(body, "one"), time.Now())
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+	_, err = keys.Verify("", body, utils.CalculateHashWithKey(body, "static"), time.Now())
+	assert.NoError(t, err)
+}
+
+func TestLoadSigningKeys_Errors(t *testing.T) {
+	keys, err := LoadSigningKeys("", "")
+	require.NoError(t, err)
+	assert.True(t, keys.Empty())
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"key":""}]`), 0o600))
+	keys, err = LoadSigningKeys("static", file)
+	assert.ErrorContains(t, err, "key is empty")
+	assert.False(t, keys.Empty(), "the static key must survive a broken keys file")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"key":"k","not_before":"2025-01-02T00:00:00Z","not_after":"2025-01-01T00:00:00Z"}]`), 0o600))
+	_, err = LoadSigningKeys("", file)
+	assert.ErrorContains(t, err, "not_after")
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// issue creates a certificate signed by parent (self-signed if parent is nil)
+// and writes it with its key to dir.
+func issue(t *testing.T, dir, name string, parent *testCert, isCA bool, notAfter time.Time) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-2 * time.Hour),
+		NotAfter:              notAfter,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return tc
+}
+
+func TestTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, dir, "ca", nil, true, time.Now().Add(time.Hour))
+	srv := issue(t, dir, "server", ca, false, time.Now().Add(time.Hour))
+	expired := issue(t, dir, "expired", ca, false, time.Now().Add(-time.Hour))
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a pem"), 0o600))
+
+	tests := []struct {
+		name, cert, key, ca, errContains string
+	}{
+		{name: "only cert", cert: srv.certFile, errContains: "both tls-cert and tls-key"},
+		{name: "client CA without cert", ca: ca.certFile, errContains: "requires tls-cert"},
+		{name: "missing file", cert: filepath.Join(dir, "missing.pem"), key: srv.keyFile, errContains: "load server certificate"},
+		{name: "key does not match", cert: srv.certFile, key: ca.keyFile, errContains: "load server certificate"},
+		{name: "expired", cert: expired.certFile, key: expired.keyFile, errContains: "expired"},
+		{name: "bad CA bundle", cert: srv.certFile, key: srv.keyFile, ca: garbage, errContains: "no PEM certificates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := TLSConfig(tt.cert, tt.key, tt.ca)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errContains)
+		})
+	}
+
+	cfg, err := TLSConfig("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+}
+
+func TestTLSConfig_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, dir, "ca", nil, true, time.Now().Add(time.Hour))
+	srvCert := issue(t, dir, "server", ca, false, time.Now().Add(time.Hour))
+	clientCert := issue(t, dir, "client", ca, false, time.Now().Add(time.Hour))
+
+	cfg, err := TLSConfig(srvCert.certFile, srvCert.keyFile, ca.certFile)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			MinVersion:   tls.VersionTLS12,
+		}}}
+	}
+
+	pair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	require.NoError(t, err)
+	resp, err := client(pair).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = client().Get(ts.URL)
+	assert.Error(t, err, "a client without certificate must be rejected")
+}
@@ -0,0 +1,134 @@
+// Package server implements configuration logic for the metrics server.
+//
+// This file contains Tokens — the bearer tokens accepted by the API and the
+// scopes they grant.
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Scope is a class of API endpoints a token gives access to.
+type Scope string
+
+const (
+	// ScopeRead allows reading metrics: /value, / and the query endpoints.
+	ScopeRead Scope = "read"
+	// ScopeWrite allows storing metrics: /update and /updates.
+	ScopeWrite Scope = "write"
+	// ScopeAdmin allows profiling and management endpoints and implies every other scope.
+	ScopeAdmin Scope = "admin"
+)
+
+// Token is a bearer token and the scopes it grants.
+type Token struct {
+	Name   string  `json:"name,omitempty"` // имя владельца для журналов
+	Token  string  `json:"token"`          // секрет, передаваемый в Authorization
+	Scopes []Scope `json:"scopes"`         // разрешённые области
+}
+
+// Allows reports whether the token grants scope.
+func (t Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens holds the tokens from the server config and the ones read from a
+// JSON file with an array of Token objects.
+//
+// It is safe for concurrent use; Reload replaces the tokens atomically.
+type Tokens struct {
+	static []Token
+	file   string
+	tokens []Token
+	mu     sync.RWMutex
+}
+
+// LoadTokens creates Tokens from the configured tokens and the tokens file.
+// Either may be empty. The result is never nil, even on error.
+func LoadTokens(static []Token, file string) (*Tokens, error) {
+	t := &Tokens{static: static, file: file, tokens: static}
+	return t, t.Reload()
+}
+
+// Reload reads the tokens file again. On error the current tokens are kept.
+func (t *Tokens) Reload() error {
+	tokens := append([]Token(nil), t.static...)
+	if t.file != "" {
+		data, err := os.ReadFile(t.file)
+		if err != nil {
+			return err
+		}
+		var fromFile []Token
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return fmt.Errorf("%s: %w", t.file, err)
+		}
+		tokens = append(tokens, fromFile...)
+	}
+	if err := validateTokens(tokens); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.tokens = tokens
+	t.mu.Unlock()
+	return nil
+}
+
+// validateTokens rejects empty tokens, unknown scopes and duplicate tokens.
+func validateTokens(tokens []Token) error {
+	seen := make(map[string]bool)
+	for i, tok := range tokens {
+		if tok.Token == "" {
+			return fmt.Errorf("token %d: token is empty", i)
+		}
+		if len(tok.Scopes) == 0 {
+			return fmt.Errorf("token %d: no scopes", i)
+		}
+		for _, s := range tok.Scopes {
+			if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+				return fmt.Errorf("token %d: unknown scope %q", i, s)
+			}
+		}
+		if seen[tok.Token] {
+			return fmt.Errorf("token %d: duplicate token", i)
+		}
+		seen[tok.Token] = true
+	}
+	return nil
+}
+
+// Empty reports whether no token is configured, in which case the API is open.
+func (t *Tokens) Empty() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.tokens) == 0
+}
+
+// Lookup finds the token equal to secret.
+//
+// Tokens are compared by their SHA-256 sums in constant time, so the
+// comparison leaks neither the secret nor its length.
+func (t *Tokens) Lookup(secret string) (Token, bool) {
+	t.mu.RLock()
+	tokens := t.tokens
+	t.mu.RUnlock()
+
+	sum := sha256.Sum256([]byte(secret))
+	for _, tok := range tokens {
+		expected := sha256.Sum256([]byte(tok.Token))
+		if subtle.ConstantTimeCompare(sum[:], expected[:]) == 1 {
+			return tok, true
+		}
+	}
+	return Token{}, false
+}
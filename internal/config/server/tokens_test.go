@@ -0,0 +1,64 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToken_Allows(t *testing.T) {
+	reader := Token{Scopes: []Scope{ScopeRead}}
+	assert.True(t, reader.Allows(ScopeRead))
+	assert.False(t, reader.Allows(ScopeWrite))
+	assert.False(t, reader.Allows(ScopeAdmin))
+
+	admin := Token{Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.Allows(ScopeRead))
+	assert.True(t, admin.Allows(ScopeWrite))
+}
+
+func TestTokens_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"name":"agent","token":"w","scopes":["write"]}]`), 0o600))
+
+	tokens, err := LoadTokens([]Token{{Token: "r", Scopes: []Scope{ScopeRead}}}, file)
+	require.NoError(t, err)
+	tok, ok := tokens.Lookup("w")
+	require.True(t, ok)
+	assert.Equal(t, "agent", tok.Name)
+	_, ok = tokens.Lookup("r")
+	assert.True(t, ok)
+	_, ok = tokens.Lookup("x")
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"token":"w","scopes":["delete"]}]`), 0o600))
+	assert.ErrorContains(t, tokens.Reload(), "unknown scope")
+	_, ok = tokens.Lookup("w")
+	assert.True(t, ok, "a failed reload must keep the current tokens")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"token":"r","scopes":["admin"]}]`), 0o600))
+	assert.ErrorContains(t, tokens.Reload(), "duplicate token")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[]`), 0o600))
+	require.NoError(t, tokens.Reload())
+	_, ok = tokens.Lookup("w")
+	assert.False(t, ok)
+}
+
+func TestLoadTokens_Errors(t *testing.T) {
+	tokens, err := LoadTokens(nil, "")
+	require.NoError(t, err)
+	assert.True(t, tokens.Empty())
+
+	_, err = LoadTokens([]Token{{Token: "t"}}, "")
+	assert.ErrorContains(t, err, "no scopes")
+
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"token":"","scopes":["read"]}]`), 0o600))
+	tokens, err = LoadTokens([]Token{{Token: "r", Scopes: []Scope{ScopeRead}}}, file)
+	assert.ErrorContains(t, err, "token is empty")
+	assert.False(t, tokens.Empty(), "the config tokens must survive a broken tokens file")
+}
@@ -0,0 +1,218 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the export and import endpoints used to back up and
+// restore the whole storage.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Dump formats of the export and import endpoints.
+const (
+	// FormatJSON is a JSON object of name to metric, as written to the storage file.
+	FormatJSON = "json"
+	// FormatNDJSON is one JSON metric per line.
+	FormatNDJSON = "ndjson"
+)
+
+// Import modes.
+const (
+	// ImportMerge keeps the stored metrics missing from the dump.
+	ImportMerge = "merge"
+	// ImportReplace removes every stored metric before the import.
+	ImportReplace = "replace"
+)
+
+// ndjsonType is the media type of FormatNDJSON.
+const ndjsonType = "application/x-ndjson"
+
+// ImportResponse is the body of a successful import.
+type ImportResponse struct {
+	Mode     string `json:"mode"`     // merge или replace
+	Imported int    `json:"imported"` // число сохранённых метрик
+	Skipped  int    `json:"skipped"`  // метрики сервера, пропущенные при загрузке
+}
+
+// Export handles GET /admin/export?format=json|ndjson and writes every
+// stored metric in the requested format. Without the format parameter,
+// NDJSON is chosen by an Accept header of application/x-ndjson, otherwise
+// the dump is the JSON object the storage file holds.
+//
+// NDJSON is written in name order, one metric at a time.
+func Export(c *gin.Context, st storage.Storage) {
+	format, ok := dumpFormat(c, c.GetHeader("Accept"))
+	if !ok {
+		return
+	}
+	metrics := st.GetAllMetrics()
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", `attachment; filename="metrics.`+format+`"`)
+	if format == FormatJSON {
+		c.JSON(http.StatusOK, metrics)
+		return
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.Header("Content-Type", ndjsonType)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, name := range names {
+		if err := enc.Encode(metrics[name]); err != nil {
+			logger.Log.Error("Export", zap.String("error while writing metric", err.Error()))
+			return
+		}
+	}
+}
+
+// Import handles POST /admin/import?mode=merge|replace&format=json|ndjson
+// and loads a dump written by Export or by the storage file into the
+// storage; see storage.Import. Without the format parameter, NDJSON is
+// chosen by a Content-Type of application/x-ndjson. The mode defaults to merge.
+//
+// The dump is validated as a whole before anything is stored. Metrics
+// whose names start with telemetry.ReservedPrefix belong to the server that
+// wrote the dump and are skipped. The new series of the dump are admitted
+// as a whole; see storage.Import.
+//
+// Responds with:
+// - 200 OK and an ImportResponse as JSON
+// - 400 Bad Request if the parameters or the dump are invalid, with the index of the offending metric, or if a series limit would be exceeded
+// - 413 Request Entity Too Large if the dump exceeds maxSize bytes
+// - 500 Internal Server Error if the storage fails
+func Import(c *gin.Context, st storage.Storage, maxSize int64) {
+	mode := c.DefaultQuery("mode", ImportMerge)
+	if mode != ImportMerge && mode != ImportReplace {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidQuery, "mode must be merge or replace")
+		return
+	}
+	format, ok := dumpFormat(c, c.ContentType())
+	if !ok {
+		return
+	}
+	metrics, err := readDump(http.MaxBytesReader(c.Writer, c.Request.Body, maxSize), format)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+			fmt.Sprintf("dump exceeds %d bytes", maxSize))
+		return
+	case err != nil:
+		apierror.AbortWithError(c, http.StatusBadRequest, toAPIError(err, apierror.CodeInvalidBody))
+		return
+	}
+
+	resp := ImportResponse{Mode: mode}
+	valid := metrics[:0]
+	for i, m := range metrics {
+		if strings.HasPrefix(m.ID, telemetry.ReservedPrefix) {
+			resp.Skipped++
+			continue
+		}
+		if e, ok := validateItem(i, m); !ok {
+			apierror.AbortWithError(c, http.StatusBadRequest, e)
+			return
+		}
+		valid = append(valid, m)
+	}
+
+	resp.Imported, err = storage.Import(c.Request.Context(), st, valid, mode == ImportReplace)
+	if errors.Is(err, storage.ErrSeriesLimit) || errors.Is(err, storage.ErrClientSeriesLimit) {
+		apierror.AbortWithError(c, http.StatusBadRequest, seriesError(err))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+	logger.Log.Info("Import", zap.String("mode", mode), zap.Int("imported", resp.Imported), zap.Int("skipped", resp.Skipped))
+	c.JSON(http.StatusOK, resp)
+}
+
+// dumpFormat returns the format parameter of the request or, without one,
+// the format named by the media type. Otherwise it aborts the request with
+// 400 and returns false.
+func dumpFormat(c *gin.Context, mediaType string) (string, bool) {
+	format, ok := c.GetQuery("format")
+	if !ok {
+		if t, _, err := mime.ParseMediaType(mediaType); err == nil && t == ndjsonType {
+			return FormatNDJSON, true
+		}
+		return FormatJSON, true
+	}
+	if format != FormatJSON && format != FormatNDJSON {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidQuery, "format must be json or ndjson")
+		return "", false
+	}
+	return format, true
+}
+
+// readDump decodes the metrics of a dump in format. The metrics of the JSON
+// object are sorted by name; one without an ID takes the name of its key.
+//
+// An error in an NDJSON line is an apierror.Error with the index of the
+// line, unless r failed to read.
+func readDump(r io.Reader, format string) ([]utils.Metrics, error) {
+	if format == FormatJSON {
+		var dump map[string]utils.Metrics
+		if err := json.NewDecoder(r).Decode(&dump); err != nil {
+			return nil, err
+		}
+		metrics := make([]utils.Metrics, 0, len(dump))
+		for name, m := range dump {
+			if m.ID == "" {
+				m.ID = name
+			}
+			metrics = append(metrics, m)
+		}
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].ID < metrics[j].ID })
+		return metrics, nil
+	}
+
+	var metrics []utils.Metrics
+	dec := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var m utils.Metrics
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return metrics, nil
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, apierror.NewItem(i, apierror.CodeInvalidBody, err.Error())
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// toAPIError returns err as an apierror.Error, or a new one with code and
+// the message of err.
+func toAPIError(err error, code string) apierror.Error {
+	var e apierror.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return apierror.New(code, err.Error())
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := storage.NewMemStorage(&sync.Map{})
+	st.SetMetric(context.Background(), "PollCount", int64(3), true)
+	st.SetMetric(context.Background(), "Alloc", 1.5, false)
+	r := gin.New()
+	r.GET("/admin/export", func(ctx *gin.Context) {
+		Export(ctx, st)
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		accept         string
+		expectedType   string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 JSON by default",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/json; charset=utf-8",
+			expectedBody:   `{"Alloc":{"id":"Alloc","type":"gauge","value":1.5},"PollCount":{"id":"PollCount","type":"counter","delta":3}}`,
+		},
+		{
+			name:           "Positive #2 NDJSON by Accept",
+			accept:         "application/x-ndjson",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/x-ndjson",
+			expectedBody:   "{\"value\":1.5,\"id\":\"Alloc\",\"type\":\"gauge\"}\n{\"delta\":3,\"id\":\"PollCount\",\"type\":\"counter\"}\n",
+		},
+		{
+			name:           "Positive #3 format overrides Accept",
+			query:          "?format=json",
+			accept:         "application/x-ndjson",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/json; charset=utf-8",
+		},
+		{
+			name:           "Negative #1 unknown format",
+			query:          "?format=csv",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_query","message":"format must be json or ndjson"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/export"+tt.query, nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedType != "" {
+				assert.Equal(t, tt.expectedType, rr.Header().Get("Content-Type"))
+			}
+			switch {
+			case tt.expectedBody == "":
+			case strings.HasSuffix(tt.expectedBody, "\n"):
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			default:
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		expectedMetrics map[string]utils.Metrics
+		name            string
+		query           string
+		contentType     string
+		body            string
+		expectedBody    string
+		expectedStatus  int
+	}{
+		{
+			name:           "Positive #1 merge a JSON dump",
+			body:           `{"PollCount":{"id":"PollCount","type":"counter","delta":10},"Alloc":{"type":"gauge","value":2.5},"_server.up":{"type":"gauge","value":1}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"mode":"merge","imported":2,"skipped":1}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(10), true),
+				"Alloc":     utils.NewMetrics("Alloc", 2.5, false),
+				"Kept":      utils.NewMetrics("Kept", 1.0, false),
+			},
+		},
+		{
+			name:           "Positive #2 replace with an NDJSON dump",
+			query:          "?mode=replace",
+			contentType:    "application/x-ndjson",
+			body:           "{\"id\":\"PollCount\",\"type\":\"counter\",\"delta\":10}\n\n{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":2.5}\n",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"mode":"replace","imported":2,"skipped":0}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(10), true),
+				"Alloc":     utils.NewMetrics("Alloc", 2.5, false),
+			},
+		},
+		{
+			name:           "Negative #1 invalid metric rejects the whole dump",
+			query:          "?format=ndjson&mode=replace",
+			body:           "{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":2.5}\n{\"id\":\"PollCount\",\"type\":\"counter\"}\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"index":1,"code":"missing_value","message":"delta is required for counter"}}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(4), true),
+				"Kept":      utils.NewMetrics("Kept", 1.0, false),
+			},
+		},
+		{
+			name:           "Negative #2 malformed NDJSON line",
+			query:          "?format=ndjson",
+			body:           "{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":2.5}\nnot json\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(4), true),
+				"Kept":      utils.NewMetrics("Kept", 1.0, false),
+			},
+		},
+		{
+			name:           "Negative #3 dump over the limit",
+			body:           `{"` + strings.Repeat("a", 1<<10) + `":{"type":"gauge","value":2.5}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedBody:   `{"error":{"code":"body_too_large","message":"dump exceeds 1024 bytes"}}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(4), true),
+				"Kept":      utils.NewMetrics("Kept", 1.0, false),
+			},
+		},
+		{
+			name:           "Negative #4 unknown mode",
+			query:          "?mode=append",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_query","message":"mode must be merge or replace"}}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"PollCount": utils.NewMetrics("PollCount", int64(4), true),
+				"Kept":      utils.NewMetrics("Kept", 1.0, false),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			st := storage.NewMemStorage(&sync.Map{})
+			st.SetMetric(context.Background(), "PollCount", int64(4), true)
+			st.SetMetric(context.Background(), "Kept", 1.0, false)
+			r := gin.New()
+			r.POST("/admin/import", func(ctx *gin.Context) {
+				Import(ctx, st, 1<<10)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/import"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.Equal(t, tt.expectedMetrics, st.GetAllMetrics())
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the handler for values derived from metric history.
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+)
+
+// DefaultWindow is used when a derived value is requested without a window.
+const DefaultWindow = time.Minute
+
+// Derived computes a value over the recent history of a metric.
+//
+// Supported functions:
+// - rate: per-second increase of a counter, tolerant to counter resets
+// - increase: total increase of a counter, tolerant to counter resets
+// - delta: difference between the last and the first gauge sample
+//
+// The window is taken from the "window" query parameter (default 1m) and
+// must not exceed the history retention.
+//
+// Responds with:
+// - 200 OK and the derived value as string
+// - 400 Bad Request if fn or window is invalid for the metric type
+// - 404 Not Found if the metric has no history of that type or too few samples
+// - 501 Not Implemented if the storage does not keep history
+func Derived(c *gin.Context, st storage.Storage, metricType, metricName, fn string) {
+	h, ok := storage.History(st)
+	if !ok {
+		apierror.Abort(c, http.StatusNotImplemented, apierror.CodeHistoryDisabled, "metric history is disabled on this server")
+		return
+	}
+
+	window := DefaultWindow
+	if w := c.Query("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidWindow, "window must be a positive duration such as 30s or 5m")
+			return
+		}
+		window = d
+	}
+	if window > h.Retention() {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidWindow, "window exceeds history retention of "+h.Retention().String())
+		return
+	}
+
+	if !fnSupports(fn, metricType) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidFunction, "function "+strconv.Quote(fn)+" is not supported for "+metricType)
+		return
+	}
+
+	mType, samples, found := h.Samples(metricName, window)
+	if !found || mType != metricType {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeMetricNotFound, "no "+metricType+" history for metric "+metricName)
+		return
+	}
+
+	var result float64
+	switch fn {
+	case "rate":
+		result, ok = storage.Rate(samples)
+		if !ok {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeInsufficientSamples, "at least two samples in the window are required")
+			return
+		}
+	case "increase":
+		result = storage.Increase(samples)
+	case "delta":
+		result = storage.Delta(samples)
+	}
+	c.String(http.StatusOK, strconv.FormatFloat(result, 'f', -1, 64))
+}
+
+// fnSupports reports whether the derivation function fn applies to metricType.
+func fnSupports(fn, metricType string) bool {
+	switch fn {
+	case "rate", "increase":
+		return metricType == "counter"
+	case "delta":
+		return metricType == "gauge"
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDerived(t *testing.T) {
+	h := storage.NewHistoryStorage(storage.NewMemStorage(&sync.Map{}), time.Hour)
+	ctx := context.Background()
+	h.SetMetric(ctx, "requests", 10, true)
+	h.SetMetric(ctx, "requests", 5, true)
+	h.SetMetric(ctx, "temp", 20.5, false)
+	h.SetMetric(ctx, "temp", 18, false)
+
+	tests := []struct {
+		st             storage.Storage
+		name           string
+		url            string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 counter increase",
+			st:             h,
+			url:            "/value/counter/requests?fn=increase&window=1m",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "5",
+		},
+		{
+			name:           "Positive #2 gauge delta with default window",
+			st:             h,
+			url:            "/value/gauge/temp?fn=delta",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "-2.5",
+		},
+		{
+			name:           "Negative #1 delta is not defined for counters",
+			st:             h,
+			url:            "/value/counter/requests?fn=delta",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `"code":"invalid_function"`,
+		},
+		{
+			name:           "Negative #2 bad window",
+			st:             h,
+			url:            "/value/counter/requests?fn=rate&window=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `"code":"invalid_window"`,
+		},
+		{
+			name:           "Negative #3 window beyond retention",
+			st:             h,
+			url:            "/value/counter/requests?fn=rate&window=2h",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `"code":"invalid_window"`,
+		},
+		{
+			name:           "Negative #4 wrong type",
+			st:             h,
+			url:            "/value/counter/temp?fn=rate",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Negative #5 history disabled",
+			st:             storage.NewMemStorage(&sync.Map{}),
+			url:            "/value/counter/requests?fn=rate",
+			expectedStatus: http.StatusNotImplemented,
+			expectedBody:   `"code":"history_disabled"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/value/:metric_type/:metric_name", func(c *gin.Context) {
+				Value(c, tt.st)
+			})
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.expectedBody)
+		})
+	}
+}
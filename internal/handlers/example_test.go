@@ -20,7 +20,7 @@ func init() {
 	r := gin.New()
 
 	st := storage.NewMemStorage(&sync.Map{})
-	r.Use(middlewares.Gzip())
+	r.Use(middlewares.Decompress(10 << 20))
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
 
 	r.RedirectTrailingSlash = true
@@ -49,7 +49,7 @@ func init() {
 	r.GET("/ping", func(ctx *gin.Context) {
 		Ping(ctx, nil)
 	})
-	r.POST("/updates", middlewares.HashCheck(), func(ctx *gin.Context) {
+	r.POST("/updates", middlewares.HashCheck(nil, nil), func(ctx *gin.Context) {
 		Updates(ctx, st)
 	})
 	go func() {
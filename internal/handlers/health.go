@@ -0,0 +1,76 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the liveness and readiness endpoints.
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds the time all checks of one request may take.
+const healthCheckTimeout = 2 * time.Second
+
+// Component statuses reported by Health.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a component whose status is reported by Health.
+type Check struct {
+	Name string                          // имя компонента в ответе
+	Run  func(ctx context.Context) error // проверка; nil означает исправность
+}
+
+// ComponentStatus is the status of one component.
+type ComponentStatus struct {
+	Status string `json:"status"`          // ok или fail
+	Error  string `json:"error,omitempty"` // причина отказа
+}
+
+// HealthResponse is the body of the liveness and readiness endpoints.
+type HealthResponse struct {
+	Status     string                     `json:"status"`     // ok, если исправны все компоненты
+	Components map[string]ComponentStatus `json:"components"` // статус каждого компонента
+}
+
+// Health handles the /healthz and /readyz endpoints. It runs checks
+// concurrently and responds with the status of every component: 200 OK if
+// all of them succeed, 503 Service Unavailable otherwise.
+func Health(c *gin.Context, checks []Check) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	resp := HealthResponse{Status: StatusOK, Components: make(map[string]ComponentStatus, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := ComponentStatus{Status: StatusOK}
+			if err := check.Run(ctx); err != nil {
+				status = ComponentStatus{Status: StatusFail, Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Components[check.Name] = status
+			if status.Status != StatusOK {
+				resp.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if resp.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, resp)
+}
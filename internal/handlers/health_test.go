@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	ok := Check{Name: "database", Run: func(context.Context) error { return nil }}
+	failing := Check{Name: "file_store", Run: func(context.Context) error { return errors.New("read-only file system") }}
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantStatus HealthResponse
+	}{
+		{
+			name:     "all ok",
+			checks:   []Check{ok},
+			wantCode: http.StatusOK,
+			wantStatus: HealthResponse{Status: StatusOK, Components: map[string]ComponentStatus{
+				"database": {Status: StatusOK},
+			}},
+		},
+		{
+			name:     "one failing",
+			checks:   []Check{ok, failing},
+			wantCode: http.StatusServiceUnavailable,
+			wantStatus: HealthResponse{Status: StatusFail, Components: map[string]ComponentStatus{
+				"database":   {Status: StatusOK},
+				"file_store": {Status: StatusFail, Error: "read-only file system"},
+			}},
+		},
+		{
+			name:       "no checks",
+			wantCode:   http.StatusOK,
+			wantStatus: HealthResponse{Status: StatusOK, Components: map[string]ComponentStatus{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/readyz", func(c *gin.Context) {
+				Health(c, tt.checks)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			var got HealthResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.wantStatus, got)
+		})
+	}
+}
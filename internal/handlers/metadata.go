@@ -0,0 +1,58 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains handlers for the metric metadata registry.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// Metadata handles the /api/metadata endpoint.
+//
+// Supports:
+// - GET returns all declarations as a JSON array
+// - POST declares a JSON array of metadata.Metadata objects
+//
+// A POST is all-or-nothing: if any declaration is invalid, none is stored and
+// 400 Bad Request with an apierror.Response carrying its index is returned.
+func Metadata(c *gin.Context, reg *metadata.Registry) {
+	if c.Request.Method != http.MethodPost {
+		c.JSON(http.StatusOK, reg.All())
+		return
+	}
+
+	var list []metadata.Metadata
+	if err := c.ShouldBindJSON(&list); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error())
+		return
+	}
+	for i, md := range list {
+		if e, ok := validateName(md.ID); !ok {
+			e.Index = &i
+			apierror.AbortWithError(c, http.StatusBadRequest, e)
+			return
+		}
+		if err := md.Validate(); err != nil {
+			apierror.AbortWithError(c, http.StatusBadRequest, apierror.NewItem(i, apierror.CodeInvalidMetadata, err.Error()))
+			return
+		}
+	}
+	for _, md := range list {
+		reg.Declare(md)
+	}
+	c.Data(http.StatusOK, "", nil)
+}
+
+// annotate copies unit and help from the declaration of m, if any.
+func annotate(m utils.Metrics) utils.Metrics {
+	if md, found := metadata.Default.Get(m.ID); found {
+		m.Unit = md.Unit
+		m.Help = md.Help
+	}
+	return m
+}
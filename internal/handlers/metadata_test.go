@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useRegistry(t *testing.T) *metadata.Registry {
+	old := metadata.Default
+	metadata.Default = metadata.NewRegistry()
+	t.Cleanup(func() { metadata.Default = old })
+	return metadata.Default
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 declare metrics",
+			body:           `[{"id":"Alloc","type":"gauge","unit":"bytes","help":"Allocated bytes"},{"id":"PollCount","type":"counter"}]`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":"Alloc","type":"gauge","unit":"bytes","help":"Allocated bytes"},{"id":"PollCount","type":"counter"}]`,
+		},
+		{
+			name:           "Negative #1 unknown type",
+			body:           `[{"id":"Alloc","type":"gauge"},{"id":"x","type":"summary"}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Negative #2 invalid name",
+			body:           `[{"id":"bad name","type":"gauge"}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `[]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := useRegistry(t)
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Any("/api/metadata", func(c *gin.Context) {
+				Metadata(c, reg)
+			})
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/metadata", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			rr = httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/metadata", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestUpdate_DeclaredType(t *testing.T) {
+	reg := useRegistry(t)
+	require.NoError(t, reg.Declare(metadata.Metadata{ID: "Alloc", MType: "gauge", Unit: "bytes"}))
+	st := storage.NewMemStorage(&sync.Map{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/update/:metric_type/:metric_name/:value", func(c *gin.Context) {
+		Update(c, st)
+	})
+	r.POST("/value", func(c *gin.Context) {
+		Value(c, st)
+	})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/counter/Alloc/1", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"type_mismatch"`)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/10", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/value", strings.NewReader(`{"id":"Alloc","type":"gauge"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":10,"unit":"bytes"}`, rr.Body.String())
+}
+
+func TestPrometheus(t *testing.T) {
+	reg := useRegistry(t)
+	require.NoError(t, reg.Declare(metadata.Metadata{ID: "Alloc", MType: "gauge", Unit: "bytes", Help: "Allocated heap bytes."}))
+	st := storage.NewMemStorage(&sync.Map{})
+	st.SetMetric(context.Background(), "Alloc", 1024.5, false)
+	st.SetMetric(context.Background(), "PollCount", 3, true)
+	st.SetMetric(context.Background(), "1host.cpu-load", 0.5, false)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/metrics", func(c *gin.Context) {
+		Prometheus(c, st)
+	})
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, prometheusContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, ""+
+		"# TYPE _1host_cpu_load gauge\n"+
+		"_1host_cpu_load 0.5\n"+
+		"# HELP Alloc Allocated heap bytes. (unit: bytes)\n"+
+		"# TYPE Alloc gauge\n"+
+		"Alloc 1024.5\n"+
+		"# TYPE PollCount counter\n"+
+		"PollCount 3\n", rr.Body.String())
+}
+
+func TestWritePrometheusMetric_NoValue(t *testing.T) {
+	var b strings.Builder
+	writePrometheusMetric(&b, utils.Metrics{ID: "Alloc", MType: "gauge", Help: "Allocated heap bytes."})
+	writePrometheusMetric(&b, utils.Metrics{ID: "PollCount", MType: "counter", Unit: "polls"})
+	assert.Empty(t, b.String())
+}
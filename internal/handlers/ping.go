@@ -19,7 +19,8 @@ import (
 // Ping handles the /ping endpoint and checks database connectivity.
 //
 // If pool is nil or connection fails, responds with 500 Internal Server Error.
-// On success, responds with 200 OK.
+// On success, responds with 200 OK. Probes should use /readyz (see Health),
+// which also covers the servers that store metrics in memory.
 func Ping(c *gin.Context, pool *pgxpool.Pool) {
 	if pool == nil {
 		c.String(http.StatusInternalServerError, "")
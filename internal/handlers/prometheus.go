@@ -0,0 +1,96 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the Prometheus text exposition of stored metrics.
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// prometheusContentType is the content type of the text exposition format 0.0.4.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promInvalidChars matches characters not allowed in Prometheus metric names.
+var promInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// Prometheus handles the /metrics endpoint and renders all stored metrics
+// in the Prometheus text exposition format.
+//
+// Declared metrics get a "# HELP" line built from their help text and unit.
+// Names are sanitized to the Prometheus charset.
+func Prometheus(c *gin.Context, st storage.Storage) {
+	all := st.GetAllMetrics()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		writePrometheusMetric(&b, annotate(all[name]))
+	}
+	c.Data(http.StatusOK, prometheusContentType, []byte(b.String()))
+}
+
+// Telemetry handles the /internal/metrics endpoint and renders the metrics
+// the server records about itself in the Prometheus text exposition format.
+func Telemetry(c *gin.Context, r *telemetry.Registry) {
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, prometheusContentType, []byte(b.String()))
+}
+
+// writePrometheusMetric appends the HELP, TYPE and sample lines of m to b.
+// A metric without a value is skipped entirely.
+func writePrometheusMetric(b *strings.Builder, m utils.Metrics) {
+	var typ, sample string
+	switch {
+	case m.MType == "counter" && m.Delta != nil:
+		typ, sample = "counter", strconv.FormatInt(*m.Delta, 10)
+	case m.MType == "gauge" && m.Value != nil:
+		typ, sample = "gauge", strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	default:
+		return
+	}
+	name := prometheusName(m.ID)
+
+	help := m.Help
+	if m.Unit != "" {
+		help = strings.TrimSpace(help + " (unit: " + m.Unit + ")")
+	}
+	if help != "" {
+		b.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	}
+	b.WriteString("# TYPE " + name + " " + typ + "\n")
+	b.WriteString(name + " " + sample + "\n")
+}
+
+// prometheusName replaces characters that Prometheus does not accept with
+// underscores and prefixes names that start with a digit.
+func prometheusName(id string) string {
+	name := promInvalidChars.ReplaceAllString(id, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// escapeHelp escapes backslashes and line feeds as required for HELP lines.
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,36 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the aggregation query endpoint.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/query"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+)
+
+// Query handles GET /api/query?q=avg(CPUutilization*) and aggregates across
+// the metrics matched by the query.
+//
+// Responds with:
+// - 200 OK and a query.Result as JSON
+// - 400 Bad Request if the query cannot be parsed
+// - 500 Internal Server Error if the storage cannot be listed
+//
+// Errors are reported with an apierror.Response body.
+func Query(c *gin.Context, st storage.Storage) {
+	q, err := query.Parse(c.Query("q"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidQuery, err.Error())
+		return
+	}
+	result, err := query.Eval(c.Request.Context(), st, q)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
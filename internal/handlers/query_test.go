@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery(t *testing.T) {
+	st := storage.NewMemStorage(&sync.Map{})
+	st.SetMetric(context.Background(), "CPUutilization1", 10.0, false)
+	st.SetMetric(context.Background(), "CPUutilization2", 20.0, false)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 scalar",
+			query:          "sum(CPUutilization*)",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"value":30,"count":2}`,
+		},
+		{
+			name:           "Positive #2 grouped",
+			query:          "count by (type) (CPU*)",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"value":2,"count":2,"groups":[{"labels":{"type":"gauge"},"value":2,"count":2}]}`,
+		},
+		{
+			name:           "Negative #1 invalid query",
+			query:          "sum(",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `"code":"invalid_query"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/api/query", func(c *gin.Context) {
+				Query(c, st)
+			})
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/query?q="+url.QueryEscape(tt.query), nil))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.expectedBody)
+		})
+	}
+}
@@ -15,12 +15,17 @@ import (
 )
 
 // Root handles the root endpoint ("/") and returns all stored metrics in JSON format.
+// Declared metrics are annotated with their unit and help text.
 //
 // Responds with:
 // - 200 OK and JSON body if successful
 // - 500 Internal Server Error if JSON marshaling fails
 func Root(c *gin.Context, st storage.Storage) {
-	jsonData, err := json.Marshal(st.GetAllMetrics())
+	all := st.GetAllMetrics()
+	for name, m := range all {
+		all[name] = annotate(m)
+	}
+	jsonData, err := json.Marshal(all)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/audit"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// AuditRequests returns a middleware that logs the metrics stored while
+// serving a request as a single audit event, with the request ID, the
+// client address and the name of its token. Requests that store nothing or
+// fail are not logged.
+//
+// Metrics reach the event through storage.AuditStorage, which must wrap
+// the storage the handlers write to.
+func AuditRequests(a *audit.Auditor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trace, _ := utils.TraceFrom(c.Request.Context())
+		ctx, pending := audit.NewContext(c.Request.Context(), audit.Event{
+			Time:      time.Now().UTC(),
+			RequestID: trace.RequestID,
+			ClientIP:  c.ClientIP(),
+			Agent:     c.GetString(TokenNameKey),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+		})
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if status := c.Writer.Status(); status < http.StatusOK || status >= http.StatusMultipleChoices {
+			return
+		}
+		if e := pending.Event(); len(e.Metrics) > 0 {
+			a.Log(e)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/audit"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute_Audit(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.OpenFileSink(path)
+	require.NoError(t, err)
+	a := audit.NewAuditor(16, sink)
+	tokens, err := server.LoadTokens([]server.Token{
+		{Name: "agent-1", Token: "secret", Scopes: []server.Scope{server.ScopeWrite}},
+	}, "")
+	require.NoError(t, err)
+
+	r := setupRouter()
+	Route(r, storage.NewAuditStorage(storage.NewMemStorage(&sync.Map{}), a), nil, Security{Tokens: tokens})
+
+	serve := func(method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.RemoteAddr = "10.0.0.7:40000"
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/updates",
+		`[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":3}]`))
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/counter/PollCount/2", ""))
+	// Rejected updates store nothing and are not audited
+	require.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/updates", `[{"id":"Alloc","type":"gauge"}]`))
+	require.NoError(t, a.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var batch, single audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &batch))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &single))
+
+	assert.NotEmpty(t, batch.RequestID)
+	assert.Equal(t, "10.0.0.7", batch.ClientIP)
+	assert.Equal(t, "agent-1", batch.Agent)
+	assert.Equal(t, "/updates", batch.Path)
+	require.Len(t, batch.Metrics, 2)
+	assert.Equal(t, "Alloc", batch.Metrics[0].ID)
+	assert.Equal(t, int64(3), *batch.Metrics[1].Delta)
+
+	assert.Equal(t, "/update/counter/PollCount/2", single.Path)
+	require.Len(t, single.Metrics, 1)
+	assert.Equal(t, int64(2), *single.Metrics[0].Delta)
+}
+
+func TestAuditRequests_FailedRequest(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.OpenFileSink(path)
+	require.NoError(t, err)
+	a := audit.NewAuditor(16, sink)
+	st := storage.NewAuditStorage(storage.NewMemStorage(&sync.Map{}), a)
+
+	// A request that fails after storing a metric is not audited
+	r := setupRouter()
+	r.POST("/updates", AuditRequests(a), func(c *gin.Context) {
+		st.SetMetric(c.Request.Context(), "Alloc", 1.5, false)
+		c.Status(http.StatusInternalServerError)
+	})
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/updates", nil))
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+	require.NoError(t, a.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+)
+
+// TokenNameKey is the gin context key under which RequireScope stores the
+// name of the authenticated token.
+const TokenNameKey = "token_name"
+
+// RequireScope returns a middleware that admits only requests carrying an
+// "Authorization: Bearer <token>" header with a token that grants scope.
+//
+// A missing or unknown token is answered with 401, a token without the
+// scope with 403. When tokens is nil or empty every request is admitted,
+// so servers without configured tokens keep working as before.
+func RequireScope(tokens *server.Tokens, scope server.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokens == nil || tokens.Empty() {
+			c.Next()
+			return
+		}
+
+		secret, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "bearer token is required")
+			return
+		}
+		tok, ok := tokens.Lookup(secret)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics", error="invalid_token"`)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid bearer token")
+			return
+		}
+		if !tok.Allows(scope) {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics", error="insufficient_scope", scope="`+string(scope)+`"`)
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "token lacks the "+string(scope)+" scope")
+			return
+		}
+		c.Set(TokenNameKey, tok.Name)
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from an Authorization header value.
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireScope(t *testing.T) {
+	tokens, err := server.LoadTokens([]server.Token{
+		{Name: "dashboard", Token: "reader", Scopes: []server.Scope{server.ScopeRead}},
+		{Name: "ops", Token: "root", Scopes: []server.Scope{server.ScopeAdmin}},
+	}, "")
+	require.NoError(t, err)
+
+	r := setupRouter()
+	r.GET("/read", RequireScope(tokens, server.ScopeRead), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(TokenNameKey))
+	})
+	r.GET("/write", RequireScope(tokens, server.ScopeWrite), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(TokenNameKey))
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		auth   string
+		body   string
+		status int
+	}{
+		{name: "no token", path: "/read", status: http.StatusUnauthorized, body: `"code":"unauthorized"`},
+		{name: "basic auth", path: "/read", auth: "Basic cmVhZGVyOg==", status: http.StatusUnauthorized},
+		{name: "unknown token", path: "/read", auth: "Bearer nobody", status: http.StatusUnauthorized},
+		{name: "read scope", path: "/read", auth: "Bearer reader", status: http.StatusOK, body: "dashboard"},
+		{name: "lowercase scheme", path: "/read", auth: "bearer reader", status: http.StatusOK},
+		{name: "missing scope", path: "/write", auth: "Bearer reader", status: http.StatusForbidden, body: `"code":"forbidden"`},
+		{name: "admin implies write", path: "/write", auth: "Bearer root", status: http.StatusOK, body: "ops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+			assert.Equal(t, tt.status, resp.Code)
+			assert.Contains(t, resp.Body.String(), tt.body)
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, resp.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestRequireScope_NoTokens(t *testing.T) {
+	empty, err := server.LoadTokens(nil, "")
+	require.NoError(t, err)
+
+	for _, tokens := range []*server.Tokens{nil, empty} {
+		r := setupRouter()
+		r.GET("/", RequireScope(tokens, server.ScopeAdmin), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+}
+
+func TestRoute_TokenScopes(t *testing.T) {
+	var st storage.Storage
+	var p *pgxpool.Pool
+	tokens, err := server.LoadTokens([]server.Token{
+		{Token: "agent", Scopes: []server.Scope{server.ScopeWrite}},
+		{Token: "root", Scopes: []server.Scope{server.ScopeAdmin}},
+	}, "")
+	require.NoError(t, err)
+
+	r := setupRouter()
+	Route(r, st, p, Security{Tokens: tokens})
+
+	get := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusForbidden, get("/debug/pprof/", "agent"))
+	assert.Equal(t, http.StatusOK, get("/debug/pprof/", "root"))
+	assert.Equal(t, http.StatusForbidden, get("/value/gauge/x", "agent"))
+	assert.Equal(t, http.StatusUnauthorized, get("/metrics", "unknown"))
+}
@@ -0,0 +1,20 @@
+// Package router sets up HTTP routes and middleware for the metrics server.
+//
+// This file identifies the client of an update for the series limits.
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+)
+
+// IdentifyClient returns a middleware that stores the key of the client,
+// as returned by key, in the request context, where storage.AdmitSeries
+// finds it. It must run after the middlewares the key depends on, such as
+// the token check.
+func IdentifyClient(key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(storage.WithClient(c.Request.Context(), key(c)))
+		c.Next()
+	}
+}
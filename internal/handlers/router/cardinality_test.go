@@ -0,0 +1,56 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute_SeriesLimitPerClient(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	file := filepath.Join(t.TempDir(), "keys.json")
+	keysJSON, err := json.Marshal([]server.SigningKey{
+		{ID: "agent-1", Key: "agent-1-secret"},
+		{ID: "agent-2", Key: "agent-2-secret"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, keysJSON, 0o600))
+	signing, err := server.LoadSigningKeys("", file)
+	require.NoError(t, err)
+	key, err := RateLimitKey(server.RateLimitByAgent)
+	require.NoError(t, err)
+	st := storage.NewCardinalityStorage(storage.NewMemStorage(&sync.Map{}), 0, 1)
+	r := setupRouter()
+	Route(r, st, nil, Security{Client: key, Signing: signing})
+
+	update := func(name, value, agent, secret string) int {
+		body := `[{"id":"` + name + `","type":"gauge","value":` + value + `}]`
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Key-Id", agent)
+		req.Header.Set("HashSHA256", utils.CalculateHashWithKey([]byte(body), secret))
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, update("a", "1", "agent-1", "agent-1-secret"))
+	assert.Equal(t, http.StatusBadRequest, update("b", "1", "agent-1", "agent-1-secret"))
+	assert.Equal(t, http.StatusOK, update("b", "1", "agent-2", "agent-2-secret"))
+	// Series created by another client may be updated
+	assert.Equal(t, http.StatusOK, update("b", "2", "agent-1", "agent-1-secret"))
+	// A Key-Id header without a matching signature names no client
+	assert.Equal(t, http.StatusBadRequest, update("c", "1", "agent-3", "agent-1-secret"))
+}
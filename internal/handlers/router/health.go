@@ -0,0 +1,42 @@
+// Package router sets up HTTP routes and middleware for the metrics server.
+//
+// This file selects the components checked by the liveness and readiness
+// endpoints for the configured storage.
+package router
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/handlers"
+)
+
+// livenessChecks returns the components checked by /healthz. Liveness only
+// reports that the server handles requests: a failing dependency must not
+// get the process restarted.
+func livenessChecks() []handlers.Check {
+	return []handlers.Check{
+		{Name: "server", Run: func(context.Context) error { return nil }},
+	}
+}
+
+// readinessChecks returns the components checked by /readyz: the database
+// ping when metrics are stored in PostgreSQL, otherwise the writability of
+// the storage file and the freshness of its snapshots. The restore is not
+// checked, as it completes before the server starts listening.
+func readinessChecks(pool *pgxpool.Pool) []handlers.Check {
+	if server.IsDB {
+		return []handlers.Check{{Name: "database", Run: func(ctx context.Context) error {
+			if pool == nil {
+				return errors.New("no database connection pool")
+			}
+			return pool.Ping(ctx)
+		}}}
+	}
+	return []handlers.Check{
+		{Name: "file_store", Run: func(context.Context) error { return server.CheckFileStore() }},
+		{Name: "snapshot", Run: func(context.Context) error { return server.CheckSnapshot() }},
+	}
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/middlewares"
+)
+
+// RateLimitKey returns the function that identifies the client of a
+// request for the rate limits: its IP address, the name of its bearer
+// token or the ID of the signing key that verified it, see
+// server.RateLimitBy. Headers the client may choose freely, such as
+// Key-Id, are never used. Requests without the key are identified by the
+// name of their token, then by their IP address.
+func RateLimitKey(by string) (func(c *gin.Context) string, error) {
+	switch by {
+	case server.RateLimitByIP, "":
+		return func(c *gin.Context) string { return "ip:" + c.ClientIP() }, nil
+	case server.RateLimitByToken:
+		return func(c *gin.Context) string {
+			if name := c.GetString(TokenNameKey); name != "" {
+				return "token:" + name
+			}
+			return "ip:" + c.ClientIP()
+		}, nil
+	case server.RateLimitByAgent:
+		return func(c *gin.Context) string {
+			if id := c.GetString(middlewares.VerifiedKeyIDKey); id != "" {
+				return "agent:" + id
+			}
+			if name := c.GetString(TokenNameKey); name != "" {
+				return "token:" + name
+			}
+			return "ip:" + c.ClientIP()
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit key %q: want ip, token or agent", by)
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/middlewares"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newContext := func(token, keyID string, verified bool) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = "10.0.0.1:1234"
+		if keyID != "" {
+			c.Request.Header.Set("Key-Id", keyID)
+		}
+		if keyID != "" && verified {
+			c.Set(middlewares.VerifiedKeyIDKey, keyID)
+		}
+		if token != "" {
+			c.Set(TokenNameKey, token)
+		}
+		return c
+	}
+
+	tests := []struct {
+		by       string
+		token    string
+		keyID    string
+		verified bool
+		want     string
+	}{
+		{by: server.RateLimitByIP, token: "agent-1", want: "ip:10.0.0.1"},
+		{by: server.RateLimitByToken, token: "agent-1", want: "token:agent-1"},
+		{by: server.RateLimitByToken, want: "ip:10.0.0.1"},
+		{by: server.RateLimitByAgent, keyID: "host-7", verified: true, want: "agent:host-7"},
+		// A Key-Id header nothing verified is ignored
+		{by: server.RateLimitByAgent, keyID: "host-7", token: "agent-1", want: "token:agent-1"},
+		{by: server.RateLimitByAgent, keyID: "host-7", want: "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		key, err := RateLimitKey(tt.by)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, key(newContext(tt.token, tt.keyID, tt.verified)), tt.by)
+	}
+
+	_, err := RateLimitKey("cookie")
+	assert.Error(t, err)
+}
+
+func TestRoute_RateLimit(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	limiter := middlewares.NewRateLimiter(middlewares.RateLimitOptions{RequestsPerSecond: 100, MetricsPerMinute: 2})
+	r := setupRouter()
+	Route(r, storage.NewMemStorage(&sync.Map{}), nil, Security{Limiter: limiter})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/counter/hits/1", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/update", `{"id":"load","type":"gauge","value":1}`).Code)
+	w := serve(http.MethodPost, "/updates", `[{"id":"load","type":"gauge","value":2}]`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// Reads and metadata do not count against the metric quota
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/value/gauge/load", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/metadata", `[]`).Code)
+}
@@ -2,82 +2,223 @@
 //
 // It defines:
 // - Metric update and query endpoints
-// - Middleware stack (logging, compression, hash validation)
-// - Profiling routes via pprof
+// - Middleware stack (logging, compression, hash validation, token scopes)
+// - Liveness, readiness and profiling routes via pprof, optionally on a separate admin listener
 package router
 
 import (
 	// "net/http"
 
-	"crypto/rsa"
+	"cmp"
 	"net/http"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
 	"github.com/stepanov-ds/ya-metrics/internal/handlers"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
 	"github.com/stepanov-ds/ya-metrics/internal/middlewares"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"go.uber.org/zap"
 )
 
+// Security groups the keys and checks that protect the API and request bodies.
+// Nil fields disable the corresponding check.
+type Security struct {
+	Keys    *server.Keyring           // ключи расшифровки тела запроса
+	Signing *server.SigningKeys       // ключи подписи HashSHA256
+	Replay  *middlewares.ReplayGuard  // защита от повтора запросов
+	Tokens  *server.Tokens            // токены доступа к API
+	Limiter *middlewares.RateLimiter  // ограничение частоты запросов и метрик клиента
+	Client  func(*gin.Context) string // ключ клиента для лимита рядов; nil — клиенты не различаются
+
+	ReadEncryption  middlewares.EncryptionPolicy // шифрование запросов чтения; по умолчанию allow
+	WriteEncryption middlewares.EncryptionPolicy // шифрование запросов записи; по умолчанию require
+	RejectLegacyV1  bool                         // отклонять конверты v1; по умолчанию принимаются
+}
+
 // Route registers all HTTP handlers and middleware for the Gin engine.
 //
 // Registers:
-// - Gzip compression middleware
-// - Request logging middleware
+// - Request decompression (gzip, deflate, zstd, br) with a size limit and gzip response compression
+// - Request decryption under a policy per group (see middlewares.EncryptionPolicy)
+// - Request IDs and W3C trace-context propagation
+// - Request count and latency metrics of the server (see telemetry.Default)
+// - Request logging middleware, with optional headers and bodies and per-route sampling
 // - Hash validation middleware (optional)
+// - Bearer token scopes: read, write and admin (optional)
+// - Per-client limits of requests and stored metrics (optional)
+// - Series limits, if st is wrapped in a storage.CardinalityStorage, per client if sec.Client is set
+// - Audit of accepted updates, if st is wrapped in a storage.AuditStorage
 // - Metric update and value retrieval endpoints
-// - Pprof profiling routes
-func Route(r *gin.Engine, st storage.Storage, pool *pgxpool.Pool, privateKey *rsa.PrivateKey) {
-	r.Use(middlewares.Crypto(privateKey))
-	r.Use(middlewares.Gzip())
+// - Prometheus exposition, aggregation query, top series prefixes and metadata endpoints
+// - Health check, liveness, readiness, backup and pprof profiling routes, unless an admin listener is configured
+func Route(r *gin.Engine, st storage.Storage, pool *pgxpool.Pool, sec Security) {
+	// Request body pipeline: buffer here, then decrypt and decompress per
+	// group once the token is checked; /updates also verifies
+	r.Use(middlewares.RequestID())
+	r.Use(middlewares.Instrument())
+	r.Use(middlewares.BufferBody(*server.MaxBodySize))
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
 
-	r.Use(middlewares.WithLogging())
+	logging, err := RequestLogging()
+	if err != nil {
+		logger.Log.Error("Route", zap.String("error while parsing log sampling", err.Error()))
+	}
+	r.Use(middlewares.LogRequests(logging))
 	r.RedirectTrailingSlash = true
 
+	read := r.Group("", RequireScope(sec.Tokens, server.ScopeRead), sec.Limiter.Requests(),
+		middlewares.Crypto(sec.Keys, sec.Replay, cmp.Or(sec.ReadEncryption, middlewares.EncryptionAllow), !sec.RejectLegacyV1),
+		middlewares.Decompress(*server.MaxBodySize))
+	write := r.Group("", RequireScope(sec.Tokens, server.ScopeWrite), sec.Limiter.Requests(),
+		middlewares.Crypto(sec.Keys, sec.Replay, cmp.Or(sec.WriteEncryption, middlewares.EncryptionRequire), !sec.RejectLegacyV1),
+		middlewares.Decompress(*server.MaxBodySize))
+	if audited, ok := storage.Audited(st); ok {
+		write.Use(AuditRequests(audited.Auditor()))
+	}
+	// Metric updates also count against the metric quota of the client;
+	// /updates is metered once its signature identifies the client
+	metered := []gin.HandlerFunc{sec.Limiter.Metrics()}
+	if sec.Client != nil {
+		metered = append(metered, IdentifyClient(sec.Client))
+	}
+	update := write.Group("", metered...)
+
 	// Update metric by URL path
-	r.Any("/update/:metric_type/:metric_name/:value", func(ctx *gin.Context) {
+	update.Any("/update/:metric_type/:metric_name/:value", func(ctx *gin.Context) {
 		handlers.Update(ctx, st)
 	})
-	r.Any("/update/:metric_type/:metric_name/:value/", func(ctx *gin.Context) {
+	update.Any("/update/:metric_type/:metric_name/:value/", func(ctx *gin.Context) {
 		handlers.Update(ctx, st)
 	})
 
 	// Update metric via JSON body
-	r.POST("/update", func(ctx *gin.Context) {
+	update.POST("/update", func(ctx *gin.Context) {
 		handlers.Update(ctx, st)
 	})
 	// Get metric value by name and type
-	r.GET("/value/:metric_type/:metric_name", func(ctx *gin.Context) {
+	read.GET("/value/:metric_type/:metric_name", func(ctx *gin.Context) {
 		handlers.Value(ctx, st)
 	})
-	r.GET("/value/:metric_type/:metric_name/", func(ctx *gin.Context) {
+	read.GET("/value/:metric_type/:metric_name/", func(ctx *gin.Context) {
 		handlers.Value(ctx, st)
 	})
 
 	// Get metric value via JSON body
-	r.POST("/value", func(ctx *gin.Context) {
+	read.POST("/value", func(ctx *gin.Context) {
 		handlers.Value(ctx, st)
 	})
 
 	// Root endpoint to list all metrics
-	r.GET("/", func(ctx *gin.Context) {
+	read.GET("/", func(ctx *gin.Context) {
 		handlers.Root(ctx, st)
 	})
 
-	// Database ping endpoint
+	// Prometheus text exposition of all metrics
+	read.GET("/metrics", func(ctx *gin.Context) {
+		handlers.Prometheus(ctx, st)
+	})
+
+	// Aggregation across metrics matched by a query
+	read.GET("/api/query", func(ctx *gin.Context) {
+		handlers.Query(ctx, st)
+	})
+
+	// Name prefixes with the most series
+	read.GET("/api/series/top", func(ctx *gin.Context) {
+		handlers.TopSeries(ctx, st)
+	})
+
+	// Metric metadata declarations
+	read.GET("/api/metadata", func(ctx *gin.Context) {
+		handlers.Metadata(ctx, metadata.Default)
+	})
+	write.POST("/api/metadata", func(ctx *gin.Context) {
+		handlers.Metadata(ctx, metadata.Default)
+	})
+
+	// Bulk updates with hash validation
+	updates := handlers.Updates
+	if *server.UpdatesMode == server.UpdatesModePartial {
+		updates = handlers.UpdatesPartial
+	}
+	signed := write.Group("", middlewares.HashCheck(sec.Signing, sec.Replay))
+	signed.Use(metered...)
+	signed.POST("/updates", func(ctx *gin.Context) {
+		updates(ctx, st)
+	})
+	// Without a separate admin listener the operational routes are public
+	if *server.AdminAddress == "" {
+		RouteAdmin(r, st, pool, sec)
+	}
+	NoRoute(r)
+}
+
+// NoRoute answers requests for unknown routes of r with 404 and an
+// apierror.Response. It is registered once per engine, after its routes.
+func NoRoute(r *gin.Engine) {
+	r.NoRoute(func(c *gin.Context) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+	})
+}
+
+// RequestLogging returns the request logging options set in the server
+// configuration.
+func RequestLogging() (middlewares.LoggingOptions, error) {
+	sampling, err := middlewares.ParseSampling(*server.LogSample)
+	return middlewares.LoggingOptions{
+		Headers:   *server.LogHeaders,
+		Bodies:    *server.LogBodies,
+		BodyLimit: *server.LogBodyLimit,
+		Sampling:  sampling,
+	}, err
+}
+
+// RouteAdmin registers the operational endpoints: the database ping and the
+// liveness and readiness probes /healthz and /readyz, which are left open,
+// and the metrics of the server itself, the export and import of st and
+// the pprof profiling routes, which require the admin scope when tokens
+// are configured. Dumps to import may be compressed and are bounded by
+// server.MaxImportSize.
+//
+// Route calls it for the public engine unless server.AdminAddress is set,
+// in which case these endpoints are served only by the admin listener,
+// whose engine gets NoRoute from the caller.
+func RouteAdmin(r *gin.Engine, st storage.Storage, pool *pgxpool.Pool, sec Security) {
+	// Database ping endpoint, left open for health checks
 	r.GET("/ping", func(ctx *gin.Context) {
 		handlers.Ping(ctx, pool)
 	})
-	// Bulk updates with hash validation
-	r.POST("/updates", middlewares.HashCheck(), func(ctx *gin.Context) {
-		handlers.Updates(ctx, st)
+	// Kubernetes probes, left open like /ping
+	r.GET("/healthz", func(ctx *gin.Context) {
+		handlers.Health(ctx, livenessChecks())
+	})
+	readiness := readinessChecks(pool)
+	r.GET("/readyz", func(ctx *gin.Context) {
+		handlers.Health(ctx, readiness)
 	})
 	// Register pprof profiling routes under /debug/pprof/*
-	pprof.Register(r)
-	r.NoRoute(func(c *gin.Context) {
-		c.AbortWithStatus(http.StatusNotFound)
+	admin := r.Group("", RequireScope(sec.Tokens, server.ScopeAdmin))
+	pprof.Register(admin)
+	// Prometheus exposition of the server's own metrics
+	admin.GET("/internal/metrics", func(ctx *gin.Context) {
+		handlers.Telemetry(ctx, telemetry.Default)
+	})
+	// Backup and restore of the whole storage
+	admin.GET("/admin/export", func(ctx *gin.Context) {
+		handlers.Export(ctx, st)
+	})
+	restore := admin.Group("", middlewares.Decompress(*server.MaxImportSize))
+	if audited, ok := storage.Audited(st); ok {
+		restore.Use(AuditRequests(audited.Auditor()))
+	}
+	restore.POST("/admin/import", func(ctx *gin.Context) {
+		handlers.Import(ctx, st, *server.MaxImportSize)
 	})
 }
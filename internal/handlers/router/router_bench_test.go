@@ -0,0 +1,143 @@
+package router
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// benchBatch returns the JSON of an /updates batch like the agent sends.
+func benchBatch(b *testing.B) []byte {
+	metrics := make([]utils.Metrics, 0, 30)
+	for i := 0; i < 30; i++ {
+		v := float64(i) * 1.5
+		metrics = append(metrics, utils.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: "gauge", Value: &v})
+	}
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return body
+}
+
+// benchEncrypt seals plain into a v2 envelope for pub.
+func benchEncrypt(b *testing.B, plain []byte, pub *rsa.PublicKey) []byte {
+	keyID, err := utils.KeyID(pub)
+	if err != nil {
+		b.Fatal(err)
+	}
+	aesKey := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err = rand.Read(aesKey); err != nil {
+		b.Fatal(err)
+	}
+	if _, err = rand.Read(nonce); err != nil {
+		b.Fatal(err)
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	block, _ := aes.NewCipher(aesKey)
+	gcm, _ := cipher.NewGCM(block)
+	payload := utils.EncryptedPayload{
+		Version:         utils.PayloadV2,
+		Alg:             utils.AlgRSAOAEP256,
+		KeyID:           keyID,
+		EncryptedAESKey: base64.StdEncoding.EncodeToString(wrapped),
+		Nonce:           base64.StdEncoding.EncodeToString(nonce),
+	}
+	payload.CipherText = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plain, payload.AAD()))
+	body, err := json.Marshal(payload)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return body
+}
+
+// BenchmarkUpdates measures the whole middleware chain of POST /updates for
+// plain, compressed, signed and encrypted bodies.
+func BenchmarkUpdates(b *testing.B) {
+	const key = "bench_key"
+	if err := logger.Initialize("error"); err != nil {
+		b.Fatal(err)
+	}
+	batch := benchBatch(b)
+	compressed, err := utils.Compress(utils.EncodingGzip, batch)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	keys, err := server.LoadKeyring("../../../private_key.pem")
+	if err != nil {
+		b.Fatal(err)
+	}
+	pub := &keys.Keys()[0].RSA.PublicKey
+	signing, err := server.LoadSigningKeys(key, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		body     []byte
+		sec      Security
+		encoding string
+		signed   bool
+	}{
+		{name: "plain", body: batch},
+		{name: "gzip", body: compressed, encoding: utils.EncodingGzip},
+		{name: "gzip+signed", body: compressed, encoding: utils.EncodingGzip, signed: true, sec: Security{Signing: signing}},
+		{name: "gzip+encrypted", body: benchEncrypt(b, compressed, pub), encoding: utils.EncodingGzip, sec: Security{Keys: keys}},
+	}
+	for _, bc := range cases {
+		b.Run(bc.name, func(b *testing.B) {
+			gin.SetMode(gin.ReleaseMode)
+			gin.DefaultWriter = io.Discard
+			r := gin.New()
+			Route(r, storage.NewMemStorage(&sync.Map{}), nil, bc.sec)
+
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			hash := utils.CalculateHashWithKey(utils.SignedMessage(timestamp, "nonce", batch), key)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(bc.body))
+				req.Header.Set("Content-Type", "application/json")
+				if bc.encoding != "" {
+					req.Header.Set("Content-Encoding", bc.encoding)
+				}
+				if bc.signed {
+					req.Header.Set(utils.TimestampHeader, timestamp)
+					req.Header.Set(utils.NonceHeader, "nonce")
+					req.Header.Set("HashSHA256", hash)
+				}
+				resp := httptest.NewRecorder()
+				r.ServeHTTP(resp, req)
+				if resp.Code != http.StatusOK {
+					b.Fatalf("status %d: %s", resp.Code, resp.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -1,13 +1,25 @@
 package router
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/handlers"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupRouter() *gin.Engine {
@@ -22,7 +34,11 @@ func TestRoute_RegisterAllRoutes(t *testing.T) {
 
 	r := setupRouter()
 	cryptoKey := "../../../private_key.pem"
-	Route(r, st, p, server.ReadPrivateKey(cryptoKey))
+	keys, err := server.LoadKeyring(cryptoKey)
+	assert.NoError(t, err)
+	signing, err := server.LoadSigningKeys("", "")
+	assert.NoError(t, err)
+	Route(r, st, p, Security{Keys: keys, Signing: signing})
 
 	routes := r.Routes()
 
@@ -35,6 +51,7 @@ func TestRoute_RegisterAllRoutes(t *testing.T) {
 		{"GET", "/"},
 		{"GET", "/ping"},
 		{"POST", "/updates"},
+		{"GET", "/api/series/top"},
 	}
 
 	for _, expected := range expectedRoutes {
@@ -48,3 +65,153 @@ func TestRoute_RegisterAllRoutes(t *testing.T) {
 		assert.True(t, found, "Route not found: %s %s", expected.method, expected.path)
 	}
 }
+
+func TestRoute_AdminListener(t *testing.T) {
+	var st storage.Storage
+	var p *pgxpool.Pool
+
+	addr := "localhost:9090"
+	server.AdminAddress = &addr
+	t.Cleanup(func() {
+		empty := ""
+		server.AdminAddress = &empty
+	})
+
+	public := setupRouter()
+	Route(public, st, p, Security{})
+	admin := setupRouter()
+	RouteAdmin(admin, st, p, Security{})
+
+	hasRoute := func(r *gin.Engine, method, path string) bool {
+		for _, route := range r.Routes() {
+			if route.Method == method && route.Path == path {
+				return true
+			}
+		}
+		return false
+	}
+	for _, path := range []string{"/ping", "/healthz", "/readyz", "/internal/metrics", "/admin/export", "/debug/pprof/", "/debug/pprof/heap"} {
+		assert.False(t, hasRoute(public, "GET", path), "public listener must not serve %s", path)
+		assert.True(t, hasRoute(admin, "GET", path), "admin listener must serve %s", path)
+	}
+	assert.True(t, hasRoute(public, "POST", "/updates"))
+	assert.False(t, hasRoute(admin, "POST", "/updates"))
+	assert.False(t, hasRoute(public, "POST", "/admin/import"))
+	assert.True(t, hasRoute(admin, "POST", "/admin/import"))
+}
+
+func TestRoute_EncryptionPolicies(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	keys, err := server.LoadKeyring("../../../private_key.pem")
+	assert.NoError(t, err)
+	r := setupRouter()
+	Route(r, storage.NewMemStorage(&sync.Map{}), nil, Security{Keys: keys})
+
+	serve := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	// Reads accept plaintext by default, writes still require encryption
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/", ""))
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/value/gauge/missing", ""))
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/update", `{"id":"g","type":"gauge","value":1}`))
+}
+
+func TestNoRoute(t *testing.T) {
+	r := setupRouter()
+	RouteAdmin(r, nil, nil, Security{})
+	NoRoute(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"no route for GET /missing"}}`, w.Body.String())
+}
+
+func TestRouteAdmin_Telemetry(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	r := setupRouter()
+	Route(r, storage.NewMemStorage(&sync.Map{}), nil, Security{})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/value/gauge/missing", nil))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Contains(t, w.Body.String(), `http_requests_total{route="/value/:metric_type/:metric_name",method="GET",status="404"}`)
+	assert.Contains(t, w.Body.String(), `http_request_failures_total{route="/value/:metric_type/:metric_name",code="metric_not_found"}`)
+	assert.Contains(t, w.Body.String(), "# TYPE storage_operation_duration_seconds histogram")
+}
+
+func TestRouteAdmin_Backup(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	src, dst := storage.NewMemStorage(&sync.Map{}), storage.NewMemStorage(&sync.Map{})
+	src.SetMetric(context.Background(), "PollCount", int64(7), true)
+	src.SetMetric(context.Background(), "Alloc", 1.5, false)
+	dst.SetMetric(context.Background(), "Stale", 1.0, false)
+	from, to := setupRouter(), setupRouter()
+	Route(from, src, nil, Security{})
+	Route(to, dst, nil, Security{})
+
+	w := httptest.NewRecorder()
+	from.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/export?format=ndjson", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Dumps may be compressed
+	var dump bytes.Buffer
+	zw := gzip.NewWriter(&dump)
+	_, err := zw.Write(w.Body.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	req := httptest.NewRequest(http.MethodPost, "/admin/import?mode=replace", &dump)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	to.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, src.GetAllMetrics(), dst.GetAllMetrics())
+}
+
+func TestRouteAdmin_Health(t *testing.T) {
+	assert.NoError(t, logger.Initialize("fatal"))
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	server.FileStorePath = &path
+	restore := false
+	server.Restore = &restore
+	t.Cleanup(func() { server.IsDB = false })
+
+	serve := func(r *gin.Engine, target string) (int, handlers.HealthResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp handlers.HealthResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	r := setupRouter()
+	RouteAdmin(r, nil, nil, Security{})
+	code, resp := serve(r, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, handlers.StatusOK, resp.Components["server"].Status)
+
+	// Memory storage: the file is writable but the snapshot loop is not running
+	code, resp = serve(r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, handlers.StatusOK, resp.Components["file_store"].Status)
+	assert.Equal(t, handlers.StatusFail, resp.Components["snapshot"].Status)
+	assert.NotContains(t, resp.Components, "database")
+	assert.NotContains(t, resp.Components, "restore")
+
+	server.IsDB = true
+	r = setupRouter()
+	RouteAdmin(r, nil, nil, Security{})
+	code, resp = serve(r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]handlers.ComponentStatus{
+		"database": {Status: handlers.StatusFail, Error: "no database connection pool"},
+	}, resp.Components)
+}
@@ -0,0 +1,58 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains the endpoint listing the name prefixes with the most series.
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+)
+
+// SeriesResponse is the body of the top prefixes endpoint.
+type SeriesResponse struct {
+	Total    int                   `json:"total"`    // число рядов в хранилище
+	Prefixes []storage.PrefixCount `json:"prefixes"` // крупнейшие префиксы по числу рядов
+}
+
+// TopSeries handles GET /api/series/top?n=10&depth=1 and lists the n name
+// prefixes with the most series, a prefix being the first depth segments of
+// a name separated by any of "._:-". Both parameters default to 10 and 1.
+//
+// Responds with:
+// - 200 OK and a SeriesResponse as JSON
+// - 400 Bad Request if n or depth is not a positive integer
+func TopSeries(c *gin.Context, st storage.Storage) {
+	n, ok := positiveQuery(c, "n", 10)
+	if !ok {
+		return
+	}
+	depth, ok := positiveQuery(c, "depth", 1)
+	if !ok {
+		return
+	}
+	all := st.GetAllMetrics()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	c.JSON(http.StatusOK, SeriesResponse{Total: len(names), Prefixes: storage.TopPrefixes(names, depth, n)})
+}
+
+// positiveQuery returns the query parameter key as a positive integer, or
+// def if it is absent. Otherwise it aborts the request with 400 and returns false.
+func positiveQuery(c *gin.Context, key string, def int) (int, bool) {
+	v, ok := c.GetQuery(key)
+	if !ok {
+		return def, true
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidQuery, key+" must be a positive integer")
+		return 0, false
+	}
+	return i, true
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := storage.NewMemStorage(&sync.Map{})
+	mem.SetMetric(context.Background(), "a", 1.0, false)
+	st := storage.NewCardinalityStorage(mem, 3, 0)
+	r := gin.New()
+	r.POST("/update/:metric_type/:metric_name/:value", func(ctx *gin.Context) {
+		Update(ctx, st)
+	})
+	r.POST("/updates", func(ctx *gin.Context) {
+		Updates(ctx, st)
+	})
+	r.POST("/updates/partial", func(ctx *gin.Context) {
+		UpdatesPartial(ctx, st)
+	})
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rr
+	}
+
+	// Invalid updates reserve no series
+	assert.Equal(t, http.StatusBadRequest, post("/update/gauge/x/abc", "").Code)
+	assert.Equal(t, http.StatusBadRequest, post("/update/gauge/x/NaN", "").Code)
+	assert.Equal(t, 1, st.Len())
+	assert.Equal(t, http.StatusOK, post("/update/gauge/b/1", "").Code)
+
+	// The whole batch is rejected, with the index of the first new series over the limit
+	rr := post("/updates", `[{"id":"a","type":"gauge","value":2},{"id":"c","type":"gauge","value":1},{"id":"d","type":"gauge","value":1}]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"error":{"index":2,"code":"series_limit","message":"series limit of the server reached"}}`, rr.Body.String())
+	assert.Len(t, st.GetAllMetrics(), 2)
+
+	rr = post("/updates/partial", `[{"id":"bad name","type":"gauge","value":1},{"id":"c","type":"gauge","value":1},{"id":"d","type":"gauge","value":1},{"id":"a","type":"gauge","value":3}]`)
+	assert.Equal(t, http.StatusMultiStatus, rr.Code)
+	assert.JSONEq(t, `{"applied":[1,3],"rejected":[`+
+		`{"index":0,"code":"invalid_name","message":"metric name \"bad name\" contains forbidden characters"},`+
+		`{"index":2,"code":"series_limit","message":"series limit of the server reached"}]}`, rr.Body.String())
+	assert.Len(t, st.GetAllMetrics(), 3)
+
+	rr = post("/update/counter/e/1", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"series_limit"`)
+	// Existing series are still updated
+	assert.Equal(t, http.StatusOK, post("/update/gauge/a/4", "").Code)
+}
+
+func TestTopSeries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := storage.NewMemStorage(&sync.Map{})
+	for _, name := range []string{"http.requests", "http.errors", "db.queries", "Alloc"} {
+		st.SetMetric(context.Background(), name, 1.0, false)
+	}
+	r := gin.New()
+	r.GET("/api/series/top", func(ctx *gin.Context) {
+		TopSeries(ctx, st)
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 defaults",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"total":4,"prefixes":[{"prefix":"http","series":2},{"prefix":"Alloc","series":1},{"prefix":"db","series":1}]}`,
+		},
+		{
+			name:           "Positive #2 n and depth",
+			query:          "?n=1&depth=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"total":4,"prefixes":[{"prefix":"Alloc","series":1}]}`,
+		},
+		{
+			name:           "Negative #1 invalid n",
+			query:          "?n=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_query","message":"n must be a positive integer"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/series/top"+tt.query, nil))
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
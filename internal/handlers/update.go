@@ -7,13 +7,12 @@
 package handlers
 
 import (
-	"bytes"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
@@ -25,6 +24,7 @@ import (
 // - JSON POST format with metric data
 //
 // Validates input and stores metric in the provided storage.
+// On failure responds with an apierror.Response describing the problem.
 func Update(c *gin.Context, st storage.Storage) {
 	metricType := c.Param("metric_type")
 	metricName := c.Param("metric_name")
@@ -34,82 +34,120 @@ func Update(c *gin.Context, st storage.Storage) {
 
 	if metricType == "" || metricName == "" || metricValue == "" {
 		if c.Request.Body != nil {
-			body, err := io.ReadAll(c.Request.Body)
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+			body, err := utils.ReadBody(c.Request)
 			if err != nil {
-				c.AbortWithStatus(http.StatusNotFound)
+				apierror.Abort(c, http.StatusNotFound, apierror.CodeInvalidBody, "failed to read request body")
 				return
 			}
 			if len(body) == 0 {
-				c.AbortWithStatus(http.StatusNotFound)
+				code, message := missingParam(metricType, metricName)
+				apierror.Abort(c, http.StatusNotFound, code, message)
+				return
+			}
+			m, err = UpdateWithJSON(c, st)
+			if err != nil {
+				apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error())
 				return
 			}
-			m = UpdateWithJSON(c, st)
 			metricName = m.ID
 			metricType = m.MType
 			if strings.ToLower(metricType) == "counter" {
 				if m.Delta != nil {
 					metricValue = strconv.FormatInt(*m.Delta, 10)
 				} else {
-					c.AbortWithStatus(http.StatusBadRequest)
+					apierror.Abort(c, http.StatusBadRequest, apierror.CodeMissingValue, "delta is required for counter")
 					return
 				}
 			} else if strings.ToLower(metricType) == "gauge" {
 				if m.Value != nil {
 					metricValue = strconv.FormatFloat(*m.Value, 'f', -1, 64)
 				} else {
-					c.AbortWithStatus(http.StatusBadRequest)
+					apierror.Abort(c, http.StatusBadRequest, apierror.CodeMissingValue, "value is required for gauge")
 					return
 				}
 			} else {
-				c.AbortWithStatus(http.StatusBadRequest)
+				apierror.Abort(c, http.StatusBadRequest, apierror.CodeUnknownMetricType, "unknown metric type "+strconv.Quote(metricType))
 				return
 			}
 		} else {
-			c.AbortWithStatus(http.StatusNotFound)
+			code, message := missingParam(metricType, metricName)
+			apierror.Abort(c, http.StatusNotFound, code, message)
 			return
 		}
 	}
 
 	if c.Request.Method != http.MethodPost {
-		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		apierror.Abort(c, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "only POST is allowed")
 		return
 	}
 	if metricValue == "" {
-		c.AbortWithStatus(http.StatusNotFound)
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeMissingValue, "metric value is missing")
+		return
+	}
+	if e, ok := validateName(metricName); !ok {
+		apierror.AbortWithError(c, http.StatusBadRequest, e)
+		return
+	}
+	if e, ok := validateDeclared(metricName, strings.ToLower(metricType)); !ok {
+		apierror.AbortWithError(c, http.StatusBadRequest, e)
 		return
 	}
+	var value interface{}
+	counter := strings.ToLower(metricType) == "counter"
 	if strings.ToLower(metricType) == "gauge" {
 		v, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidValue, "gauge value must be a float")
 			return
 		}
-		st.SetMetric(ctx, metricName, v, strings.ToLower(metricType) == "counter")
-	} else if strings.ToLower(metricType) == "counter" {
+		if e, ok := validateGauge(v); !ok {
+			apierror.AbortWithError(c, http.StatusBadRequest, e)
+			return
+		}
+		value = v
+	} else if counter {
 		v, err := strconv.ParseInt(metricValue, 10, 64)
 		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidValue, "counter value must be an integer")
 			return
 		}
-		st.SetMetric(ctx, metricName, v, strings.ToLower(metricType) == "counter")
+		value = v
 	} else {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeUnknownMetricType, "unknown metric type "+strconv.Quote(metricType))
+		return
+	}
+	// The series is admitted last, so that an invalid update reserves nothing
+	if errs := storage.AdmitSeries(ctx, st, []string{metricName}, true); len(errs) > 0 && errs[0] != nil {
+		apierror.AbortWithError(c, http.StatusBadRequest, seriesError(errs[0]))
 		return
 	}
+	st.SetMetric(ctx, metricName, value, counter)
 
 	c.Data(http.StatusOK, "", nil)
 }
 
+// missingParam picks the error code and message for an update request
+// that lacks a metric name or value.
+func missingParam(metricType, metricName string) (string, string) {
+	switch {
+	case metricType == "":
+		return apierror.CodeInvalidBody, "request body is empty"
+	case metricName == "":
+		return apierror.CodeMissingName, "metric name is missing"
+	default:
+		return apierror.CodeMissingValue, "metric value is missing"
+	}
+}
+
 // UpdateWithJSON handles metric updates via JSON request body.
 //
 // Binds incoming JSON to utils.Metrics struct and returns a pointer to it.
-// If binding fails, returns an empty Metrics object.
-func UpdateWithJSON(c *gin.Context, st storage.Storage) *utils.Metrics {
+// If binding fails, returns the decode error.
+func UpdateWithJSON(c *gin.Context, st storage.Storage) (*utils.Metrics, error) {
 	var m utils.Metrics
 	if err := c.ShouldBindJSON(&m); err != nil {
-		return &utils.Metrics{}
+		return nil, err
 	}
-	return &m
+	return &m, nil
 
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 
@@ -97,6 +98,22 @@ func TestUpdate(t *testing.T) {
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "Negative #10 forbidden characters in name",
+			args: args{
+				r:       httptest.NewRequest(http.MethodPost, "/update/gauge/bad$name/1", nil),
+				storage: storage.NewMemStorage(&sync.Map{}),
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Negative #11 gauge is not finite",
+			args: args{
+				r:       httptest.NewRequest(http.MethodPost, "/update/gauge/testGauge/NaN", nil),
+				storage: storage.NewMemStorage(&sync.Map{}),
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name: "Positive #1 counter",
 			args: args{
@@ -147,3 +164,21 @@ func TestUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateInvalidJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	st := storage.NewMemStorage(&sync.Map{})
+	r.POST("/update/", func(c *gin.Context) {
+		Update(c, st)
+	})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(`{"id":"testGauge","type":`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_body"`)
+	assert.Empty(t, st.GetAllMetrics())
+}
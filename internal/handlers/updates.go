@@ -7,12 +7,13 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"io"
 	"net/http"
+	"sort"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
@@ -23,25 +24,156 @@ import (
 //
 // Expects a JSON array of utils.Metrics objects in the request body.
 // Processes each metric and stores it using the provided storage.
-// Supports transaction handling when using DBStorage.
+// Supports transaction handling when using DBStorage; the series admitted
+// for a batch that could not be stored are released.
+//
+// The batch is all-or-nothing: it is rejected as a whole with 400 Bad Request
+// if the body is not a valid JSON array, any item fails validation or would
+// create a series over a series limit; the apierror.Response then carries
+// the index of the offending item.
 func Updates(c *gin.Context, st storage.Storage) {
+	m, ok := readBatch(c)
+	if !ok {
+		return
+	}
+
+	names := make([]string, len(m))
+	for i, item := range m {
+		if e, ok := validateItem(i, item); !ok {
+			apierror.AbortWithError(c, http.StatusBadRequest, e)
+			return
+		}
+		names[i] = item.ID
+	}
+	for i, err := range storage.AdmitSeries(c.Request.Context(), st, names, true) {
+		if err != nil {
+			e := seriesError(err)
+			e.Index = &i
+			apierror.AbortWithError(c, http.StatusBadRequest, e)
+			return
+		}
+	}
+
+	if err := storeBatch(c.Request.Context(), st, m); err != nil {
+		storage.ReleaseSeries(c.Request.Context(), st, names)
+		logger.Log.Error("Updates", zap.String("error while storing batch", err.Error()))
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to store metrics")
+		return
+	}
+
+	c.Data(http.StatusOK, "", nil)
+}
+
+// UpdatesPartial handles bulk metric updates like Updates, but stores every
+// valid item even if some items fail validation or exceed a series limit.
+//
+// Responds with:
+// - 200 OK if all items were stored
+// - 207 Multi-Status with an apierror.BatchResponse listing applied and rejected items otherwise
+// - 400 Bad Request if the body is not a valid JSON array
+func UpdatesPartial(c *gin.Context, st storage.Storage) {
+	m, ok := readBatch(c)
+	if !ok {
+		return
+	}
+
+	result := apierror.BatchResponse{
+		Applied:  []int{},
+		Rejected: []apierror.Error{},
+	}
+	valid := make([]utils.Metrics, 0, len(m))
+	for i, item := range m {
+		if e, ok := validateItem(i, item); !ok {
+			result.Rejected = append(result.Rejected, e)
+			continue
+		}
+		result.Applied = append(result.Applied, i)
+		valid = append(valid, item)
+	}
+	if errs := admitBatch(c.Request.Context(), st, valid); errs != nil {
+		applied, admitted := result.Applied[:0], valid[:0]
+		for j, err := range errs {
+			if err != nil {
+				e, i := seriesError(err), result.Applied[j]
+				e.Index = &i
+				result.Rejected = append(result.Rejected, e)
+				continue
+			}
+			applied = append(applied, result.Applied[j])
+			admitted = append(admitted, valid[j])
+		}
+		result.Applied, valid = applied, admitted
+		sort.Slice(result.Rejected, func(i, j int) bool {
+			return *result.Rejected[i].Index < *result.Rejected[j].Index
+		})
+	}
+
+	if err := storeBatch(c.Request.Context(), st, valid); err != nil {
+		storage.ReleaseSeries(c.Request.Context(), st, metricNames(valid))
+		logger.Log.Error("UpdatesPartial", zap.String("error while storing batch", err.Error()))
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to store metrics")
+		return
+	}
+
+	if len(result.Rejected) == 0 {
+		c.Data(http.StatusOK, "", nil)
+		return
+	}
+	logger.Log.Info("UpdatesPartial", zap.Int("applied", len(result.Applied)), zap.Int("rejected", len(result.Rejected)))
+	c.JSON(http.StatusMultiStatus, result)
+}
+
+// admitBatch admits the series of m one by one, see storage.AdmitSeries.
+// Returns nil if every item was admitted.
+func admitBatch(ctx context.Context, st storage.Storage, m []utils.Metrics) []error {
+	errs := storage.AdmitSeries(ctx, st, metricNames(m), false)
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// metricNames returns the names of the items of m.
+func metricNames(m []utils.Metrics) []string {
+	names := make([]string, len(m))
+	for i, item := range m {
+		names[i] = item.ID
+	}
+	return names
+}
+
+// readBatch reads and decodes the JSON array of metrics from the request body.
+//
+// On failure it aborts the request with 400 and returns false.
+func readBatch(c *gin.Context) ([]utils.Metrics, bool) {
 	var m []utils.Metrics
 
-	body, err := io.ReadAll(c.Request.Body)
+	body, err := utils.ReadBody(c.Request)
 	if err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
+		return nil, false
 	}
 	if err := json.Unmarshal(body, &m); err != nil {
 		logger.Log.Error("Updates", zap.String("error while unmarshal body", err.Error()))
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error())
+		return nil, false
 	}
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-
-	ctx := c.Request.Context()
+	return m, true
+}
 
-	var isDB bool
-	if _, isDB = st.(*storage.DBStorage); isDB {
-		st.(*storage.DBStorage).BeginTransaction(ctx)
-		defer st.(*storage.DBStorage).RollbackTransaction(ctx)
+// storeBatch writes already validated metrics to st, inside a transaction
+// when st is backed by DBStorage. Returns an error if the transaction could
+// not be started or committed; nothing is stored then.
+func storeBatch(ctx context.Context, st storage.Storage, m []utils.Metrics) error {
+	db, isDB := storage.Unwrap(st).(*storage.DBStorage)
+	if isDB {
+		var err error
+		if ctx, err = db.BeginTransaction(ctx); err != nil {
+			return err
+		}
+		defer db.RollbackTransaction(ctx)
 	}
 
 	for _, item := range m {
@@ -54,8 +186,7 @@ func Updates(c *gin.Context, st storage.Storage) {
 	}
 
 	if isDB {
-		st.(*storage.DBStorage).CommitTransaction(ctx)
+		return db.CommitTransaction(ctx)
 	}
-
-	c.Data(http.StatusOK, "", nil)
+	return nil
 }
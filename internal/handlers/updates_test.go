@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/middlewares"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
@@ -38,6 +41,7 @@ func TestUpdates(t *testing.T) {
 		expectedMetrics map[string]utils.Metrics
 		name            string
 		metricName      string
+		expectedBody    string
 		expectedStatus  int
 	}{
 		{
@@ -65,6 +69,37 @@ func TestUpdates(t *testing.T) {
 				"RandomValueGauge":   utils.NewMetrics("RandomValueGauge", 0.2843918916068879, false),
 			},
 		},
+		{
+			name: "Negative #1 invalid JSON",
+			args: args{
+				r:       httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(`{"id":`)),
+				storage: storage.NewMemStorage(&sync.Map{}),
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `"code":"invalid_body"`,
+		},
+		{
+			name: "Negative #2 unknown type rejects whole batch",
+			args: args{
+				r: httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(
+					`[{"id":"ok","type":"gauge","value":1},{"id":"bad","type":"histogram","value":1}]`)),
+				storage: storage.NewMemStorage(&sync.Map{}),
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":{"index":1,"code":"unknown_metric_type","message":"unknown metric type \"histogram\""}}`,
+			expectedMetrics: map[string]utils.Metrics{},
+		},
+		{
+			name: "Negative #3 counter without delta",
+			args: args{
+				r: httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(
+					`[{"id":"c","type":"counter"}]`)),
+				storage: storage.NewMemStorage(&sync.Map{}),
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":{"index":0,"code":"missing_value","message":"delta is required for counter"}}`,
+			expectedMetrics: map[string]utils.Metrics{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -81,10 +116,94 @@ func TestUpdates(t *testing.T) {
 			r.HandleContext(ctx)
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
-			if tt.expectedStatus == http.StatusOK {
+			if tt.expectedBody != "" {
+				assert.Contains(t, rr.Body.String(), tt.expectedBody)
+			}
+			if tt.expectedMetrics != nil {
 				metrics := tt.args.storage.GetAllMetrics()
 				assert.Equal(t, tt.expectedMetrics, metrics)
 			}
 		})
 	}
 }
+
+func TestUpdatesPartial(t *testing.T) {
+	tests := []struct {
+		expectedMetrics map[string]utils.Metrics
+		name            string
+		body            string
+		expectedBody    string
+		expectedStatus  int
+	}{
+		{
+			name:           "Positive #1 all items valid",
+			body:           `[{"id":"a","type":"gauge","value":1.5},{"id":"b","type":"counter","delta":2}]`,
+			expectedStatus: http.StatusOK,
+			expectedMetrics: map[string]utils.Metrics{
+				"a": utils.NewMetrics("a", 1.5, false),
+				"b": utils.NewMetrics("b", 2, true),
+			},
+		},
+		{
+			name:           "Positive #2 invalid items are reported",
+			body:           `[{"id":"a","type":"gauge","value":1.5},{"id":"bad name","type":"gauge","value":1},{"id":"c","type":"counter"}]`,
+			expectedStatus: http.StatusMultiStatus,
+			expectedBody: `{"applied":[0],"rejected":[` +
+				`{"index":1,"code":"invalid_name","message":"metric name \"bad name\" contains forbidden characters"},` +
+				`{"index":2,"code":"missing_value","message":"delta is required for counter"}]}`,
+			expectedMetrics: map[string]utils.Metrics{
+				"a": utils.NewMetrics("a", 1.5, false),
+			},
+		},
+		{
+			name:            "Negative #1 invalid JSON",
+			body:            `not json`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMetrics: map[string]utils.Metrics{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := storage.NewMemStorage(&sync.Map{})
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/updates", func(ctx *gin.Context) {
+				UpdatesPartial(ctx, st)
+			})
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.Equal(t, tt.expectedMetrics, st.GetAllMetrics())
+		})
+	}
+}
+
+// failingBeginPool is a storage.PgxPooler that cannot start a transaction.
+type failingBeginPool struct {
+	storage.PgxPooler
+}
+
+func (failingBeginPool) Begin(context.Context) (pgx.Tx, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUpdates_BeginTransactionFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	st := &storage.DBStorage{Pool: failingBeginPool{}}
+	r.POST("/updates/", func(c *gin.Context) {
+		Updates(c, st)
+	})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"g","type":"gauge","value":1}]`))
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"internal"`)
+}
@@ -0,0 +1,96 @@
+// Package handlers implements HTTP handlers for the metrics server.
+//
+// This file contains validation rules applied to incoming metrics.
+package handlers
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// MaxNameLength is the longest accepted metric name.
+// It matches the width of the "ID" column in public.metrics.
+const MaxNameLength = 255
+
+// namePattern lists the characters allowed in a metric name.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// validateName checks that name is non-empty, not longer than MaxNameLength,
+// consists only of letters, digits and the characters "_.:-" and does not
+// start with telemetry.ReservedPrefix.
+func validateName(name string) (apierror.Error, bool) {
+	switch {
+	case name == "":
+		return apierror.New(apierror.CodeMissingName, "metric name is missing"), false
+	case len(name) > MaxNameLength:
+		return apierror.New(apierror.CodeInvalidName, "metric name is longer than "+strconv.Itoa(MaxNameLength)+" bytes"), false
+	case !namePattern.MatchString(name):
+		return apierror.New(apierror.CodeInvalidName, "metric name "+strconv.Quote(name)+" contains forbidden characters"), false
+	case strings.HasPrefix(name, telemetry.ReservedPrefix):
+		return apierror.New(apierror.CodeInvalidName, "metric names starting with "+strconv.Quote(telemetry.ReservedPrefix)+" are reserved for the server"), false
+	}
+	return apierror.Error{}, true
+}
+
+// validateGauge rejects NaN and infinite gauge values.
+func validateGauge(v float64) (apierror.Error, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return apierror.New(apierror.CodeInvalidValue, "gauge value must be finite"), false
+	}
+	return apierror.Error{}, true
+}
+
+// validateDeclared rejects a metric whose type differs from the one
+// declared in metadata.Default.
+func validateDeclared(name, mType string) (apierror.Error, bool) {
+	if err := metadata.Default.Check(name, mType); err != nil {
+		return apierror.New(apierror.CodeTypeMismatch, err.Error()), false
+	}
+	return apierror.Error{}, true
+}
+
+// validateMetric checks the name, type and value of a metric received as JSON.
+func validateMetric(m utils.Metrics) (apierror.Error, bool) {
+	if e, ok := validateName(m.ID); !ok {
+		return e, false
+	}
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			return apierror.New(apierror.CodeMissingValue, "delta is required for counter"), false
+		}
+	case "gauge":
+		if m.Value == nil {
+			return apierror.New(apierror.CodeMissingValue, "value is required for gauge"), false
+		}
+		if e, ok := validateGauge(*m.Value); !ok {
+			return e, false
+		}
+	default:
+		return apierror.New(apierror.CodeUnknownMetricType, "unknown metric type "+strconv.Quote(m.MType)), false
+	}
+	return validateDeclared(m.ID, m.MType)
+}
+
+// validateItem runs validateMetric on the batch item at position i
+// and tags a failure with that index.
+func validateItem(i int, m utils.Metrics) (apierror.Error, bool) {
+	e, ok := validateMetric(m)
+	if !ok {
+		e.Index = &i
+	}
+	return e, ok
+}
+
+// seriesError describes the rejection of a new series by a series limit,
+// see storage.AdmitSeries.
+func seriesError(err error) apierror.Error {
+	return apierror.New(apierror.CodeSeriesLimit, err.Error())
+}
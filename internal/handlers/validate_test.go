@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMetric(t *testing.T) {
+	delta := int64(1)
+	value := 1.5
+	tests := []struct {
+		name         string
+		expectedCode string
+		metric       utils.Metrics
+		ok           bool
+	}{
+		{
+			name:   "Positive #1 counter",
+			metric: utils.Metrics{ID: "PollCount", MType: "counter", Delta: &delta},
+			ok:     true,
+		},
+		{
+			name:   "Positive #2 gauge with punctuation in name",
+			metric: utils.Metrics{ID: "host-1.cpu:user_0", MType: "gauge", Value: &value},
+			ok:     true,
+		},
+		{
+			name:         "Negative #1 empty name",
+			metric:       utils.Metrics{MType: "gauge", Value: &value},
+			expectedCode: apierror.CodeMissingName,
+		},
+		{
+			name:         "Negative #2 name with space",
+			metric:       utils.Metrics{ID: "bad name", MType: "gauge", Value: &value},
+			expectedCode: apierror.CodeInvalidName,
+		},
+		{
+			name:         "Negative #3 name too long",
+			metric:       utils.Metrics{ID: strings.Repeat("a", MaxNameLength+1), MType: "gauge", Value: &value},
+			expectedCode: apierror.CodeInvalidName,
+		},
+		{
+			name:         "Negative #4 unknown type",
+			metric:       utils.Metrics{ID: "x", MType: "summary", Value: &value},
+			expectedCode: apierror.CodeUnknownMetricType,
+		},
+		{
+			name:         "Negative #5 gauge without value",
+			metric:       utils.Metrics{ID: "x", MType: "gauge", Delta: &delta},
+			expectedCode: apierror.CodeMissingValue,
+		},
+		{
+			name:         "Negative #6 counter without delta",
+			metric:       utils.Metrics{ID: "x", MType: "counter", Value: &value},
+			expectedCode: apierror.CodeMissingValue,
+		},
+		{
+			name:         "Negative #7 reserved prefix",
+			metric:       utils.Metrics{ID: "_server.http_requests_total", MType: "gauge", Value: &value},
+			expectedCode: apierror.CodeInvalidName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := validateMetric(tt.metric)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expectedCode, e.Code)
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/storage"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
@@ -20,11 +21,12 @@ import (
 //
 // Supports:
 // - GET /value/:metric_type/:metric_name
+// - GET /value/:metric_type/:metric_name?fn=rate&window=1m for derived values (see Derived)
 // - POST with JSON body containing metric type and name
 //
 // Returns:
 // - 200 OK with metric value as string if found
-// - 404 Not Found if metric doesn't exist or type mismatch
+// - 404 Not Found with an apierror.Response if metric doesn't exist or type mismatch
 func Value(c *gin.Context, st storage.Storage) {
 	metricType := c.Param("metric_type")
 	metricName := c.Param("metric_name")
@@ -34,11 +36,16 @@ func Value(c *gin.Context, st storage.Storage) {
 			ValueWithJSON(c, st)
 			return
 		} else {
-			c.AbortWithStatus(http.StatusNotFound)
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeMissingName, "metric type and name are required")
 			return
 		}
 	}
 
+	if fn := c.Query("fn"); fn != "" {
+		Derived(c, st, strings.ToLower(metricType), metricName, fn)
+		return
+	}
+
 	metricValue, found := st.GetMetric(metricName)
 	if found {
 		if strings.EqualFold(strings.ToLower(metricType), strings.ToLower(metricValue.MType)) {
@@ -53,37 +60,39 @@ func Value(c *gin.Context, st storage.Storage) {
 				return
 			}
 		} else {
-			c.String(http.StatusNotFound, "")
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeMetricNotFound, "metric "+metricName+" has type "+metricValue.MType)
 			return
 		}
 	} else {
-		c.String(http.StatusNotFound, "")
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeMetricNotFound, "metric "+metricName+" not found")
 		return
 	}
 }
 
 // ValueWithJSON handles metric value retrieval via JSON request body.
 //
-// Binds incoming JSON to utils.Metrics struct and returns the value if found.
+// Binds incoming JSON to utils.Metrics struct and returns the value if found,
+// annotated with unit and help from its declaration.
 // Responds with:
 // - 200 OK and metric value if successful
 // - 404 Not Found if metric not found or type mismatch
 // - 400 Bad Request if JSON binding fails
+//
+// Errors are reported with an apierror.Response body.
 func ValueWithJSON(c *gin.Context, st storage.Storage) {
 	var m utils.Metrics
 
 	if err := c.ShouldBindJSON(&m); err == nil {
 		metricValue, found := st.GetMetric(m.ID)
 		if found && strings.EqualFold(strings.ToLower(m.MType), strings.ToLower(metricValue.MType)) {
-			c.JSON(http.StatusOK, metricValue)
+			c.JSON(http.StatusOK, annotate(metricValue))
 			return
 		} else {
-			c.String(http.StatusNotFound, "")
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeMetricNotFound, "metric "+m.ID+" not found")
 			return
 		}
 	} else {
-		println(err.Error())
-		c.String(http.StatusBadRequest, "")
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error())
 		return
 	}
 }
@@ -1,25 +1,64 @@
 // Package logger provides a global zap.Logger instance for structured logging.
 //
-// It allows initialization with custom log level and is used across the application
-// to log events, errors, and operational information.
+// It allows initialization with custom log level, format and output and is
+// used across the application to log events, errors, and operational information.
 package logger
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
 
 // Log is a global logger instance initialized during application startup.
 var Log *zap.Logger
 
+// Log formats accepted by Options.Format.
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Options configures the global logger.
+type Options struct {
+	Level  string // debug, info, warn, error, dpanic, panic или fatal
+	Format string // json (по умолчанию) или console
+	Output string // stderr (по умолчанию), stdout или путь к файлу
+}
+
 // Initialize configures and builds a new zap.Logger with the specified log level.
 //
-// Uses zap.NewProductionConfig() as base configuration.
-// Returns error if level parsing or logger creation fails.
+// It is Configure with the default format and output.
 func Initialize(level string) error {
-	lvl, err := zap.ParseAtomicLevel(level)
+	return Configure(Options{Level: level})
+}
+
+// Configure builds a new zap.Logger from opts and makes it the global one.
+//
+// Uses zap.NewProductionConfig() as base configuration; the console format
+// writes human-readable lines with ISO 8601 timestamps.
+// Returns error if an option is invalid or logger creation fails, in which
+// case the current logger is kept.
+func Configure(opts Options) error {
+	lvl, err := zap.ParseAtomicLevel(opts.Level)
 	if err != nil {
 		return err
 	}
 	cfg := zap.NewProductionConfig()
 	cfg.Level = lvl
+	switch opts.Format {
+	case "", FormatJSON:
+	case FormatConsole:
+		cfg.Encoding = FormatConsole
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	default:
+		return fmt.Errorf("unknown log format %q, want %s or %s", opts.Format, FormatJSON, FormatConsole)
+	}
+	if opts.Output != "" {
+		cfg.OutputPaths = []string{opts.Output}
+	}
 	zl, err := cfg.Build()
 	if err != nil {
 		return err
@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +45,30 @@ func TestInitialize_WithValidLevels(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigure(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "json.log")
+	consolePath := filepath.Join(dir, "console.log")
+
+	assert.NoError(t, Configure(Options{Level: "info", Output: jsonPath}))
+	Log.Info("hello")
+	assert.NoError(t, Log.Sync())
+	assert.NoError(t, Configure(Options{Level: "info", Format: FormatConsole, Output: consolePath}))
+	Log.Debug("hidden")
+	Log.Info("hello")
+	assert.NoError(t, Log.Sync())
+
+	data, err := os.ReadFile(jsonPath)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "{"), string(data))
+	data, err = os.ReadFile(consolePath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "\tINFO\t")
+	assert.Contains(t, string(data), "hello")
+	assert.NotContains(t, string(data), "hidden")
+
+	current := Log
+	assert.Error(t, Configure(Options{Level: "info", Format: "xml"}))
+	assert.Same(t, current, Log)
+}
@@ -0,0 +1,134 @@
+// Package metadata implements a registry of declared metrics.
+//
+// A declaration fixes the type of a metric and describes it with a unit,
+// a help text and the label names it may carry. The server enforces the
+// declared type on ingest and uses unit and help when rendering metrics.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Metadata describes a single declared metric.
+type Metadata struct {
+	ID     string   `json:"id"`               // имя метрики
+	MType  string   `json:"type"`             // gauge или counter
+	Unit   string   `json:"unit,omitempty"`   // единица измерения: bytes, ns, percent...
+	Help   string   `json:"help,omitempty"`   // описание метрики
+	Labels []string `json:"labels,omitempty"` // допустимые имена меток
+}
+
+// ErrTypeMismatch is returned by Check when a metric is sent with a type
+// other than the declared one.
+var ErrTypeMismatch = errors.New("metric type does not match declaration")
+
+// labelPattern lists the characters allowed in a label name.
+var labelPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Default is the registry used by the server handlers.
+var Default = NewRegistry()
+
+// Registry stores declarations keyed by metric name.
+//
+// Uses sync.Map for thread-safe operations.
+type Registry struct {
+	declared *sync.Map
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		declared: &sync.Map{},
+	}
+}
+
+// Validate checks that md has a name, a known type and well-formed label names.
+func (md Metadata) Validate() error {
+	if md.ID == "" {
+		return errors.New("metric name is missing")
+	}
+	if md.MType != "gauge" && md.MType != "counter" {
+		return fmt.Errorf("unknown metric type %q", md.MType)
+	}
+	for _, l := range md.Labels {
+		if !labelPattern.MatchString(l) {
+			return fmt.Errorf("invalid label name %q", l)
+		}
+	}
+	return nil
+}
+
+// Declare validates md and stores it, replacing any previous declaration
+// of the same metric.
+func (r *Registry) Declare(md Metadata) error {
+	if err := md.Validate(); err != nil {
+		return err
+	}
+	r.declared.Store(md.ID, md)
+	return nil
+}
+
+// Get returns the declaration of the metric id.
+//
+// Returns the declaration and true if found, empty Metadata and false otherwise.
+func (r *Registry) Get(id string) (Metadata, bool) {
+	value, found := r.declared.Load(id)
+	if !found {
+		return Metadata{}, false
+	}
+	md, ok := value.(Metadata)
+	return md, ok
+}
+
+// All returns every declaration sorted by metric name.
+func (r *Registry) All() []Metadata {
+	result := []Metadata{}
+	r.declared.Range(func(key, value interface{}) bool {
+		if md, ok := value.(Metadata); ok {
+			result = append(result, md)
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Check verifies an incoming metric against its declaration.
+//
+// Undeclared metrics are always accepted. Returns ErrTypeMismatch if
+// the metric is declared with another type.
+func (r *Registry) Check(id, mType string) error {
+	md, found := r.Get(id)
+	if !found || md.MType == mType {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is declared as %s", ErrTypeMismatch, id, md.MType)
+}
+
+// LoadFile reads a JSON array of declarations from path and declares each of them.
+//
+// Stops at the first invalid declaration and returns its error.
+func (r *Registry) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []Metadata
+	if err = json.Unmarshal(content, &list); err != nil {
+		return err
+	}
+	for _, md := range list {
+		if err = r.Declare(md); err != nil {
+			return fmt.Errorf("%s: %w", md.ID, err)
+		}
+	}
+	return nil
+}
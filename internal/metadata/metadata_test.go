@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Declare(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      Metadata
+		wantErr bool
+	}{
+		{
+			name: "Positive #1 gauge with unit and labels",
+			md:   Metadata{ID: "Alloc", MType: "gauge", Unit: "bytes", Help: "Allocated bytes", Labels: []string{"host"}},
+		},
+		{
+			name:    "Negative #1 no name",
+			md:      Metadata{MType: "gauge"},
+			wantErr: true,
+		},
+		{
+			name:    "Negative #2 unknown type",
+			md:      Metadata{ID: "Alloc", MType: "histogram"},
+			wantErr: true,
+		},
+		{
+			name:    "Negative #3 invalid label",
+			md:      Metadata{ID: "Alloc", MType: "gauge", Labels: []string{"1host"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			err := r.Declare(tt.md)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, r.All())
+				return
+			}
+			require.NoError(t, err)
+			got, found := r.Get(tt.md.ID)
+			assert.True(t, found)
+			assert.Equal(t, tt.md, got)
+		})
+	}
+}
+
+func TestRegistry_Check(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Declare(Metadata{ID: "PollCount", MType: "counter"}))
+
+	assert.NoError(t, r.Check("PollCount", "counter"))
+	assert.NoError(t, r.Check("Undeclared", "gauge"))
+	assert.True(t, errors.Is(r.Check("PollCount", "gauge"), ErrTypeMismatch))
+}
+
+func TestRegistry_LoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.json")
+	require.NoError(t, os.WriteFile(good, []byte(`[
+		{"id":"b","type":"gauge","unit":"bytes"},
+		{"id":"a","type":"counter","help":"A counter"}
+	]`), 0o600))
+	r := NewRegistry()
+	require.NoError(t, r.LoadFile(good))
+	assert.Equal(t, []Metadata{
+		{ID: "a", MType: "counter", Help: "A counter"},
+		{ID: "b", MType: "gauge", Unit: "bytes"},
+	}, r.All())
+
+	bad := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(bad, []byte(`[{"id":"c","type":"summary"}]`), 0o600))
+	assert.Error(t, NewRegistry().LoadFile(bad))
+
+	assert.Error(t, NewRegistry().LoadFile(filepath.Join(dir, "missing.json")))
+}
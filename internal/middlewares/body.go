@@ -0,0 +1,85 @@
+// Package middlewares implements custom middleware functions for the Gin router.
+//
+// This file contains BufferBody, the first stage of the request body pipeline.
+//
+// The body is read from the network once, into a pooled buffer, and every
+// later stage works on the bytes in memory:
+//
+//  1. BufferBody reads the raw body;
+//  2. Crypto decrypts it, if the encryption policy of the route group asks for it;
+//  3. Decompress decompresses it;
+//  4. HashCheck verifies its signature;
+//
+// after which handlers read the result. Each stage passes its output on as
+// a utils.Body, so utils.ReadBody gives the next one the bytes without a copy.
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// maxPooledBuffer keeps buffers grown by unusually large bodies out of the
+// pool, so that one big request does not pin its memory for good.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// getBuffer takes an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool. Its bytes must no longer be referenced.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// BufferBody returns a Gin middleware that reads the raw request body into
+// a pooled buffer and replaces it with a utils.Body.
+//
+// Bodies larger than maxSize bytes are rejected with 413. The buffer goes
+// back to the pool once the handlers have run, so neither middlewares nor
+// handlers may keep references to the body bytes past the request.
+func BufferBody(maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if c.Request.ContentLength > 0 && c.Request.ContentLength <= maxSize {
+			buf.Grow(int(c.Request.ContentLength))
+		}
+		n, err := buf.ReadFrom(http.MaxBytesReader(c.Writer, c.Request.Body, maxSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+					fmt.Sprintf("request body exceeds %d bytes", maxSize))
+				return
+			}
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
+			return
+		}
+
+		c.Request.Body = utils.NewBody(buf.Bytes())
+		c.Request.ContentLength = n
+		c.Next()
+	}
+}
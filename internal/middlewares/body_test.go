@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(BufferBody(16))
+	r.POST("/test", func(c *gin.Context) {
+		_, isBody := c.Request.Body.(*utils.Body)
+		assert.True(t, isBody)
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("POST", "/test", strings.NewReader("small body")))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "small body", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("POST", "/test", strings.NewReader("body over sixteen bytes")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"body_too_large"`)
+}
+
+func TestBodyPipeline(t *testing.T) {
+	const key = "secret_key"
+	logger.Initialize("fatal")
+	signing, err := server.LoadSigningKeys(key, "")
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(BufferBody(1024), Crypto(nil, nil, EncryptionRequire, true), Decompress(1024), WithLogging())
+	r.POST("/test", HashCheck(signing, nil), func(c *gin.Context) {
+		body, err := utils.ReadBody(c.Request)
+		require.NoError(t, err)
+		c.String(http.StatusOK, string(body))
+	})
+
+	const original = `[{"id":"a","type":"counter","delta":1}]`
+	compressed, err := utils.Compress(utils.EncodingZstd, []byte(original))
+	require.NoError(t, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := httptest.NewRequest("POST", "/test", strings.NewReader(string(compressed)))
+	req.Header.Set("Content-Encoding", utils.EncodingZstd)
+	req.Header.Set(utils.TimestampHeader, timestamp)
+	req.Header.Set(utils.NonceHeader, "n1")
+	req.Header.Set("HashSHA256", utils.CalculateHashWithKey(utils.SignedMessage(timestamp, "n1", []byte(original)), key))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, original, resp.Body.String())
+	assert.Equal(t, utils.CalculateHashWithKey([]byte(original), key), resp.Header().Get("HashSHA256"))
+}
@@ -1,75 +1,262 @@
 package middlewares
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"go.uber.org/zap"
 )
 
-func decrypt(payload *utils.EncryptedPayload, privKey *rsa.PrivateKey) ([]byte, error) {
-	encryptedAESKey, err := base64.StdEncoding.DecodeString(payload.EncryptedAESKey)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка декодирования AES ключа: %v", err)
+// errKeyType means a key cannot be used with the algorithm of a payload.
+var errKeyType = errors.New("ключ не подходит для алгоритма")
+
+// decrypt opens a v1 or v2 payload with one of the keys.
+//
+// v1 payloads wrap the AES key with RSA PKCS#1 v1.5 and are accepted for
+// backward compatibility only with legacyV1; a payload without a version is
+// a v1 one. v2 payloads use RSA-OAEP-256 or ECDH-ES. If the
+// key ID of the payload is known only that key is used, otherwise every key
+// is tried in turn; the GCM tag tells whether a key was the right one.
+//
+// Returns the plaintext and the AES key it was encrypted with.
+func decrypt(payload *utils.EncryptedPayload, keys *server.Keyring, legacyV1 bool) ([]byte, []byte, error) {
+	switch {
+	case payload.Version == 0 || payload.Version == utils.PayloadV1:
+		if !legacyV1 {
+			return nil, nil, fmt.Errorf("конверт версии %d больше не принимается", payload.Version)
+		}
+	case payload.Version != utils.PayloadV2:
+		return nil, nil, fmt.Errorf("неподдерживаемая версия конверта %d", payload.Version)
+	case payload.Alg != utils.AlgRSAOAEP256 && payload.Alg != utils.AlgECDHES:
+		return nil, nil, fmt.Errorf("неподдерживаемый алгоритм %q", payload.Alg)
 	}
 
 	cipherText, err := base64.StdEncoding.DecodeString(payload.CipherText)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка декодирования данных: %v", err)
+		return nil, nil, fmt.Errorf("ошибка декодирования данных: %v", err)
 	}
 
 	nonce, err := base64.StdEncoding.DecodeString(payload.Nonce)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка декодирования nonce: %v", err)
+		return nil, nil, fmt.Errorf("ошибка декодирования nonce: %v", err)
+	}
+
+	candidates := keys.Keys()
+	if key, found := keys.Lookup(payload.KeyID); found {
+		candidates = []server.PrivateKey{key}
 	}
+	lastErr := errors.New("нет ключа для расшифровки")
+	for _, key := range candidates {
+		plainText, aesKey, err := open(payload, key, cipherText, nonce)
+		if err == nil {
+			return plainText, aesKey, nil
+		}
+		if !errors.Is(err, errKeyType) {
+			lastErr = err
+		}
+	}
+	return nil, nil, lastErr
+}
 
-	aesKey, err := rsa.DecryptPKCS1v15(rand.Reader, privKey, encryptedAESKey)
+// open recovers the AES key of payload with key and decrypts the data.
+func open(payload *utils.EncryptedPayload, key server.PrivateKey, cipherText, nonce []byte) ([]byte, []byte, error) {
+	aesKey, err := unwrapKey(payload, key)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка расшифровки AES ключа: %v", err)
+		return nil, nil, err
 	}
 
 	block, err := aes.NewCipher(aesKey)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания AES шифра: %v", err)
+		return nil, nil, fmt.Errorf("ошибка создания AES шифра: %v", err)
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания GCM: %v", err)
+		return nil, nil, fmt.Errorf("ошибка создания GCM: %v", err)
 	}
 
-	plainText, err := gcm.Open(nil, nonce, cipherText, nil)
+	plainText, err := gcm.Open(nil, nonce, cipherText, payload.AAD())
 	if err != nil {
-		return nil, fmt.Errorf("ошибка расшифровки данных: %v", err)
+		return nil, nil, fmt.Errorf("ошибка расшифровки данных: %v", err)
 	}
+	return plainText, aesKey, nil
+}
 
-	return plainText, nil
+// unwrapKey recovers the AES key of payload with key.
+//
+// Returns errKeyType if key does not fit the algorithm of the payload.
+func unwrapKey(payload *utils.EncryptedPayload, key server.PrivateKey) ([]byte, error) {
+	if payload.Alg == utils.AlgECDHES {
+		if key.ECDH == nil {
+			return nil, errKeyType
+		}
+		epkBytes, err := base64.StdEncoding.DecodeString(payload.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка декодирования эфемерного ключа: %v", err)
+		}
+		epk, err := key.ECDH.Curve().NewPublicKey(epkBytes)
+		if err != nil {
+			return nil, errKeyType
+		}
+		secret, err := key.ECDH.ECDH(epk)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка ECDH: %v", err)
+		}
+		return utils.DeriveECDHKey(secret, epk, key.ECDH.PublicKey())
+	}
+
+	if key.RSA == nil {
+		return nil, errKeyType
+	}
+	encryptedAESKey, err := base64.StdEncoding.DecodeString(payload.EncryptedAESKey)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка декодирования AES ключа: %v", err)
+	}
+	var aesKey []byte
+	if payload.Version == utils.PayloadV2 {
+		aesKey, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key.RSA, encryptedAESKey, nil)
+	} else {
+		aesKey, err = rsa.DecryptPKCS1v15(rand.Reader, key.RSA, encryptedAESKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка расшифровки AES ключа: %v", err)
+	}
+	return aesKey, nil
+}
+
+// EncryptionPolicy tells Crypto which requests of a route group must be encrypted.
+type EncryptionPolicy string
+
+const (
+	// EncryptionRequire accepts only encrypted requests. The body is parsed
+	// as an EncryptedPayload whatever its Content-Type, so agents that predate
+	// content negotiation keep working. It is also what the zero value means.
+	EncryptionRequire EncryptionPolicy = "require"
+	// EncryptionAllow decrypts requests sent with utils.EncryptedContentType
+	// and passes the others on as plaintext.
+	EncryptionAllow EncryptionPolicy = "allow"
+	// EncryptionOff passes every request on as plaintext and rejects the
+	// ones sent with utils.EncryptedContentType.
+	EncryptionOff EncryptionPolicy = "off"
+)
+
+// ParseEncryptionPolicy parses "require", "allow" or "off".
+func ParseEncryptionPolicy(s string) (EncryptionPolicy, error) {
+	switch p := EncryptionPolicy(s); p {
+	case EncryptionRequire, EncryptionAllow, EncryptionOff:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown encryption policy %q, want require, allow or off", s)
 }
 
-func Crypto(privKey *rsa.PrivateKey) gin.HandlerFunc {
+// Crypto returns a Gin middleware that decrypts request bodies encrypted
+// for one of the server keys, as policy dictates.
+//
+// Without keys every policy acts as EncryptionOff. An encrypted body must be
+// a JSON utils.EncryptedPayload of version 1 or 2 (see decrypt); requests
+// that cannot be parsed or decrypted are aborted with 400, the latter with
+// the same message whatever the cause, encrypted requests
+// that are not accepted with 415, each with an apierror.Response.
+// Keys reloaded into the keyring are picked up by the next request.
+//
+// If guard is set, the envelope must carry a timestamp and a nonce that pass it.
+// Envelopes of version 1 are rejected unless legacyV1 is set.
+//
+// When an encrypted request lists utils.EncryptedContentType in Accept, the
+// response is sealed with the AES key of the request (see utils.SealWithKey).
+func Crypto(keys *server.Keyring, guard *ReplayGuard, policy EncryptionPolicy, legacyV1 bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if privKey != nil {
-			var encryptedPayload utils.EncryptedPayload
-			if err := c.ShouldBindBodyWithJSON(&encryptedPayload); err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
+		marked := c.ContentType() == utils.EncryptedContentType
+		if keys == nil || len(keys.Keys()) == 0 || policy == EncryptionOff {
+			if marked {
+				apierror.Abort(c, http.StatusUnsupportedMediaType, apierror.CodeEncryptionUnsupported,
+					"encrypted requests are not accepted here")
 				return
 			}
+			c.Next()
+			return
+		}
+		if policy == EncryptionAllow && !marked {
+			c.Next()
+			return
+		}
 
-			decrypted, err := decrypt(&encryptedPayload, privKey)
-			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
-				return
-			}
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(decrypted))
+		body, err := utils.ReadBody(c.Request)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
+			return
+		}
+		var encryptedPayload utils.EncryptedPayload
+		if err = json.Unmarshal(body, &encryptedPayload); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "body is not an encrypted payload")
+			return
+		}
+
+		decrypted, aesKey, err := decrypt(&encryptedPayload, keys, legacyV1)
+		if err != nil {
+			// The cause stays in the log: telling a failed key unwrap from a
+			// failed GCM open would make the endpoint a padding oracle.
+			logger.Log.Warn("Crypto", zap.String("error while decrypting payload", err.Error()))
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeDecryptFailed, "failed to decrypt payload")
+			return
+		}
+		if !checkReplay(c, guard, encryptedPayload.Timestamp, encryptedPayload.RequestNonce) {
+			return
 		}
-		c.Next()
+		c.Request.Body = utils.NewBody(decrypted)
+		c.Request.ContentLength = int64(len(decrypted))
+		logDecodedBody(c, decrypted)
+		if marked {
+			c.Request.Header.Set("Content-Type", "application/json")
+		}
+
+		if !strings.Contains(c.GetHeader("Accept"), utils.EncryptedContentType) {
+			c.Next()
+			return
+		}
+		sealResponse(c, aesKey, encryptedPayload.KeyID, encryptedPayload.RequestNonce)
+	}
+}
+
+// sealResponse runs the rest of the chain with the response buffered and
+// writes it sealed with aesKey as a utils.EncryptedPayload.
+func sealResponse(c *gin.Context, aesKey []byte, keyID, requestNonce string) {
+	originalWriter := c.Writer
+	sealedWriter := &bufferedResponseWriter{ResponseWriter: originalWriter, body: getBuffer()}
+	defer putBuffer(sealedWriter.body)
+	c.Writer = sealedWriter
+
+	c.Next()
+
+	c.Writer = originalWriter
+	payload, err := utils.SealWithKey(sealedWriter.body.Bytes(), aesKey, keyID, requestNonce)
+	var sealed []byte
+	if err == nil {
+		sealed, err = json.Marshal(payload)
+	}
+	if err != nil {
+		logger.Log.Error("Crypto", zap.String("error while sealing response", err.Error()))
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to encrypt response")
+		return
+	}
+	originalWriter.Header().Set("Content-Type", utils.EncryptedContentType)
+	originalWriter.Header().Del("Content-Length")
+	originalWriter.WriteHeaderNow()
+	if _, err := originalWriter.Write(sealed); err != nil {
+		logger.Log.Error("Crypto", zap.String("error while writing response", err.Error()))
 	}
 }
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -23,6 +27,7 @@ import (
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func encrypt(plainText []byte, publicKey *rsa.PublicKey) (*utils.EncryptedPayload, error) {
@@ -62,6 +67,91 @@ func encrypt(plainText []byte, publicKey *rsa.PublicKey) (*utils.EncryptedPayloa
 	return payload, nil
 }
 
+func encryptV2(plainText []byte, publicKey *rsa.PublicKey) (*utils.EncryptedPayload, error) {
+	return encryptV2Replay(plainText, publicKey, 0, "")
+}
+
+func encryptV2Replay(plainText []byte, publicKey *rsa.PublicKey, timestamp int64, requestNonce string) (*utils.EncryptedPayload, error) {
+	keyID, err := utils.KeyID(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	payload := &utils.EncryptedPayload{
+		Version:      utils.PayloadV2,
+		Alg:          utils.AlgRSAOAEP256,
+		KeyID:        keyID,
+		Timestamp:    timestamp,
+		RequestNonce: requestNonce,
+	}
+
+	aesKey := make([]byte, 32)
+	if _, err = rand.Read(aesKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payload.EncryptedAESKey = base64.StdEncoding.EncodeToString(encryptedAESKey)
+	payload.CipherText = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plainText, payload.AAD()))
+	payload.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	return payload, nil
+}
+
+func encryptECDH(plainText []byte, pub *ecdh.PublicKey) (*utils.EncryptedPayload, error) {
+	keyID, err := utils.KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := utils.DeriveECDHKey(secret, ephemeral.PublicKey(), pub)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload := &utils.EncryptedPayload{
+		Version:      utils.PayloadV2,
+		Alg:          utils.AlgECDHES,
+		KeyID:        keyID,
+		EphemeralKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+	}
+	payload.CipherText = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plainText, payload.AAD()))
+	return payload, nil
+}
+
 func readPublicKey(file string) *x509.Certificate {
 	pemData, err := os.ReadFile(file)
 	if err != nil {
@@ -82,12 +172,17 @@ func readPublicKey(file string) *x509.Certificate {
 	return cert
 }
 
-func setupTestRouterWithCrypto(privKey *rsa.PrivateKey) *gin.Engine {
+func loadKeys(path string) *server.Keyring {
+	keys, _ := server.LoadKeyring(path)
+	return keys
+}
+
+func setupTestRouterWithCrypto(keys *server.Keyring) *gin.Engine {
 	logger.Initialize("fatal")
 	gin.SetMode(gin.TestMode)
 
 	r := gin.New()
-	r.Use(Crypto(privKey))
+	r.Use(Crypto(keys, nil, EncryptionRequire, true))
 
 	r.POST("/test", func(c *gin.Context) {
 		body, _ := io.ReadAll(c.Request.Body)
@@ -98,8 +193,7 @@ func setupTestRouterWithCrypto(privKey *rsa.PrivateKey) *gin.Engine {
 }
 
 func TestCryptoMiddleware_DecryptsSuccessfully(t *testing.T) {
-	privKey := server.ReadPrivateKey("../../private_key.pem")
-	r := setupTestRouterWithCrypto(privKey)
+	r := setupTestRouterWithCrypto(loadKeys("../../private_key.pem"))
 
 	originalData := []byte(`{"test": "value"}`)
 	encrypted, err := encrypt(originalData, readPublicKey("../../cert.pem").PublicKey.(*rsa.PublicKey))
@@ -117,8 +211,7 @@ func TestCryptoMiddleware_DecryptsSuccessfully(t *testing.T) {
 }
 
 func TestCryptoMiddleware_InvalidJSON(t *testing.T) {
-	privKey := server.ReadPrivateKey("../../private_key.pem")
-	r := setupTestRouterWithCrypto(privKey)
+	r := setupTestRouterWithCrypto(loadKeys("../../private_key.pem"))
 
 	req, _ := http.NewRequest("POST", "/test", strings.NewReader("not a JSON"))
 	resp := httptest.NewRecorder()
@@ -129,8 +222,7 @@ func TestCryptoMiddleware_InvalidJSON(t *testing.T) {
 }
 
 func TestCryptoMiddleware_NoCryptoKey(t *testing.T) {
-	privKey := server.ReadPrivateKey("")
-	r := setupTestRouterWithCrypto(privKey)
+	r := setupTestRouterWithCrypto(loadKeys(""))
 
 	originalData := []byte(`{"test": "value"}`)
 
@@ -142,3 +234,317 @@ func TestCryptoMiddleware_NoCryptoKey(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.Code)
 	assert.Equal(t, string(originalData), resp.Body.String())
 }
+
+func TestCryptoMiddleware_V2(t *testing.T) {
+	r := setupTestRouterWithCrypto(loadKeys("../../private_key.pem"))
+	originalData := []byte(`{"test": "value"}`)
+
+	tests := []struct {
+		name           string
+		tamper         func(p *utils.EncryptedPayload)
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 v2 envelope",
+			tamper:         func(p *utils.EncryptedPayload) {},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Negative #1 unknown key id",
+			tamper:         func(p *utils.EncryptedPayload) { p.KeyID = "0000000000000000" },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative #2 unsupported algorithm",
+			tamper:         func(p *utils.EncryptedPayload) { p.Alg = "RSA1_5" },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative #3 downgrade to v1",
+			tamper:         func(p *utils.EncryptedPayload) { p.Version = utils.PayloadV1 },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative #4 unknown version",
+			tamper:         func(p *utils.EncryptedPayload) { p.Version = 9 },
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := encryptV2(originalData, readPublicKey("../../cert.pem").PublicKey.(*rsa.PublicKey))
+			assert.NoError(t, err)
+			assert.Equal(t, utils.PayloadV2, encrypted.Version)
+			tt.tamper(encrypted)
+
+			bodyBytes, _ := json.Marshal(encrypted)
+			req, _ := http.NewRequest("POST", "/test", bytes.NewReader(bodyBytes))
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, string(originalData), resp.Body.String())
+			} else {
+				// Every failure reads the same, whatever stage rejected the envelope
+				assert.JSONEq(t, `{"error":{"code":"decrypt_failed","message":"failed to decrypt payload"}}`, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestCryptoMiddleware_RejectLegacyV1(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Crypto(loadKeys("../../private_key.pem"), nil, EncryptionRequire, false))
+	r.POST("/test", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	originalData := []byte(`{"test": "value"}`)
+	publicKey := readPublicKey("../../cert.pem").PublicKey.(*rsa.PublicKey)
+
+	for _, version := range []int{0, utils.PayloadV1, utils.PayloadV2} {
+		var encrypted *utils.EncryptedPayload
+		var err error
+		if version == utils.PayloadV2 {
+			encrypted, err = encryptV2(originalData, publicKey)
+		} else {
+			encrypted, err = encrypt(originalData, publicKey)
+			encrypted.Version = version
+		}
+		require.NoError(t, err)
+
+		bodyBytes, _ := json.Marshal(encrypted)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(bodyBytes)))
+
+		if version == utils.PayloadV2 {
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, string(originalData), resp.Body.String())
+		} else {
+			assert.Equal(t, http.StatusBadRequest, resp.Code, "version %d", version)
+			assert.JSONEq(t, `{"error":{"code":"decrypt_failed","message":"failed to decrypt payload"}}`, resp.Body.String())
+		}
+	}
+}
+
+func TestCryptoMiddleware_KeyRotation(t *testing.T) {
+	newKey := func(t *testing.T, key interface{}) server.PrivateKey {
+		var pk server.PrivateKey
+		var err error
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			pk.RSA = k
+			pk.ID, err = utils.KeyID(&k.PublicKey)
+		case *ecdh.PrivateKey:
+			pk.ECDH = k
+			pk.ID, err = utils.KeyID(k.PublicKey())
+		}
+		assert.NoError(t, err)
+		return pk
+	}
+	oldRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	newRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	p256, err := ecKey.ECDH()
+	assert.NoError(t, err)
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	r := setupTestRouterWithCrypto(server.NewKeyring(
+		newKey(t, oldRSA), newKey(t, p256), newKey(t, x25519), newKey(t, newRSA),
+	))
+	originalData := []byte(`{"test": "value"}`)
+
+	tests := []struct {
+		encrypt        func() (*utils.EncryptedPayload, error)
+		name           string
+		expectedStatus int
+	}{
+		{
+			name:           "Positive #1 v2 for the second RSA key",
+			encrypt:        func() (*utils.EncryptedPayload, error) { return encryptV2(originalData, &newRSA.PublicKey) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Positive #2 v2 without key id by trial decryption",
+			encrypt: func() (*utils.EncryptedPayload, error) {
+				p, err := encryptV2(originalData, &newRSA.PublicKey)
+				p.KeyID = ""
+				p.CipherText = reseal(t, newRSA, p, originalData)
+				return p, err
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Positive #3 v1 for the second RSA key",
+			encrypt:        func() (*utils.EncryptedPayload, error) { return encrypt(originalData, &newRSA.PublicKey) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Positive #4 ECDH P-256",
+			encrypt:        func() (*utils.EncryptedPayload, error) { return encryptECDH(originalData, p256.PublicKey()) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Positive #5 ECDH X25519",
+			encrypt:        func() (*utils.EncryptedPayload, error) { return encryptECDH(originalData, x25519.PublicKey()) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Negative #1 key not in the keyring",
+			encrypt: func() (*utils.EncryptedPayload, error) {
+				other, err := ecdh.X25519().GenerateKey(rand.Reader)
+				assert.NoError(t, err)
+				return encryptECDH(originalData, other.PublicKey())
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := tt.encrypt()
+			assert.NoError(t, err)
+
+			bodyBytes, _ := json.Marshal(encrypted)
+			req, _ := http.NewRequest("POST", "/test", bytes.NewReader(bodyBytes))
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, string(originalData), resp.Body.String())
+			}
+		})
+	}
+}
+
+// reseal encrypts plainText again under the AES key of p, so that changes
+// to the authenticated header fields of p stay valid.
+func reseal(t *testing.T, key *rsa.PrivateKey, p *utils.EncryptedPayload, plainText []byte) string {
+	wrapped, err := base64.StdEncoding.DecodeString(p.EncryptedAESKey)
+	assert.NoError(t, err)
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, wrapped, nil)
+	assert.NoError(t, err)
+	nonce, err := base64.StdEncoding.DecodeString(p.Nonce)
+	assert.NoError(t, err)
+	block, err := aes.NewCipher(aesKey)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plainText, p.AAD()))
+}
+
+func TestParseEncryptionPolicy(t *testing.T) {
+	for _, s := range []string{"require", "allow", "off"} {
+		p, err := ParseEncryptionPolicy(s)
+		assert.NoError(t, err)
+		assert.Equal(t, EncryptionPolicy(s), p)
+	}
+	_, err := ParseEncryptionPolicy("optional")
+	assert.Error(t, err)
+}
+
+func TestCrypto_Policies(t *testing.T) {
+	logger.Initialize("fatal")
+	gin.SetMode(gin.TestMode)
+	keys := loadKeys("../../private_key.pem")
+	originalData := []byte(`{"test": "value"}`)
+	encrypted, err := encryptV2(originalData, readPublicKey("../../cert.pem").PublicKey.(*rsa.PublicKey))
+	assert.NoError(t, err)
+	encryptedBody, _ := json.Marshal(encrypted)
+
+	tests := []struct {
+		name           string
+		keys           *server.Keyring
+		policy         EncryptionPolicy
+		contentType    string
+		body           []byte
+		expectedStatus int
+	}{
+		{name: "allow plaintext", keys: keys, policy: EncryptionAllow, contentType: "application/json", body: originalData, expectedStatus: http.StatusOK},
+		{name: "allow without body", keys: keys, policy: EncryptionAllow, expectedStatus: http.StatusOK},
+		{name: "allow encrypted", keys: keys, policy: EncryptionAllow, contentType: utils.EncryptedContentType, body: encryptedBody, expectedStatus: http.StatusOK},
+		{name: "allow unmarked envelope is plaintext", keys: keys, policy: EncryptionAllow, contentType: "application/json", body: encryptedBody, expectedStatus: http.StatusOK},
+		{name: "require encrypted", keys: keys, policy: EncryptionRequire, contentType: utils.EncryptedContentType, body: encryptedBody, expectedStatus: http.StatusOK},
+		{name: "require legacy envelope", keys: keys, policy: EncryptionRequire, contentType: "application/json", body: encryptedBody, expectedStatus: http.StatusOK},
+		{name: "require plaintext", keys: keys, policy: EncryptionRequire, contentType: "application/json", body: originalData, expectedStatus: http.StatusBadRequest},
+		{name: "require without body", keys: keys, policy: EncryptionRequire, expectedStatus: http.StatusBadRequest},
+		{name: "off plaintext", keys: keys, policy: EncryptionOff, contentType: "application/json", body: originalData, expectedStatus: http.StatusOK},
+		{name: "off encrypted", keys: keys, policy: EncryptionOff, contentType: utils.EncryptedContentType, body: encryptedBody, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "no keys encrypted", policy: EncryptionRequire, contentType: utils.EncryptedContentType, body: encryptedBody, expectedStatus: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(Crypto(tt.keys, nil, tt.policy, true))
+			r.Any("/test", func(c *gin.Context) {
+				body, _ := io.ReadAll(c.Request.Body)
+				c.Data(http.StatusOK, c.ContentType(), body)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(tt.body))
+			if tt.body == nil {
+				req = httptest.NewRequest(http.MethodGet, "/test", nil)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code, resp.Body.String())
+			if tt.expectedStatus == http.StatusOK && tt.contentType == utils.EncryptedContentType {
+				assert.Equal(t, string(originalData), resp.Body.String())
+				assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestCrypto_EncryptedResponse(t *testing.T) {
+	logger.Initialize("fatal")
+	gin.SetMode(gin.TestMode)
+	keys := loadKeys("../../private_key.pem")
+	encrypted, err := encryptV2Replay([]byte(`{"id":"g"}`), readPublicKey("../../cert.pem").PublicKey.(*rsa.PublicKey), 0, "abc")
+	assert.NoError(t, err)
+	body, _ := json.Marshal(encrypted)
+	aesKey, err := unwrapKey(encrypted, keys.Keys()[0])
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.Use(Crypto(keys, nil, EncryptionAllow, true))
+	r.POST("/test", func(c *gin.Context) {
+		c.Header("X-Handler", "yes")
+		c.JSON(http.StatusCreated, gin.H{"stored": true})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+	req.Header.Set("Content-Type", utils.EncryptedContentType)
+	req.Header.Set("Accept", utils.EncryptedContentType)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, utils.EncryptedContentType, resp.Header().Get("Content-Type"))
+	assert.Equal(t, "yes", resp.Header().Get("X-Handler"))
+	var sealed utils.EncryptedPayload
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &sealed))
+	assert.Equal(t, utils.AlgDirect, sealed.Alg)
+	assert.Equal(t, encrypted.KeyID, sealed.KeyID)
+	assert.Equal(t, "abc", sealed.RequestNonce)
+	plain, err := utils.OpenWithKey(&sealed, aesKey)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"stored":true}`, string(plain))
+
+	// Without Accept the response stays plaintext
+	req = httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+	req.Header.Set("Content-Type", utils.EncryptedContentType)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.JSONEq(t, `{"stored":true}`, resp.Body.String())
+}
@@ -1,43 +1,62 @@
 // Package middlewares implements custom middleware functions for the Gin router.
 //
-// Currently provides Gzip middleware for handling compressed request bodies.
+// Currently provides Decompress middleware for handling compressed request bodies.
 package middlewares
 
 import (
-	"bytes"
-	"compress/gzip"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
 
-// Gzip returns a Gin middleware handler that manages gzip-compressed requests.
+// minZstdWindow is the zstd window every decoder is expected to support;
+// streaming encoders use it by default regardless of the input size.
+const minZstdWindow = 8 << 20
+
+// Decompress returns a Gin middleware handler that manages compressed requests.
 //
-// It automatically decompresses incoming gzip-encoded payloads so handlers can
-// read them as plain text. Useful for APIs that accept compressed data.
-func Gzip() gin.HandlerFunc {
+// Bodies with a gzip, deflate, zstd or br Content-Encoding are decompressed
+// as a stream into a pooled buffer, so handlers can read them as plain text. Decompression stops
+// as soon as the output exceeds maxSize bytes and the request is rejected
+// with 413, which makes compression bombs harmless. Malformed bodies are
+// rejected with 400. Other encodings are passed through unchanged.
+func Decompress(maxSize int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.GetHeader("Content-Encoding") == "gzip" {
-			body, err := io.ReadAll(c.Request.Body)
-			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
-			}
-
-			reader, err := gzip.NewReader(bytes.NewReader(body))
-			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
-			}
-			defer reader.Close()
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		if !utils.SupportedEncoding(encoding) {
+			c.Next()
+			return
+		}
 
-			decompressed, err := io.ReadAll(reader)
-			if err != nil {
-				print(err.Error())
-				c.AbortWithStatus(http.StatusBadRequest)
-			}
+		reader, err := utils.NewDecoder(encoding, c.Request.Body, uint64(max(maxSize, minZstdWindow)))
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeDecompressFailed, err.Error())
+			return
+		}
+		defer reader.Close()
 
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(decompressed))
+		decompressed := getBuffer()
+		defer putBuffer(decompressed)
+		n, err := decompressed.ReadFrom(io.LimitReader(reader, maxSize+1))
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeDecompressFailed, err.Error())
+			return
+		}
+		if n > maxSize {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+				fmt.Sprintf("decompressed body exceeds %d bytes", maxSize))
+			return
 		}
+
+		c.Request.Body = utils.NewBody(decompressed.Bytes())
+		c.Request.ContentLength = n
+		logDecodedBody(c, decompressed.Bytes())
+		c.Request.Header.Del("Content-Encoding")
 		c.Next()
 	}
 }
@@ -10,7 +10,9 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func compressWithGzip(input string) io.Reader {
@@ -29,7 +31,7 @@ func setupTestRouterWithGzip() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 
 	r := gin.New()
-	r.Use(Gzip())
+	r.Use(Decompress(10 << 20))
 
 	r.POST("/test", func(c *gin.Context) {
 		body, _ := io.ReadAll(c.Request.Body)
@@ -78,7 +80,7 @@ func Test_GzipMiddleware_UnsupportedEncoding(t *testing.T) {
 	reqBody := strings.NewReader("some data")
 	req, _ := http.NewRequest("POST", "/test", reqBody)
 	req.Header.Set("Content-Type", "text/plain")
-	req.Header.Set("Content-Encoding", "deflate")
+	req.Header.Set("Content-Encoding", "compress")
 
 	resp := httptest.NewRecorder()
 
@@ -87,3 +89,56 @@ func Test_GzipMiddleware_UnsupportedEncoding(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.Code)
 	assert.Equal(t, "some data", resp.Body.String())
 }
+
+func Test_GzipMiddleware_InvalidCompression(t *testing.T) {
+	r := setupTestRouterWithGzip()
+
+	reqBody := strings.NewReader("not gzip at all")
+	req, _ := http.NewRequest("POST", "/test", reqBody)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"decompress_failed"`)
+}
+
+func Test_DecompressMiddleware_Encodings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Decompress(1024))
+	reached := false
+	r.POST("/test", func(c *gin.Context) {
+		reached = true
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	const original = "this is a test string"
+	bomb := bytes.Repeat([]byte{0}, 1<<20)
+	for _, encoding := range []string{utils.EncodingGzip, utils.EncodingDeflate, utils.EncodingZstd, utils.EncodingBrotli} {
+		t.Run(encoding, func(t *testing.T) {
+			body, err := utils.Compress(encoding, []byte(original))
+			require.NoError(t, err)
+			req, _ := http.NewRequest("POST", "/test", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", encoding)
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, original, resp.Body.String())
+
+			body, err = utils.Compress(encoding, bomb)
+			require.NoError(t, err)
+			req, _ = http.NewRequest("POST", "/test", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", encoding)
+			resp = httptest.NewRecorder()
+			reached = false
+			r.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+			assert.Contains(t, resp.Body.String(), `"code":"body_too_large"`)
+			assert.False(t, reached, "the handler must not run after the limit is exceeded")
+		})
+	}
+}
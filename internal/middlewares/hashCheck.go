@@ -6,66 +6,117 @@ package middlewares
 
 import (
 	"bytes"
-	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/config/server"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"go.uber.org/zap"
 )
 
+// KeyIDHeader names the signing key of a request and of its response.
+const KeyIDHeader = "Key-Id"
+
+// VerifiedKeyIDKey is the gin context key of the ID of the signing key a
+// request was verified with by HashCheck; unset for keys without an ID.
+const VerifiedKeyIDKey = "middlewares.verifiedKeyID"
+
 // HashCheck returns a Gin middleware that verifies request/response integrity
-// using SHA256 hash when a signing key is configured.
+// using HMAC-SHA256 when signing keys are configured.
 //
 // For incoming requests:
 // - Skips check if no key is set
-// - Computes hash of request body using shared key
-// - Compares with "HashSHA256" header
-// - Aborts with 400 if hash mismatch
+// - Looks up the key named by the "Key-Id" header, or tries every key without an ID
+// - Compares the "HashSHA256" header with the hash of the request body, or of
+// utils.SignedMessage if the request carries a timestamp and a nonce
+// - Aborts with 400 and an apierror.Response if no active key matches
+// - If guard is set, requires the timestamp and nonce and checks them with it
+// - Stores the ID of the matching key under VerifiedKeyIDKey
 //
 // For outgoing responses:
-// - Calculates SHA256 hash of response body
-// - Sets "HashSHA256" header before sending response
-func HashCheck() gin.HandlerFunc {
+// - Buffers the response body
+// - Sets "HashSHA256" to its hash with the key the request used, and
+// "Key-Id" if that key has an ID
+func HashCheck(keys *server.SigningKeys, guard *ReplayGuard) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if *server.Key == "" {
+		if keys == nil || keys.Empty() {
 			c.Next()
 			return
 		}
 
-		body, err := c.GetRawData()
+		body, err := utils.ReadBody(c.Request)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Failed to read body"})
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
 			return
 		}
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-
-		originalWriter := c.Writer
-		var bodyBuf bytes.Buffer
-		loggedWriter := &LoggedResponseWriter{
-			ResponseWriter: originalWriter,
-			Body:           &bodyBuf,
-		}
-		c.Writer = loggedWriter
 
 		hashString := c.GetHeader("HashSHA256")
-
-		if utils.CalculateHashWithKey(body, *server.Key) != hashString {
-			logger.Log.Error("HashCheck", zap.String("error", "body hash does not match"),
-				zap.String("hashString", hashString),
-				zap.String("calculatedHashString", utils.CalculateHashWithKey(body, *server.Key)))
-			c.AbortWithStatusJSON(http.StatusBadRequest, nil)
+		timestamp, nonce := c.GetHeader(utils.TimestampHeader), c.GetHeader(utils.NonceHeader)
+		signed := body
+		if timestamp != "" || nonce != "" {
+			signed = utils.SignedMessage(timestamp, nonce, body)
+		}
+		key, err := keys.Verify(c.GetHeader(KeyIDHeader), signed, hashString, time.Now())
+		if err != nil {
+			logger.Log.Error("HashCheck", zap.String("error", err.Error()),
+				zap.String("keyID", c.GetHeader(KeyIDHeader)),
+				zap.String("hashString", hashString))
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeHashMismatch, err.Error())
+			return
+		}
+		ts, _ := strconv.ParseInt(timestamp, 10, 64)
+		if !checkReplay(c, guard, ts, nonce) {
 			return
 		}
+		if key.ID != "" {
+			c.Set(VerifiedKeyIDKey, key.ID)
+		}
+
+		originalWriter := c.Writer
+		signedWriter := &bufferedResponseWriter{ResponseWriter: originalWriter, body: getBuffer()}
+		defer putBuffer(signedWriter.body)
+		c.Writer = signedWriter
+
 		c.Next()
 
-		respBody := bodyBuf.String()
+		c.Writer = originalWriter
+		originalWriter.Header().Set("HashSHA256", utils.CalculateHashWithKey(signedWriter.body.Bytes(), key.Key))
+		if key.ID != "" {
+			originalWriter.Header().Set(KeyIDHeader, key.ID)
+		}
+		originalWriter.WriteHeaderNow()
+		if _, err := originalWriter.Write(signedWriter.body.Bytes()); err != nil {
+			logger.Log.Error("HashCheck", zap.String("error while writing response", err.Error()))
+		}
+	}
+}
+
+// bufferedResponseWriter holds the response body back, so that headers
+// depending on it can still be set after the handler has run.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+// Write appends b to the buffer.
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
 
-		hashRespString := utils.CalculateHashWithKey([]byte(respBody), *server.Key)
+// WriteString appends s to the buffer.
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
 
-		c.Writer.Header().Set("HashSHA256", hashRespString)
+// WriteHeaderNow does nothing: the status and headers are sent when the
+// buffered body is written to the wrapped writer.
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
 
-	}
+// Written reports whether anything has been buffered.
+func (w *bufferedResponseWriter) Written() bool {
+	return w.body.Len() > 0 || w.ResponseWriter.Written()
 }
@@ -1,28 +1,36 @@
 package middlewares
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stepanov-ds/ya-metrics/internal/config/server"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestRouterWithHashCheck(key string) *gin.Engine {
-	gin.SetMode(gin.TestMode)
+	keys, _ := server.LoadSigningKeys(key, "")
+	return setupTestRouterWithSigningKeys(keys)
+}
 
-	server.Key = &key
+func setupTestRouterWithSigningKeys(keys *server.SigningKeys) *gin.Engine {
+	gin.SetMode(gin.TestMode)
 
 	logger.Initialize("fatal")
 
 	r := gin.New()
-	r.Use(HashCheck())
+	r.Use(HashCheck(keys, nil))
 
 	r.POST("/test", func(c *gin.Context) {
 		body, _ := io.ReadAll(c.Request.Body)
@@ -63,6 +71,7 @@ func Test_HashCheck_InvalidHash(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"hash_mismatch"`)
 }
 
 func Test_HashCheck_ValidHash(t *testing.T) {
@@ -106,3 +115,71 @@ func Test_HashCheck_ResponseHash(t *testing.T) {
 
 	assert.Equal(t, calculated, headerHash)
 }
+
+func Test_HashCheck_KeyRotation(t *testing.T) {
+	now := time.Now()
+	file := filepath.Join(t.TempDir(), "keys.json")
+	keysJSON, err := json.Marshal([]server.SigningKey{
+		{Key: "old", NotAfter: now.Add(-time.Minute)},
+		{Key: "current", NotBefore: now.Add(-time.Hour)},
+		{Key: "next", NotBefore: now.Add(time.Hour)},
+		{ID: "agent-1", Key: "agent-1-secret"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, keysJSON, 0o600))
+
+	keys, err := server.LoadSigningKeys("static", file)
+	require.NoError(t, err)
+	r := setupTestRouterWithSigningKeys(keys)
+
+	body := `{"value": 3.14}`
+	tests := []struct {
+		name           string
+		key            string
+		keyID          string
+		expectedStatus int
+	}{
+		{name: "Positive #1 static key", key: "static", expectedStatus: http.StatusOK},
+		{name: "Positive #2 active key from file", key: "current", expectedStatus: http.StatusOK},
+		{name: "Positive #3 per-agent key", key: "agent-1-secret", keyID: "agent-1", expectedStatus: http.StatusOK},
+		{name: "Negative #1 expired key", key: "old", expectedStatus: http.StatusBadRequest},
+		{name: "Negative #2 not yet active key", key: "next", expectedStatus: http.StatusBadRequest},
+		{name: "Negative #3 per-agent key without Key-Id", key: "agent-1-secret", expectedStatus: http.StatusBadRequest},
+		{name: "Negative #4 unknown Key-Id", key: "static", keyID: "agent-2", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/test", strings.NewReader(body))
+			req.Header.Set("HashSHA256", utils.CalculateHashWithKey([]byte(body), tt.key))
+			if tt.keyID != "" {
+				req.Header.Set(KeyIDHeader, tt.keyID)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedStatus == http.StatusOK {
+				expected := utils.CalculateHashWithKey([]byte("Response: "+body), tt.key)
+				assert.Equal(t, expected, resp.Header().Get("HashSHA256"), "response must be signed with the request key")
+				assert.Equal(t, tt.keyID, resp.Header().Get(KeyIDHeader))
+			}
+		})
+	}
+}
+
+func Test_HashCheck_ResponseHashOverNetwork(t *testing.T) {
+	key := "secret_key"
+	ts := httptest.NewServer(setupTestRouterWithHashCheck(key))
+	defer ts.Close()
+
+	body := `{"value": 3.14}`
+	req, _ := http.NewRequest("POST", ts.URL+"/test", strings.NewReader(body))
+	req.Header.Set("HashSHA256", utils.CalculateHashWithKey([]byte(body), key))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, utils.CalculateHashWithKey(respBody, key), resp.Header.Get("HashSHA256"))
+}
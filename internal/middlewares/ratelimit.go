@@ -0,0 +1,192 @@
+// Package middlewares implements custom middleware functions for the Gin router.
+//
+// This file contains RateLimiter, which limits the requests and the
+// metrics each client may send with token buckets.
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// sweepInterval is how often buckets that have refilled are forgotten.
+const sweepInterval = time.Minute
+
+// RateLimitOptions configures a RateLimiter. A zero rate disables the
+// corresponding limit.
+type RateLimitOptions struct {
+	RequestsPerSecond float64                     // запросов в секунду на клиента
+	Burst             int                         // запросов сверх среднего; 0 — RequestsPerSecond, округлённое вверх
+	MetricsPerMinute  int                         // метрик в минуту на клиента
+	Key               func(c *gin.Context) string // ключ клиента; по умолчанию его IP адрес
+}
+
+// RateLimiter limits the requests per second and the metrics per minute
+// of every client with a token bucket per client key.
+//
+// A client over a limit is answered with 429 Too Many Requests and a
+// Retry-After header telling when the request would be admitted.
+type RateLimiter struct {
+	requests *buckets
+	metrics  *buckets
+	key      func(c *gin.Context) string
+}
+
+// NewRateLimiter creates a RateLimiter with the given options.
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	l := &RateLimiter{key: opts.Key}
+	if l.key == nil {
+		l.key = func(c *gin.Context) string { return c.ClientIP() }
+	}
+	if opts.RequestsPerSecond > 0 {
+		burst := float64(opts.Burst)
+		if burst <= 0 {
+			burst = math.Ceil(opts.RequestsPerSecond)
+		}
+		l.requests = newBuckets(opts.RequestsPerSecond, burst)
+	}
+	if opts.MetricsPerMinute > 0 {
+		l.metrics = newBuckets(float64(opts.MetricsPerMinute)/60, float64(opts.MetricsPerMinute))
+	}
+	return l
+}
+
+// Requests returns a Gin middleware that limits the requests of a client.
+// It must run after the middlewares the client key depends on, such as
+// the token check.
+func (l *RateLimiter) Requests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil || l.requests == nil {
+			c.Next()
+			return
+		}
+		if wait, ok := l.requests.take(l.key(c), 1); !ok {
+			abortRateLimited(c, wait, "request rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Metrics returns a Gin middleware that limits the metrics a client
+// stores: one for a request without a JSON array body, otherwise the
+// number of array items. It must run once the body is decrypted and
+// decompressed.
+//
+// A batch larger than the whole minute quota is admitted when the bucket
+// is full and delays the next requests of the client accordingly.
+func (l *RateLimiter) Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil || l.metrics == nil {
+			c.Next()
+			return
+		}
+		body, err := utils.ReadBody(c.Request)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
+			return
+		}
+		if wait, ok := l.metrics.take(l.key(c), float64(countMetrics(body))); !ok {
+			abortRateLimited(c, wait, "metric rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+// countMetrics returns the number of items of a JSON array body, or 1.
+// Malformed bodies count as 1 and are rejected by the handler.
+func countMetrics(body []byte) int {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return 1
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return 1
+	}
+	return len(items)
+}
+
+// abortRateLimited responds with 429 and the whole seconds to wait in Retry-After.
+func abortRateLimited(c *gin.Context, wait time.Duration, message string) {
+	seconds := max(int(math.Ceil(wait.Seconds())), 1)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeRateLimited, message+", retry in "+strconv.Itoa(seconds)+"s")
+}
+
+// bucket is the token bucket of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets holds the token buckets of all clients for one limit.
+type buckets struct {
+	now   func() time.Time
+	items map[string]*bucket
+	swept time.Time
+	rate  float64 // токенов в секунду
+	burst float64 // ёмкость корзины
+	mu    sync.Mutex
+}
+
+// newBuckets creates buckets refilled with rate tokens per second up to burst.
+func newBuckets(rate, burst float64) *buckets {
+	return &buckets{
+		now:   time.Now,
+		items: make(map[string]*bucket),
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+// take removes n tokens from the bucket of key. The bucket may go into
+// debt when n exceeds the burst, but only once it is full.
+//
+// Returns false and the time until enough tokens are available if the
+// bucket holds fewer than min(n, burst) tokens; nothing is taken then.
+func (b *buckets) take(key string, n float64) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.sweep(now)
+
+	bk, ok := b.items[key]
+	if !ok {
+		bk = &bucket{tokens: b.burst, last: now}
+		b.items[key] = bk
+	}
+	bk.tokens = min(bk.tokens+now.Sub(bk.last).Seconds()*b.rate, b.burst)
+	bk.last = now
+
+	need := min(n, b.burst)
+	if bk.tokens < need {
+		return time.Duration((need - bk.tokens) / b.rate * float64(time.Second)), false
+	}
+	bk.tokens -= n
+	return 0, true
+}
+
+// sweep forgets the buckets that have refilled since their last use, at
+// most once per sweepInterval. It is called with b.mu held.
+func (b *buckets) sweep(now time.Time) {
+	if now.Sub(b.swept) < sweepInterval {
+		return
+	}
+	b.swept = now
+	for key, bk := range b.items {
+		if bk.tokens+now.Sub(bk.last).Seconds()*b.rate >= b.burst {
+			delete(b.items, key)
+		}
+	}
+}
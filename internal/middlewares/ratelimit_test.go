@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuckets_Take(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newBuckets(2, 4)
+	b.now = func() time.Time { return now }
+
+	for range 4 {
+		_, ok := b.take("a", 1)
+		assert.True(t, ok)
+	}
+	wait, ok := b.take("a", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	_, ok = b.take("b", 1)
+	assert.True(t, ok, "clients have buckets of their own")
+
+	now = now.Add(time.Second)
+	_, ok = b.take("a", 2)
+	assert.True(t, ok, "two tokens refilled in a second")
+
+	now = now.Add(time.Hour)
+	_, ok = b.take("a", 10)
+	assert.True(t, ok, "a full bucket admits more than its burst")
+	wait, ok = b.take("a", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 3500*time.Millisecond, wait, "the debt of 6 tokens must be repaid first")
+
+	now = now.Add(time.Hour)
+	b.take("c", 1)
+	assert.Len(t, b.items, 1, "refilled buckets are swept")
+}
+
+func TestRateLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := NewRateLimiter(RateLimitOptions{RequestsPerSecond: 1, MetricsPerMinute: 3})
+	r := gin.New()
+	r.POST("/value", l.Requests(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/updates", l.Metrics(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path, body, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/value", "", "10.0.0.1").Code)
+	w := serve("/value", "", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"code":"rate_limited"`)
+	assert.Equal(t, http.StatusOK, serve("/value", "", "10.0.0.2").Code)
+
+	assert.Equal(t, http.StatusOK, serve("/updates", `[{"id":"a"},{"id":"b"}]`, "10.0.0.1").Code)
+	w = serve("/updates", `[{"id":"a"},{"id":"b"}]`, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "20", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve("/updates", `{"id":"a"}`, "10.0.0.1").Code)
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, l := range []*RateLimiter{nil, NewRateLimiter(RateLimitOptions{})} {
+		r := gin.New()
+		r.POST("/", l.Requests(), l.Metrics(), func(c *gin.Context) { c.Status(http.StatusOK) })
+		for range 10 {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("[1,2,3]")))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestCountMetrics(t *testing.T) {
+	assert.Equal(t, 1, countMetrics(nil))
+	assert.Equal(t, 1, countMetrics([]byte(`{"id":"a"}`)))
+	assert.Equal(t, 3, countMetrics([]byte(" \n[1, 2, {\"id\":\"x\"}]")))
+	assert.Equal(t, 0, countMetrics([]byte(`[]`)))
+	assert.Equal(t, 1, countMetrics([]byte(`[1, 2`)))
+}
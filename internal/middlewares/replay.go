@@ -0,0 +1,113 @@
+// Package middlewares implements custom middleware functions for the Gin router.
+//
+// This file contains ReplayGuard, which rejects signed or encrypted requests
+// that are too old or have been seen before.
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+)
+
+// MaxNonceLength is the longest accepted request nonce.
+const MaxNonceLength = 64
+
+// replayCheckedKey marks in the gin context the nonce that already passed
+// the guard, so a request both encrypted and signed is checked once.
+const replayCheckedKey = "replay_checked_nonce"
+
+// Errors returned by ReplayGuard.Check.
+var (
+	ErrReplayMissing = errors.New("request timestamp and nonce are required")
+	ErrReplayStale   = errors.New("request timestamp is outside the allowed clock skew")
+	ErrReplayed      = errors.New("request nonce has already been used")
+)
+
+// ReplayGuard checks that a request timestamp lies within the clock-skew
+// window and that its nonce has not been used during that window.
+//
+// Nonces are kept for twice the window in a cache bounded by size entries;
+// when it is full the oldest nonce is evicted early.
+type ReplayGuard struct {
+	now    func() time.Time
+	seen   map[string]time.Time
+	order  []string
+	window time.Duration
+	size   int
+	mu     sync.Mutex
+}
+
+// NewReplayGuard creates a ReplayGuard for the given clock skew and nonce cache size.
+func NewReplayGuard(window time.Duration, size int) *ReplayGuard {
+	if size < 1 {
+		size = 1
+	}
+	return &ReplayGuard{
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+		window: window,
+		size:   size,
+	}
+}
+
+// Check validates the Unix timestamp and nonce of a request and remembers the nonce.
+func (g *ReplayGuard) Check(timestamp int64, nonce string) error {
+	if timestamp == 0 || nonce == "" || len(nonce) > MaxNonceLength {
+		return ErrReplayMissing
+	}
+	now := g.now()
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > g.window || skew < -g.window {
+		return ErrReplayStale
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.evict(now)
+	if _, found := g.seen[nonce]; found {
+		return ErrReplayed
+	}
+	if len(g.order) >= g.size {
+		delete(g.seen, g.order[0])
+		g.order = g.order[1:]
+	}
+	g.seen[nonce] = now.Add(2 * g.window)
+	g.order = append(g.order, nonce)
+	return nil
+}
+
+// evict forgets nonces whose timestamps can no longer pass the window check.
+func (g *ReplayGuard) evict(now time.Time) {
+	drop := 0
+	for drop < len(g.order) && !now.Before(g.seen[g.order[drop]]) {
+		delete(g.seen, g.order[drop])
+		drop++
+	}
+	if drop > 0 {
+		g.order = append(g.order[:0], g.order[drop:]...)
+	}
+}
+
+// checkReplay runs g on the request and aborts it on failure.
+//
+// Does nothing if g is nil or the nonce was already checked for this request.
+// Returns false if the request was aborted.
+func checkReplay(c *gin.Context, g *ReplayGuard, timestamp int64, nonce string) bool {
+	if g == nil || (nonce != "" && c.GetString(replayCheckedKey) == nonce) {
+		return true
+	}
+	switch err := g.Check(timestamp, nonce); {
+	case errors.Is(err, ErrReplayed):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeReplayed, err.Error())
+		return false
+	case err != nil:
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeStaleRequest, err.Error())
+		return false
+	}
+	c.Set(replayCheckedKey, nonce)
+	return true
+}
@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/config/server"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	g := NewReplayGuard(time.Minute, 2)
+	g.now = func() time.Time { return now }
+	ts := now.Unix()
+
+	assert.NoError(t, g.Check(ts, "a"))
+	assert.ErrorIs(t, g.Check(ts, "a"), ErrReplayed)
+	assert.ErrorIs(t, g.Check(ts-61, "b"), ErrReplayStale)
+	assert.ErrorIs(t, g.Check(ts+61, "b"), ErrReplayStale)
+	assert.ErrorIs(t, g.Check(0, "b"), ErrReplayMissing)
+	assert.ErrorIs(t, g.Check(ts, ""), ErrReplayMissing)
+	assert.ErrorIs(t, g.Check(ts, strings.Repeat("n", MaxNonceLength+1)), ErrReplayMissing)
+
+	assert.NoError(t, g.Check(ts, "b"))
+	assert.NoError(t, g.Check(ts, "c"), "the oldest nonce is evicted when the cache is full")
+	assert.Len(t, g.seen, 2)
+	assert.NoError(t, g.Check(ts, "a"))
+
+	now = now.Add(3 * time.Minute)
+	assert.NoError(t, g.Check(now.Unix(), "d"))
+	assert.Len(t, g.seen, 1, "expired nonces are forgotten")
+}
+
+func TestHashCheck_Replay(t *testing.T) {
+	key := "secret_key"
+	signing, err := server.LoadSigningKeys(key, "")
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(HashCheck(signing, NewReplayGuard(time.Minute, 100)))
+	r.POST("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	body := `{"value": 3.14}`
+	send := func(timestamp, nonce string, signedTimestamp string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/test", strings.NewReader(body))
+		req.Header.Set("HashSHA256", utils.CalculateHashWithKey(utils.SignedMessage(signedTimestamp, nonce, []byte(body)), key))
+		req.Header.Set(utils.TimestampHeader, timestamp)
+		req.Header.Set(utils.NonceHeader, nonce)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	assert.Equal(t, http.StatusOK, send(now, "n1", now).Code)
+
+	resp := send(now, "n1", now)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"replayed"`)
+
+	resp = send(old, "n2", old)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"stale_request"`)
+
+	resp = send(now, "n3", old)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"hash_mismatch"`, "the timestamp is covered by the signature")
+
+	req, _ := http.NewRequest("POST", "/test", strings.NewReader(body))
+	req.Header.Set("HashSHA256", utils.CalculateHashWithKey([]byte(body), key))
+	legacy := httptest.NewRecorder()
+	r.ServeHTTP(legacy, req)
+	assert.Equal(t, http.StatusBadRequest, legacy.Code)
+	assert.Contains(t, legacy.Body.String(), `"code":"stale_request"`)
+}
+
+func TestCrypto_Replay(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyID, err := utils.KeyID(&privKey.PublicKey)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Crypto(server.NewKeyring(server.PrivateKey{RSA: privKey, ID: keyID}), NewReplayGuard(time.Minute, 100), EncryptionRequire, true))
+	r.POST("/test", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	send := func(p *utils.EncryptedPayload) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(p)
+		req, _ := http.NewRequest("POST", "/test", bytes.NewReader(bodyBytes))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	payload, err := encryptV2Replay([]byte("data"), &privKey.PublicKey, time.Now().Unix(), "n1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send(payload).Code)
+	assert.Equal(t, http.StatusConflict, send(payload).Code)
+
+	payload.RequestNonce = "n2"
+	assert.Equal(t, http.StatusBadRequest, send(payload).Code, "the nonce is covered by the envelope")
+
+	payload, err = encryptV2([]byte("data"), &privKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, send(payload).Code)
+}
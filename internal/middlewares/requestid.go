@@ -0,0 +1,36 @@
+// Package middlewares implements custom middleware functions for the Gin router.
+//
+// This file contains RequestID, which identifies every request for logs,
+// the database and the client.
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// RequestID returns a Gin middleware that attaches a utils.Trace to the
+// request context.
+//
+// The trace continues the one of a valid traceparent header or starts a
+// new one; the request ID is taken from a valid X-Request-ID header or is
+// the trace ID. Both are echoed in the response headers, the traceparent
+// with the span of the server.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trace, ok := utils.ParseTraceparent(c.GetHeader(utils.TraceparentHeader))
+		if ok {
+			trace = trace.Child()
+		} else {
+			trace = utils.NewTrace()
+		}
+		if id := c.GetHeader(utils.RequestIDHeader); utils.ValidRequestID(id) {
+			trace.RequestID = id
+		}
+
+		c.Request = c.Request.WithContext(utils.WithTrace(c.Request.Context(), trace))
+		c.Header(utils.RequestIDHeader, trace.RequestID)
+		c.Header(utils.TraceparentHeader, trace.Traceparent())
+		c.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name        string
+		traceparent string
+		requestID   string
+		wantTrace   string
+		wantID      string
+	}{
+		{name: "new trace"},
+		{name: "propagated trace", traceparent: traceparent, wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736", wantID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "request ID", traceparent: traceparent, requestID: "batch-42", wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736", wantID: "batch-42"},
+		{name: "malformed headers", traceparent: "00-zz-00f067aa0ba902b7-01", requestID: "bad id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen utils.Trace
+			r := gin.New()
+			r.Use(RequestID())
+			r.GET("/", func(c *gin.Context) {
+				var ok bool
+				seen, ok = utils.TraceFrom(c.Request.Context())
+				require.True(t, ok)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				req.Header.Set(utils.TraceparentHeader, tt.traceparent)
+			}
+			if tt.requestID != "" {
+				req.Header.Set(utils.RequestIDHeader, tt.requestID)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			if tt.wantTrace != "" {
+				assert.Equal(t, tt.wantTrace, seen.TraceID)
+				assert.NotEqual(t, "00f067aa0ba902b7", seen.SpanID)
+			}
+			if tt.wantID != "" {
+				assert.Equal(t, tt.wantID, seen.RequestID)
+			} else {
+				assert.Equal(t, seen.TraceID, seen.RequestID)
+			}
+			assert.Equal(t, seen.RequestID, resp.Header().Get(utils.RequestIDHeader))
+			assert.Equal(t, seen.Traceparent(), resp.Header().Get(utils.TraceparentHeader))
+		})
+	}
+}
+
+func TestLogRequests_RequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs := observeLogs(t)
+	r := gin.New()
+	r.Use(RequestID(), WithLogging())
+	r.GET("/", func(c *gin.Context) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(utils.RequestIDHeader, "batch-42")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, 2, logs.Len())
+	for _, entry := range logs.All() {
+		assert.Equal(t, "batch-42", entry.ContextMap()["request_id"])
+		assert.NotEmpty(t, entry.ContextMap()["trace_id"])
+	}
+}
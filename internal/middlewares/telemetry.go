@@ -0,0 +1,63 @@
+// Package middlewares implements custom middleware functions for the Gin router.
+//
+// This file contains Instrument, which records the request metrics of the
+// server.
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+)
+
+// unmatchedRoute labels requests that matched no route, so that unknown
+// paths do not create new series.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a method outside standardMethods, so
+// that made-up methods do not create new series.
+const otherMethod = "other"
+
+// standardMethods are the HTTP methods labeled as they are.
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Instrument returns a Gin middleware that counts requests and observes
+// their latency by route pattern, method and status in telemetry.Default.
+//
+// Requests rejected with an API error, such as a body that fails to
+// decode, decrypt or match its hash, are also counted by error code.
+func Instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		if !standardMethods[method] {
+			method = otherMethod
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		telemetry.HTTPRequests.Inc(route, method, status)
+		telemetry.HTTPDuration.Observe(telemetry.Since(start), route, method, status)
+		if code := c.GetString(apierror.ContextKey); code != "" {
+			telemetry.RequestFailures.Inc(route, code)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Instrument())
+	r.POST("/instrumented/:name", func(c *gin.Context) {
+		if c.Param("name") == "bad" {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeDecryptFailed, "cannot decrypt")
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	okBefore := telemetry.HTTPRequests.Value("/instrumented/:name", "POST", "200")
+	badBefore := telemetry.HTTPRequests.Value("/instrumented/:name", "POST", "400")
+	failedBefore := telemetry.RequestFailures.Value("/instrumented/:name", apierror.CodeDecryptFailed)
+	unmatchedBefore := telemetry.HTTPRequests.Value(unmatchedRoute, "GET", "404")
+	otherBefore := telemetry.HTTPRequests.Value(unmatchedRoute, otherMethod, "404")
+	observedBefore := telemetry.HTTPDuration.Count("/instrumented/:name", "POST", "200")
+
+	for _, target := range []string{"/instrumented/a", "/instrumented/b", "/instrumented/bad"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, target, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("MADEUP", "/nowhere", nil))
+
+	assert.Equal(t, okBefore+2, telemetry.HTTPRequests.Value("/instrumented/:name", "POST", "200"))
+	assert.Equal(t, badBefore+1, telemetry.HTTPRequests.Value("/instrumented/:name", "POST", "400"))
+	assert.Equal(t, failedBefore+1, telemetry.RequestFailures.Value("/instrumented/:name", apierror.CodeDecryptFailed))
+	assert.Equal(t, unmatchedBefore+1, telemetry.HTTPRequests.Value(unmatchedRoute, "GET", "404"))
+	assert.Equal(t, otherBefore+1, telemetry.HTTPRequests.Value(unmatchedRoute, otherMethod, "404"))
+	assert.Zero(t, telemetry.HTTPRequests.Value(unmatchedRoute, "MADEUP", "404"))
+	assert.Equal(t, observedBefore+2, telemetry.HTTPDuration.Count("/instrumented/:name", "POST", "200"))
+}
@@ -5,67 +5,210 @@ package middlewares
 
 import (
 	"bytes"
-	"io"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-// WithLogging returns a Gin middleware that logs incoming requests and outgoing responses.
+// DefaultLogBodyLimit is the number of body bytes logged when
+// LoggingOptions.BodyLimit is not set.
+const DefaultLogBodyLimit = 1024
+
+// redactedHeaders lists the headers whose values are never logged.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Hashsha256":          true,
+}
+
+// LoggingOptions configures LogRequests.
+type LoggingOptions struct {
+	Headers   bool               // логировать заголовки запроса; секретные значения скрываются
+	Bodies    bool               // логировать тела запроса и ответа
+	BodyLimit int                // максимум логируемых байт тела; 0 означает DefaultLogBodyLimit
+	Sampling  map[string]float64 // доля логируемых успешных запросов по шаблону маршрута
+}
+
+// WithLogging is LogRequests with default options: no headers, no bodies
+// and every request logged.
+func WithLogging() gin.HandlerFunc {
+	return LogRequests(LoggingOptions{})
+}
+
+// LogRequests returns a Gin middleware that logs incoming requests and outgoing responses.
 //
 // Logs include:
-// - Request URI, method, duration, and body
-// - Response status, size, and body
-func WithLogging() gin.HandlerFunc {
+// - Request ID and trace ID set by RequestID
+// - Request URI, method, duration and, if enabled, headers and body
+// - Response status, size and, if enabled, body
+//
+// The request body is logged as the handler got it: decrypted and
+// decompressed by Crypto and Decompress further down the chain, see
+// logDecodedBody. Bodies are cut to BodyLimit bytes and the values of credential headers
+// such as Authorization and HashSHA256 are replaced with "[REDACTED]".
+// Requests to a route listed in Sampling are logged with the given
+// probability, unless they fail with 4xx or 5xx.
+func LogRequests(opts LoggingOptions) gin.HandlerFunc {
+	limit := opts.BodyLimit
+	if limit <= 0 {
+		limit = DefaultLogBodyLimit
+	}
 	return func(c *gin.Context) {
 		start := time.Now()
-		body, err := c.GetRawData()
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Failed to read body"})
-			return
-		}
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		var body *loggedBody
+		var loggedWriter *LoggedResponseWriter
+		if opts.Bodies {
+			raw, err := utils.ReadBody(c.Request)
+			if err != nil {
+				apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidBody, "failed to read request body")
+				return
+			}
+			body = &loggedBody{data: raw, limit: limit}
+			c.Set(loggedBodyKey, body)
 
-		originalWriter := c.Writer
-		var bodyBuf bytes.Buffer
-		loggedWriter := &LoggedResponseWriter{
-			ResponseWriter: originalWriter,
-			Body:           &bodyBuf,
+			loggedWriter = &LoggedResponseWriter{
+				ResponseWriter: c.Writer,
+				Body:           getBuffer(),
+				limit:          limit,
+			}
+			defer putBuffer(loggedWriter.Body)
+			c.Writer = loggedWriter
 		}
-		c.Writer = loggedWriter
 
 		c.Next()
 
-		respBody := bodyBuf.String()
-
+		if rate, ok := opts.Sampling[c.FullPath()]; ok && c.Writer.Status() < http.StatusBadRequest && rand.Float64() >= rate {
+			return
+		}
 		duration := time.Since(start)
 
-		logger.Log.Info("Request received",
+		var traceFields []zap.Field
+		if trace, ok := utils.TraceFrom(c.Request.Context()); ok {
+			traceFields = []zap.Field{zap.String("request_id", trace.RequestID), zap.String("trace_id", trace.TraceID)}
+		}
+		fields := append(traceFields,
 			zap.String("URI", c.Request.URL.Path),
 			zap.String("method", c.Request.Method),
 			zap.Duration("duration", duration),
-			zap.String("body", string(body)),
 		)
+		if opts.Headers {
+			fields = append(fields, zap.Object("headers", loggedHeaders(c.Request.Header)))
+		}
+		if opts.Bodies {
+			fields = append(fields, bodyFields(body.data, limit)...)
+		}
+		logger.Log.Info("Request received", fields...)
 
-		logger.Log.Info("Response sent",
+		fields = append(traceFields,
 			zap.Int("status", c.Writer.Status()),
 			zap.Int("size", c.Writer.Size()),
-			zap.String("body", respBody),
 		)
+		if loggedWriter != nil {
+			fields = append(fields, zap.ByteString("body", loggedWriter.Body.Bytes()))
+			if loggedWriter.written > loggedWriter.Body.Len() {
+				fields = append(fields, zap.Bool("truncated", true))
+			}
+		}
+		logger.Log.Info("Response sent", fields...)
 	}
 }
 
+// loggedBodyKey is the gin context key of the *loggedBody of a request.
+const loggedBodyKey = "middlewares.loggedBody"
+
+// loggedBody is the request body logged by LogRequests. It is the body as
+// received until a middleware decodes it.
+type loggedBody struct {
+	data  []byte
+	limit int
+}
+
+// logDecodedBody makes LogRequests log decoded instead of the body it
+// read, if it logs bodies. Up to one byte over the log limit is copied, as
+// decoded may be a pooled buffer reused once the request is served.
+func logDecodedBody(c *gin.Context, decoded []byte) {
+	v, ok := c.Get(loggedBodyKey)
+	if !ok {
+		return
+	}
+	b := v.(*loggedBody)
+	b.data = bytes.Clone(decoded[:min(len(decoded), b.limit+1)])
+}
+
+// bodyFields returns the log fields of body cut to limit bytes.
+func bodyFields(body []byte, limit int) []zap.Field {
+	if len(body) <= limit {
+		return []zap.Field{zap.ByteString("body", body)}
+	}
+	return []zap.Field{zap.ByteString("body", body[:limit]), zap.Bool("truncated", true)}
+}
+
+// loggedHeaders logs request headers with credentials redacted.
+type loggedHeaders http.Header
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (h loggedHeaders) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for name, values := range h {
+		if redactedHeaders[name] {
+			enc.AddString(name, "[REDACTED]")
+			continue
+		}
+		enc.AddString(name, strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// ParseSampling parses a comma-separated list of route=rate pairs, such
+// as "/updates=0.01", into LoggingOptions.Sampling. Routes are gin route
+// patterns and rates lie in [0, 1].
+func ParseSampling(s string) (map[string]float64, error) {
+	sampling := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		route, rate, ok := strings.Cut(pair, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("log sampling %q: want route=rate", pair)
+		}
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("log sampling %q: rate must be a number between 0 and 1", pair)
+		}
+		sampling[route] = r
+	}
+	return sampling, nil
+}
+
 // LoggedResponseWriter wraps gin.ResponseWriter to capture written response body.
 type LoggedResponseWriter struct {
 	gin.ResponseWriter
-	Body *bytes.Buffer
+	Body    *bytes.Buffer
+	limit   int
+	written int
 }
 
-// Write writes the response data to both the internal buffer and the original writer.
+// Write writes the response data to the original writer and keeps up to
+// limit bytes of it in Body; a zero limit keeps everything.
 func (w *LoggedResponseWriter) Write(b []byte) (int, error) {
-	w.Body.Write(b)
+	keep := b
+	if w.limit > 0 {
+		keep = b[:min(len(b), max(w.limit-w.Body.Len(), 0))]
+	}
+	w.Body.Write(keep)
+	w.written += len(b)
 	return w.ResponseWriter.Write(b)
 }
 
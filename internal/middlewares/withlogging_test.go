@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -8,8 +9,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func setupTestRouter() *gin.Engine {
@@ -47,3 +51,143 @@ func TestWithLogging_Middleware(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "Response body", w.Body.String())
 }
+
+// observeLogs replaces logger.Log with one recording its entries.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zap.InfoLevel)
+	old := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() { logger.Log = old })
+	return logs
+}
+
+func TestLogRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name  string
+		opts  LoggingOptions
+		check func(t *testing.T, request, response map[string]interface{})
+	}{
+		{
+			name: "bodies off by default",
+			opts: LoggingOptions{},
+			check: func(t *testing.T, request, response map[string]interface{}) {
+				assert.NotContains(t, request, "body")
+				assert.NotContains(t, request, "headers")
+				assert.NotContains(t, response, "body")
+				assert.Equal(t, int64(http.StatusOK), response["status"])
+			},
+		},
+		{
+			name: "bodies cut to the limit",
+			opts: LoggingOptions{Bodies: true, BodyLimit: 4},
+			check: func(t *testing.T, request, response map[string]interface{}) {
+				assert.Equal(t, `{"ke`, request["body"])
+				assert.Equal(t, true, request["truncated"])
+				assert.Equal(t, "Resp", response["body"])
+				assert.Equal(t, true, response["truncated"])
+			},
+		},
+		{
+			name: "credentials redacted",
+			opts: LoggingOptions{Headers: true},
+			check: func(t *testing.T, request, response map[string]interface{}) {
+				headers := request["headers"].(map[string]interface{})
+				assert.Equal(t, "[REDACTED]", headers["Hashsha256"])
+				assert.Equal(t, "[REDACTED]", headers["Authorization"])
+				assert.Equal(t, "application/json", headers["Content-Type"])
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			r := gin.New()
+			r.Use(LogRequests(tt.opts))
+			r.POST("/log", func(c *gin.Context) {
+				c.String(http.StatusOK, "Response body")
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(`{"key":"value"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("HashSHA256", "signature")
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, "Response body", w.Body.String())
+			entries := logs.All()
+			require.Len(t, entries, 2)
+			tt.check(t, entries[0].ContextMap(), entries[1].ContextMap())
+		})
+	}
+}
+
+func TestLogRequests_DecodedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs := observeLogs(t)
+	r := gin.New()
+	r.Use(BufferBody(1024), LogRequests(LoggingOptions{Bodies: true, BodyLimit: 8}))
+	r.POST("/log", Decompress(1024), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	compressed, err := utils.Compress(utils.EncodingGzip, []byte(`{"key":"value"}`))
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/log", bytes.NewReader(compressed))
+	req.Header.Set("Content-Encoding", utils.EncodingGzip)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, `{"key":"`, entries[0].ContextMap()["body"])
+	assert.Equal(t, true, entries[0].ContextMap()["truncated"])
+}
+
+func TestLogRequests_Sampling(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs := observeLogs(t)
+	r := gin.New()
+	r.Use(LogRequests(LoggingOptions{Sampling: map[string]float64{"/updates": 0}}))
+	r.POST("/updates", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.POST("/update", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.POST("/updates/bad", func(c *gin.Context) {
+		c.Status(http.StatusBadRequest)
+	})
+
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/updates", nil))
+	}
+	assert.Zero(t, logs.Len())
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/update", nil))
+	assert.Equal(t, 2, logs.Len())
+
+	// Failures are logged even on sampled routes
+	r = gin.New()
+	r.Use(LogRequests(LoggingOptions{Sampling: map[string]float64{"/updates": 0}}))
+	r.POST("/updates", func(c *gin.Context) {
+		c.Status(http.StatusBadRequest)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/updates", nil))
+	assert.Equal(t, 4, logs.Len())
+}
+
+func TestParseSampling(t *testing.T) {
+	sampling, err := ParseSampling(" /updates=0.01, /update/:metric_type/:metric_name/:value=1 ")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"/updates": 0.01, "/update/:metric_type/:metric_name/:value": 1}, sampling)
+
+	sampling, err = ParseSampling("")
+	require.NoError(t, err)
+	assert.Empty(t, sampling)
+
+	for _, bad := range []string{"/updates", "=0.5", "/updates=2", "/updates=often"} {
+		_, err = ParseSampling(bad)
+		assert.Error(t, err, bad)
+	}
+}
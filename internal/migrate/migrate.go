@@ -0,0 +1,180 @@
+// Package migrate copies metrics between the storage backends of the server:
+// the snapshot file written in file mode and the PostgreSQL table.
+//
+// A migration makes the target hold the values of the source. Counters are
+// copied by value, not added to the target, so a migration can be rerun
+// safely: a second run finds every metric unchanged and writes nothing.
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// Options selects what Run does.
+type Options struct {
+	Replace bool // удалить из цели метрики, которых нет в источнике
+	DryRun  bool // только посчитать изменения, ничего не записывая
+	Verify  bool // после переноса сравнить цель с источником
+}
+
+// Report describes the changes a migration makes, or would make in a dry run.
+type Report struct {
+	Created    []string `json:"created"`    // метрики, которых не было в цели
+	Updated    []string `json:"updated"`    // метрики, значение или тип которых изменится
+	Deleted    []string `json:"deleted"`    // метрики цели, которых нет в источнике; только с Replace
+	Unchanged  int      `json:"unchanged"`  // метрики, совпадающие с источником
+	Mismatched []string `json:"mismatched"` // метрики, расходящиеся с источником после переноса; только с Verify
+}
+
+// Changed reports whether the migration writes anything to the target.
+func (r Report) Changed() bool {
+	return len(r.Created)+len(r.Updated)+len(r.Deleted) > 0
+}
+
+// ErrMismatch is returned by Run when the target differs from the source
+// after the migration.
+var ErrMismatch = errors.New("target differs from source after migration")
+
+// Plan compares source with target and returns the changes that make
+// target hold the metrics of source. Metrics of target missing from source
+// are deleted only with replace.
+func Plan(source, target map[string]utils.Metrics, replace bool) Report {
+	var r Report
+	for name, m := range source {
+		old, ok := target[name]
+		switch {
+		case !ok:
+			r.Created = append(r.Created, name)
+		case !Equal(m, old):
+			r.Updated = append(r.Updated, name)
+		default:
+			r.Unchanged++
+		}
+	}
+	if replace {
+		for name := range target {
+			if _, ok := source[name]; !ok {
+				r.Deleted = append(r.Deleted, name)
+			}
+		}
+	}
+	sort.Strings(r.Created)
+	sort.Strings(r.Updated)
+	sort.Strings(r.Deleted)
+	return r
+}
+
+// Equal reports whether a and b have the same type and value.
+func Equal(a, b utils.Metrics) bool {
+	if a.MType != b.MType {
+		return false
+	}
+	switch a.MType {
+	case "counter":
+		return a.Delta != nil && b.Delta != nil && *a.Delta == *b.Delta
+	case "gauge":
+		return a.Value != nil && b.Value != nil && *a.Value == *b.Value
+	}
+	return false
+}
+
+// Run copies the metrics of src to dst according to opts and reports the
+// changes. Only created and updated metrics are written, with
+// storage.Import; with deletions the target is cleared and rewritten.
+//
+// With opts.Verify the target is read back and compared with the source;
+// Run then returns ErrMismatch along with the report if they differ.
+func Run(ctx context.Context, src, dst storage.Storage, opts Options) (Report, error) {
+	source := src.GetAllMetrics()
+	r := Plan(source, dst.GetAllMetrics(), opts.Replace)
+	if opts.DryRun || !r.Changed() {
+		return verify(r, source, dst, opts)
+	}
+
+	var metrics []utils.Metrics
+	if len(r.Deleted) > 0 {
+		for _, m := range source {
+			metrics = append(metrics, m)
+		}
+	} else {
+		for _, name := range append(append([]string(nil), r.Created...), r.Updated...) {
+			metrics = append(metrics, source[name])
+		}
+	}
+	if _, err := storage.Import(ctx, dst, metrics, len(r.Deleted) > 0); err != nil {
+		return r, fmt.Errorf("import into target: %w", err)
+	}
+	return verify(r, source, dst, opts)
+}
+
+// verify sets r.Mismatched to the metrics dst does not hold as in source if
+// opts.Verify is set. A dry run is verified against the unchanged target.
+func verify(r Report, source map[string]utils.Metrics, dst storage.Storage, opts Options) (Report, error) {
+	if !opts.Verify {
+		return r, nil
+	}
+	check := Plan(source, dst.GetAllMetrics(), opts.Replace)
+	r.Mismatched = append(append(append([]string(nil), check.Created...), check.Updated...), check.Deleted...)
+	sort.Strings(r.Mismatched)
+	if len(r.Mismatched) > 0 {
+		return r, ErrMismatch
+	}
+	return r, nil
+}
+
+// ReadFile loads the snapshot file written by the server in file mode into
+// a MemStorage. A missing file is an empty storage if missingOK is set.
+func ReadFile(path string, missingOK bool) (*storage.MemStorage, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && missingOK {
+		return storage.NewMemStorage(&sync.Map{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metrics map[string]utils.Metrics
+	if err := json.Unmarshal(content, &metrics); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	var m sync.Map
+	for name, metric := range metrics {
+		if metric.ID == "" {
+			metric.ID = name
+		}
+		m.Store(name, metric)
+	}
+	return storage.NewMemStorage(&m), nil
+}
+
+// WriteFile writes the metrics of st to path in the format of the snapshot
+// file. The file is replaced atomically, so the server never reads a
+// partial snapshot.
+func WriteFile(path string, st storage.Storage) error {
+	data, err := json.Marshal(st.GetAllMetrics())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
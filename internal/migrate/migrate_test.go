@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStorage(metrics ...utils.Metrics) *storage.MemStorage {
+	var m sync.Map
+	for _, metric := range metrics {
+		m.Store(metric.ID, metric)
+	}
+	return storage.NewMemStorage(&m)
+}
+
+func TestPlan(t *testing.T) {
+	source := map[string]utils.Metrics{
+		"PollCount": utils.NewMetrics("PollCount", int64(10), true),
+		"Alloc":     utils.NewMetrics("Alloc", 1.5, false),
+		"Load":      utils.NewMetrics("Load", 0.5, false),
+	}
+	target := map[string]utils.Metrics{
+		"PollCount": utils.NewMetrics("PollCount", int64(4), true),
+		"Load":      utils.NewMetrics("Load", 0.5, false),
+		"Stale":     utils.NewMetrics("Stale", int64(1), true),
+	}
+
+	assert.Equal(t, Report{Created: []string{"Alloc"}, Updated: []string{"PollCount"}, Unchanged: 1}, Plan(source, target, false))
+	assert.Equal(t, []string{"Stale"}, Plan(source, target, true).Deleted)
+	assert.False(t, Equal(utils.NewMetrics("a", int64(1), true), utils.NewMetrics("a", 1.0, false)))
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	src := newStorage(utils.NewMetrics("PollCount", int64(10), true), utils.NewMetrics("Alloc", 1.5, false))
+	dst := newStorage(utils.NewMetrics("PollCount", int64(4), true), utils.NewMetrics("Stale", 1.0, false))
+
+	// A dry run writes nothing, and verification reports the differences
+	r, err := Run(ctx, src, dst, Options{DryRun: true, Verify: true})
+	assert.ErrorIs(t, err, ErrMismatch)
+	assert.Equal(t, []string{"Alloc", "PollCount"}, r.Mismatched)
+	assert.Len(t, dst.GetAllMetrics(), 2)
+
+	// Counters are copied by value, not added to the target
+	r, err = Run(ctx, src, dst, Options{Verify: true})
+	require.NoError(t, err)
+	assert.Equal(t, Report{Created: []string{"Alloc"}, Updated: []string{"PollCount"}}, r)
+	m, _ := dst.GetMetric("PollCount")
+	assert.Equal(t, int64(10), *m.Delta)
+	_, ok := dst.GetMetric("Stale")
+	assert.True(t, ok)
+
+	// A rerun is idempotent
+	r, err = Run(ctx, src, dst, Options{Verify: true})
+	require.NoError(t, err)
+	assert.False(t, r.Changed())
+	assert.Equal(t, 2, r.Unchanged)
+
+	r, err = Run(ctx, src, dst, Options{Replace: true, Verify: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Stale"}, r.Deleted)
+	assert.Equal(t, src.GetAllMetrics(), dst.GetAllMetrics())
+}
+
+func TestReadWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filestore.out")
+	_, err := ReadFile(path, false)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	st, err := ReadFile(path, true)
+	require.NoError(t, err)
+	assert.Empty(t, st.GetAllMetrics())
+
+	st = newStorage(utils.NewMetrics("PollCount", int64(10), true), utils.NewMetrics("Alloc", 1.5, false))
+	require.NoError(t, WriteFile(path, st))
+	read, err := ReadFile(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, st.GetAllMetrics(), read.GetAllMetrics())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	_, err = ReadFile(path, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+// Package query implements aggregation queries across stored metrics.
+//
+// This file contains the evaluation of a parsed Query against a Storage.
+package query
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// Group is the aggregate of the metrics sharing the same values of the "by" labels.
+type Group struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Count  int               `json:"count"`
+}
+
+// Result is the answer to a Query.
+//
+// Value is the aggregate over all matched metrics; it is omitted when the
+// function is undefined for an empty set (avg, min, max). Groups is filled
+// only for queries with "by".
+type Result struct {
+	Value  *float64 `json:"value,omitempty"`
+	Count  int      `json:"count"`
+	Groups []Group  `json:"groups,omitempty"`
+}
+
+// Eval runs q against st.
+//
+// The name pattern and a "type" equality matcher are pushed down to the
+// storage via storage.List; the remaining matchers are applied in memory.
+// Counters are aggregated as their float64 value.
+func Eval(ctx context.Context, st storage.Storage, q Query) (Result, error) {
+	filter := storage.Filter{Pattern: q.Pattern}
+	for _, m := range q.Matchers {
+		if m.Label == LabelType && !m.Negate {
+			filter.MType = m.Value
+			break
+		}
+	}
+	metrics, err := storage.List(ctx, st, filter)
+	if err != nil {
+		return Result{}, err
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := aggregator{fn: q.Func}
+	groups := make(map[string]*aggregator)
+	groupLabels := make(map[string]map[string]string)
+	for _, name := range names {
+		m := metrics[name]
+		v, ok := numeric(m)
+		if !ok || !q.matches(m) {
+			continue
+		}
+		total.add(v)
+		if len(q.By) == 0 {
+			continue
+		}
+		labels, key := q.groupKey(m)
+		g, found := groups[key]
+		if !found {
+			g = &aggregator{fn: q.Func}
+			groups[key] = g
+			groupLabels[key] = labels
+		}
+		g.add(v)
+	}
+
+	result := Result{Count: total.n}
+	if v, ok := total.value(); ok {
+		result.Value = &v
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v, _ := groups[key].value()
+		result.Groups = append(result.Groups, Group{Labels: groupLabels[key], Value: v, Count: groups[key].n})
+	}
+	return result, nil
+}
+
+// matches reports whether m passes the name pattern and every matcher of q.
+func (q Query) matches(m utils.Metrics) bool {
+	if !(storage.Filter{Pattern: q.Pattern}).Match(m) {
+		return false
+	}
+	for _, matcher := range q.Matchers {
+		if (labelValue(m, matcher.Label) == matcher.Value) == matcher.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// groupKey returns the "by" labels of m and a key identifying their values.
+func (q Query) groupKey(m utils.Metrics) (map[string]string, string) {
+	labels := make(map[string]string, len(q.By))
+	values := make([]string, len(q.By))
+	for i, l := range q.By {
+		values[i] = labelValue(m, l)
+		labels[l] = values[i]
+	}
+	return labels, strings.Join(values, "\xff")
+}
+
+// labelValue returns the value of label for m.
+func labelValue(m utils.Metrics, label string) string {
+	switch label {
+	case LabelName:
+		return m.ID
+	case LabelType:
+		return m.MType
+	case LabelUnit:
+		md, _ := metadata.Default.Get(m.ID)
+		return md.Unit
+	}
+	return ""
+}
+
+// numeric returns the value of m as float64.
+func numeric(m utils.Metrics) (float64, bool) {
+	switch {
+	case m.MType == "counter" && m.Delta != nil:
+		return float64(*m.Delta), true
+	case m.MType == "gauge" && m.Value != nil:
+		return *m.Value, true
+	}
+	return 0, false
+}
+
+// aggregator accumulates values for one aggregation function.
+type aggregator struct {
+	fn       string
+	sum      float64
+	min, max float64
+	n        int
+}
+
+func (a *aggregator) add(v float64) {
+	if a.n == 0 {
+		a.min, a.max = v, v
+	}
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	a.sum += v
+	a.n++
+}
+
+// value returns the aggregate, or false if it is undefined for no values.
+func (a *aggregator) value() (float64, bool) {
+	switch a.fn {
+	case FuncSum:
+		return a.sum, true
+	case FuncCount:
+		return float64(a.n), true
+	}
+	if a.n == 0 {
+		return 0, false
+	}
+	switch a.fn {
+	case FuncAvg:
+		return a.sum / float64(a.n), true
+	case FuncMin:
+		return a.min, true
+	}
+	return a.max, true
+}
@@ -0,0 +1,259 @@
+// Package query implements aggregation queries across stored metrics.
+//
+// A query has the form
+//
+//	func [by (label, ...)] (selector)
+//
+// where func is one of sum, avg, min, max and count, and selector is a metric
+// name pattern with "*" wildcards, optionally followed by label matchers:
+//
+//	avg(CPUutilization*)
+//	sum by (type) ({unit="bytes"})
+//	max(*{type="gauge", unit!="percent"})
+//
+// Metrics carry no labels of their own, so the labels available for matching
+// and grouping are the attributes every metric has: __name__, type and unit
+// (the latter taken from the metadata registry).
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Aggregation functions.
+const (
+	FuncSum   = "sum"
+	FuncAvg   = "avg"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncCount = "count"
+)
+
+// Labels that can be matched and grouped by.
+const (
+	LabelName = "__name__"
+	LabelType = "type"
+	LabelUnit = "unit"
+)
+
+// Matcher selects metrics whose label equals (or, if Negate is set, differs from) Value.
+type Matcher struct {
+	Label  string
+	Value  string
+	Negate bool
+}
+
+// Query is a parsed aggregation query.
+type Query struct {
+	Func     string    // функция агрегации
+	By       []string  // метки группировки; пусто для скалярного результата
+	Pattern  string    // шаблон имени метрики, "*" — любая последовательность символов
+	Matchers []Matcher // условия на метки
+}
+
+// Parse parses an aggregation query.
+func Parse(s string) (Query, error) {
+	p := &parser{s: s}
+	var q Query
+
+	p.skipSpace()
+	q.Func = strings.ToLower(p.ident())
+	switch q.Func {
+	case FuncSum, FuncAvg, FuncMin, FuncMax, FuncCount:
+	case "":
+		return q, p.errorf("aggregation function expected")
+	default:
+		return q, fmt.Errorf("unknown aggregation function %q", q.Func)
+	}
+
+	p.skipSpace()
+	if p.peek() != '(' {
+		if kw := p.ident(); !strings.EqualFold(kw, "by") {
+			return q, p.errorf(`"(" or "by" expected`)
+		}
+		by, err := p.labelList()
+		if err != nil {
+			return q, err
+		}
+		q.By = by
+	}
+
+	if err := p.expect('('); err != nil {
+		return q, err
+	}
+	p.skipSpace()
+	q.Pattern = p.pattern()
+	p.skipSpace()
+	if p.peek() == '{' {
+		matchers, err := p.matchers()
+		if err != nil {
+			return q, err
+		}
+		q.Matchers = matchers
+	}
+	if err := p.expect(')'); err != nil {
+		return q, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return q, p.errorf("unexpected trailing input")
+	}
+	if q.Pattern == "" && len(q.Matchers) == 0 {
+		return q, fmt.Errorf("selector must have a name pattern or a label matcher")
+	}
+	return q, nil
+}
+
+// parser is a hand-written scanner over the query text.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *parser) expect(ch byte) error {
+	p.skipSpace()
+	if p.peek() != ch {
+		return p.errorf("%q expected", ch)
+	}
+	p.pos++
+	return nil
+}
+
+// scan consumes the longest run of bytes accepted by ok.
+func (p *parser) scan(ok func(i int, ch byte) bool) string {
+	start := p.pos
+	for p.pos < len(p.s) && ok(p.pos-start, p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// ident consumes an identifier such as a function or label name.
+func (p *parser) ident() string {
+	return p.scan(func(i int, ch byte) bool {
+		return ch == '_' || isLetter(ch) || (i > 0 && isDigit(ch))
+	})
+}
+
+// pattern consumes a metric name pattern.
+func (p *parser) pattern() string {
+	return p.scan(func(_ int, ch byte) bool {
+		return isLetter(ch) || isDigit(ch) || strings.IndexByte("_.:-*", ch) >= 0
+	})
+}
+
+// label consumes a label name and checks that it is supported.
+func (p *parser) label() (string, error) {
+	p.skipSpace()
+	name := p.ident()
+	switch name {
+	case LabelName, LabelType, LabelUnit:
+		return name, nil
+	case "":
+		return "", p.errorf("label name expected")
+	}
+	return "", fmt.Errorf("unknown label %q: metrics only have %s, %s and %s", name, LabelName, LabelType, LabelUnit)
+}
+
+// labelList consumes "(label, ...)".
+func (p *parser) labelList() ([]string, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for {
+		l, err := p.label()
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+		p.skipSpace()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return labels, p.expect(')')
+}
+
+// matchers consumes `{label="value", label!="value", ...}`.
+func (p *parser) matchers() ([]Matcher, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var result []Matcher
+	for {
+		var m Matcher
+		var err error
+		if m.Label, err = p.label(); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if strings.HasPrefix(p.s[p.pos:], "!=") {
+			m.Negate = true
+			p.pos += 2
+		} else if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		if m.Value, err = p.quoted(); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+		p.skipSpace()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return result, p.expect('}')
+}
+
+// quoted consumes a double-quoted Go string literal.
+func (p *parser) quoted() (string, error) {
+	p.skipSpace()
+	if p.peek() != '"' {
+		return "", p.errorf("quoted label value expected")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			v, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				return "", fmt.Errorf("at position %d: %w", start, err)
+			}
+			return v, nil
+		}
+	}
+	return "", p.errorf("unterminated label value")
+}
+
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
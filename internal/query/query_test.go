@@ -0,0 +1,105 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
+	"github.com/stepanov-ds/ya-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Query
+		wantErr bool
+	}{
+		{
+			name:  "Positive #1 pattern",
+			input: "avg(CPUutilization*)",
+			want:  Query{Func: FuncAvg, Pattern: "CPUutilization*"},
+		},
+		{
+			name:  "Positive #2 by and matchers",
+			input: ` SUM by (type, unit) (*{unit="bytes", type!="counter"}) `,
+			want: Query{
+				Func:    FuncSum,
+				By:      []string{LabelType, LabelUnit},
+				Pattern: "*",
+				Matchers: []Matcher{
+					{Label: LabelUnit, Value: "bytes"},
+					{Label: LabelType, Value: "counter", Negate: true},
+				},
+			},
+		},
+		{
+			name:  "Positive #3 matchers only",
+			input: `count({type="gauge"})`,
+			want:  Query{Func: FuncCount, Matchers: []Matcher{{Label: LabelType, Value: "gauge"}}},
+		},
+		{name: "Negative #1 unknown function", input: "median(x)", wantErr: true},
+		{name: "Negative #2 unknown label", input: "sum by (host) (x*)", wantErr: true},
+		{name: "Negative #3 empty selector", input: "sum()", wantErr: true},
+		{name: "Negative #4 unterminated", input: `sum(x{type="gauge)`, wantErr: true},
+		{name: "Negative #5 trailing input", input: "sum(x) y", wantErr: true},
+		{name: "Negative #6 empty", input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, q)
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	reg := metadata.Default
+	metadata.Default = metadata.NewRegistry()
+	t.Cleanup(func() { metadata.Default = reg })
+	require.NoError(t, metadata.Default.Declare(metadata.Metadata{ID: "Alloc", MType: "gauge", Unit: "bytes"}))
+
+	st := storage.NewMemStorage(&sync.Map{})
+	ctx := context.Background()
+	st.SetMetric(ctx, "CPUutilization1", 10.0, false)
+	st.SetMetric(ctx, "CPUutilization2", 30.0, false)
+	st.SetMetric(ctx, "Alloc", 100.0, false)
+	st.SetMetric(ctx, "PollCount", 5, true)
+
+	eval := func(s string) Result {
+		q, err := Parse(s)
+		require.NoError(t, err)
+		res, err := Eval(ctx, st, q)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := eval("avg(CPUutilization*)")
+	require.NotNil(t, res.Value)
+	assert.Equal(t, 20.0, *res.Value)
+	assert.Equal(t, 2, res.Count)
+
+	res = eval(`max({type="counter"})`)
+	require.NotNil(t, res.Value)
+	assert.Equal(t, 5.0, *res.Value)
+
+	res = eval("min(Missing*)")
+	assert.Nil(t, res.Value)
+	assert.Equal(t, 0, res.Count)
+
+	res = eval(`sum by (type, unit) (*{__name__!="PollCount"})`)
+	require.NotNil(t, res.Value)
+	assert.Equal(t, 140.0, *res.Value)
+	assert.Equal(t, []Group{
+		{Labels: map[string]string{"type": "gauge", "unit": ""}, Value: 40, Count: 2},
+		{Labels: map[string]string{"type": "gauge", "unit": "bytes"}, Value: 100, Count: 1},
+	}, res.Groups)
+}
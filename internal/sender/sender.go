@@ -10,20 +10,30 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/collector"
 	"github.com/stepanov-ds/ya-metrics/internal/config/agent"
+	"github.com/stepanov-ds/ya-metrics/internal/metadata"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
 
@@ -39,11 +49,12 @@ type HTTPClient interface {
 
 // HTTPSender implements metric sending via HTTP requests.
 type HTTPSender struct {
-	Headers   http.Header
-	Client    HTTPClient
-	CryptoKey *rsa.PublicKey
-	sem       chan struct{}
-	BaseURL   string
+	Headers     http.Header
+	Client      HTTPClient
+	CryptoKey   crypto.PublicKey
+	sem         chan struct{}
+	BaseURL     string
+	Compression string // Content-Encoding тела в SendMetricGzip
 }
 
 // NewHTTPSender creates and returns a new HTTPSender instance.
@@ -53,22 +64,64 @@ type HTTPSender struct {
 // - Headers to be used in each request
 // - HTTP client with timeout
 // - Semaphore based on rate limit
-func NewHTTPSender(timeout time.Duration, headers http.Header, baseURL string, rateLimit int, cryptoKey *rsa.PublicKey) HTTPSender {
+func NewHTTPSender(timeout time.Duration, headers http.Header, baseURL string, rateLimit int, cryptoKey crypto.PublicKey) HTTPSender {
 	return HTTPSender{
-		sem:       make(chan struct{}, rateLimit),
-		BaseURL:   baseURL,
-		CryptoKey: cryptoKey,
-		Headers:   headers,
+		sem:         make(chan struct{}, rateLimit),
+		BaseURL:     baseURL,
+		CryptoKey:   cryptoKey,
+		Headers:     headers,
+		Compression: utils.EncodingGzip,
 		Client: &http.Client{
 			Timeout: timeout,
 		},
 	}
 }
 
-func Encrypt(plainText []byte, publicKey *rsa.PublicKey) (*utils.EncryptedPayload, error) {
-	aesKey := make([]byte, 32)
-	if _, err := rand.Read(aesKey); err != nil {
-		return nil, fmt.Errorf("ошибка генерации AES ключа: %v", err)
+// NewTLSClient returns an HTTP client with the given timeout that uses cfg
+// for https connections.
+func NewTLSClient(timeout time.Duration, cfg *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// Encrypt seals plainText into a v2 utils.EncryptedPayload for publicKey.
+//
+// The body is encrypted with AES-256-GCM. For an RSA key a random AES key
+// is wrapped with RSA-OAEP-SHA256; for an EC (P-256) or X25519 key it is
+// derived with ephemeral-static ECDH. The envelope carries the algorithm,
+// the key ID of publicKey and a fresh timestamp and nonce for replay
+// protection, all authenticated as additional data.
+func Encrypt(plainText []byte, publicKey crypto.PublicKey) (*utils.EncryptedPayload, error) {
+	requestNonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	payload := &utils.EncryptedPayload{
+		Version:      utils.PayloadV2,
+		Timestamp:    time.Now().Unix(),
+		RequestNonce: requestNonce,
+	}
+
+	var aesKey []byte
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		aesKey, err = wrapRSA(payload, pub)
+	case *ecdsa.PublicKey:
+		var ecdhPub *ecdh.PublicKey
+		if ecdhPub, err = pub.ECDH(); err == nil {
+			aesKey, err = agreeECDH(payload, ecdhPub)
+		}
+	case *ecdh.PublicKey:
+		aesKey, err = agreeECDH(payload, pub)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый тип ключа %T", publicKey)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	block, err := aes.NewCipher(aesKey)
@@ -86,129 +139,339 @@ func Encrypt(plainText []byte, publicKey *rsa.PublicKey) (*utils.EncryptedPayloa
 		return nil, fmt.Errorf("ошибка генерации nonce: %v", err)
 	}
 
-	cipherText := gcm.Seal(nil, nonce, plainText, nil)
+	payload.CipherText = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plainText, payload.AAD()))
+	payload.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	return payload, nil
+}
+
+// wrapRSA generates an AES key and stores it in payload wrapped with RSA-OAEP-SHA256.
+func wrapRSA(payload *utils.EncryptedPayload, pub *rsa.PublicKey) ([]byte, error) {
+	keyID, err := utils.KeyID(pub)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка вычисления идентификатора ключа: %v", err)
+	}
+
+	aesKey := make([]byte, 32)
+	if _, err = rand.Read(aesKey); err != nil {
+		return nil, fmt.Errorf("ошибка генерации AES ключа: %v", err)
+	}
 
-	encryptedAESKey, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, aesKey)
+	encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, nil)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка шифрования AES ключа RSA: %v", err)
 	}
 
-	payload := &utils.EncryptedPayload{
-		EncryptedAESKey: base64.StdEncoding.EncodeToString(encryptedAESKey),
-		CipherText:      base64.StdEncoding.EncodeToString(cipherText),
-		Nonce:           base64.StdEncoding.EncodeToString(nonce),
+	payload.Alg = utils.AlgRSAOAEP256
+	payload.KeyID = keyID
+	payload.EncryptedAESKey = base64.StdEncoding.EncodeToString(encryptedAESKey)
+	return aesKey, nil
+}
+
+// agreeECDH derives an AES key from an ephemeral ECDH key pair and stores
+// the ephemeral public key in payload.
+func agreeECDH(payload *utils.EncryptedPayload, pub *ecdh.PublicKey) ([]byte, error) {
+	keyID, err := utils.KeyID(pub)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка вычисления идентификатора ключа: %v", err)
 	}
 
-	return payload, nil
+	ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации эфемерного ключа: %v", err)
+	}
+	secret, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка ECDH: %v", err)
+	}
+	aesKey, err := utils.DeriveECDHKey(secret, ephemeral.PublicKey(), pub)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка вывода AES ключа: %v", err)
+	}
+
+	payload.Alg = utils.AlgECDHES
+	payload.KeyID = keyID
+	payload.EphemeralKey = base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes())
+	return aesKey, nil
+}
+
+// newNonce returns a random hex value that identifies a single request.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ошибка генерации nonce запроса: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sign adds the HashSHA256 signature of body to req if a key is configured.
+//
+// The signature covers a fresh timestamp and nonce sent in the
+// utils.TimestampHeader and utils.NonceHeader headers, so the server can
+// reject replayed requests. The key ID is sent in "Key-Id" if set.
+func sign(req *http.Request, body []byte) error {
+	if *agent.Key == "" {
+		return nil
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(utils.TimestampHeader, timestamp)
+	req.Header.Set(utils.NonceHeader, nonce)
+	req.Header.Add("HashSHA256", utils.CalculateHashWithKey(utils.SignedMessage(timestamp, nonce, body), *agent.Key))
+	if *agent.KeyID != "" {
+		req.Header.Add("Key-Id", *agent.KeyID)
+	}
+	return nil
 }
 
 // SendMetric sends a single metric to the server using HTTP POST.
 //
-// Applies exponential backoff retry strategy if request fails.
+// Retries the request as described in send.
+// The request carries a new X-Request-ID and traceparent (see newTrace),
+// which a returned error mentions.
 func (s *HTTPSender) SendMetric(m interface{}, path string) error {
 	jsonBytes, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
-	if s.CryptoKey != nil {
-		encryptedPayload, err1 := Encrypt(jsonBytes, s.CryptoKey)
-		if err1 != nil {
-			return err1
+
+	trace := newTrace(s.Headers)
+	build := func() (*http.Request, error) {
+		body := jsonBytes
+		if s.CryptoKey != nil {
+			encryptedPayload, err := Encrypt(jsonBytes, s.CryptoKey)
+			if err != nil {
+				return nil, err
+			}
+			if body, err = json.Marshal(encryptedPayload); err != nil {
+				return nil, err
+			}
 		}
-		jsonBytes, err = json.Marshal(encryptedPayload)
+
+		req, err := http.NewRequest(http.MethodPost, s.BaseURL+path, bytes.NewReader(body))
 		if err != nil {
-			return err
+			return nil, err
+		}
+		req.Header = s.Headers.Clone()
+		if s.CryptoKey != nil {
+			req.Header.Set("Content-Type", utils.EncryptedContentType)
 		}
+		setTrace(req, trace)
+		return req, sign(req, jsonBytes)
 	}
 
-	var hashString string
-	if *agent.Key != "" {
-		hashString = utils.CalculateHashWithKey(jsonBytes, *agent.Key)
+	if err = s.send(build); err != nil {
+		return fmt.Errorf("request %s: %w", trace.RequestID, err)
+	}
+	return nil
+}
+
+// SendMetricGzip sends a single metric to the server using compressed HTTP POST.
+//
+// The body is compressed with s.Compression (gzip, deflate, zstd or br;
+// gzip if empty). Applies compression and optional payload signing.
+// Retries the request as described in send.
+func (s *HTTPSender) SendMetricGzip(m interface{}, path string) error {
+	jsonBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, s.BaseURL+path, bytes.NewBuffer(jsonBytes))
+	encoding := s.Compression
+	if encoding == "" {
+		encoding = utils.EncodingGzip
+	}
+	compressed, err := utils.Compress(encoding, jsonBytes)
 	if err != nil {
 		return err
 	}
-	req.Header = s.Headers
-	if *agent.Key != "" {
-		req.Header.Add("HashSHA256", hashString)
+
+	trace := newTrace(s.Headers)
+	build := func() (*http.Request, error) {
+		body := compressed
+		if s.CryptoKey != nil {
+			encryptedPayload, err := Encrypt(compressed, s.CryptoKey)
+			if err != nil {
+				return nil, err
+			}
+			if body, err = json.Marshal(encryptedPayload); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodPost, s.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = s.Headers.Clone()
+		if s.CryptoKey != nil {
+			req.Header.Set("Content-Type", utils.EncryptedContentType)
+		}
+		req.Header.Add("Content-Encoding", encoding)
+		req.Header.Add("Accept-Encoding", "gzip")
+		setTrace(req, trace)
+		return req, sign(req, jsonBytes)
 	}
 
+	if err = s.send(build); err != nil {
+		return fmt.Errorf("request %s: %w", trace.RequestID, err)
+	}
+	return nil
+}
+
+// maxRetryAfter is the longest Retry-After the sender waits for; a request
+// asked to wait longer fails and its metrics go with the next report.
+const maxRetryAfter = time.Minute
+
+// send performs the request made by build, retrying it with
+// utils.NewOneThreeFiveBackOff when the server cannot be reached or answers
+// 429 Too Many Requests or 503 Service Unavailable. The Retry-After header
+// of such an answer replaces the next backoff interval.
+//
+// Every attempt sends a request built anew, with a fresh envelope and
+// signature nonce: the server may have recorded the nonce of a request it
+// then refused, and would reject its retry as replayed.
+func (s *HTTPSender) send(build func() (*http.Request, error)) error {
+	b := &retryAfterBackOff{BackOff: utils.NewOneThreeFiveBackOff()}
 	operation := func() (string, error) {
-		var resp *http.Response
-		resp, err = s.Client.Do(req)
+		req, err := build()
+		if err != nil {
+			return "", backoff.Permanent(err)
+		}
+		resp, err := s.Client.Do(req)
 		if err != nil {
 			return "", err
 		}
 		defer resp.Body.Close()
-		return "", err
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			err = fmt.Errorf("server answered %s", resp.Status)
+			if wait, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if wait > maxRetryAfter {
+					return "", backoff.Permanent(fmt.Errorf("%w, retry after %s", err, wait))
+				}
+				b.wait, b.asked = wait, true
+			}
+			return "", err
+		}
+		logRejected(resp)
+		return "", nil
 	}
-
-	_, err = backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
+	_, err := backoff.RetryWithData(operation, b)
 	return err
 }
 
-// SendMetricGzip sends a single metric to the server using gzip-compressed HTTP POST.
-//
-// Applies compression and optional payload signing.
-// Uses exponential backoff retry strategy if request fails.
-func (s *HTTPSender) SendMetricGzip(m interface{}, path string) error {
-	jsonBytes, err := json.Marshal(m)
-	if err != nil {
-		return err
+// retryAfterBackOff waits as long as the server asked before the next
+// retry, or as long as the wrapped BackOff says if it did not. Retries
+// count against the wrapped BackOff either way.
+type retryAfterBackOff struct {
+	backoff.BackOff
+	wait  time.Duration // интервал из Retry-After последнего ответа
+	asked bool          // последний ответ содержал Retry-After
+}
+
+// NextBackOff returns the Retry-After interval, if any, or the next
+// interval of the wrapped BackOff.
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop || !b.asked {
+		return next
 	}
+	b.asked = false
+	return b.wait
+}
 
-	var hashString string
-	if *agent.Key != "" {
-		hashString = utils.CalculateHashWithKey(jsonBytes, *agent.Key)
+// retryAfter parses a Retry-After header value, either delay seconds or
+// an HTTP date, into the time to wait from now.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
 	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
 
-	var buf bytes.Buffer
-	gzWriter := gzip.NewWriter(&buf)
+// SendMetadata declares metrics on the server via POST /api/metadata.
+//
+// Uses the same encryption, signing and retry rules as SendMetric.
+func (s *HTTPSender) SendMetadata(md []metadata.Metadata) error {
+	return s.SendMetric(md, "/api/metadata")
+}
 
-	if _, err = gzWriter.Write(jsonBytes); err != nil {
-		return err
-	}
-	if err = gzWriter.Close(); err != nil {
-		return err
+// RejectedItems extracts the errors the server reported for a request.
+//
+// A 207 Multi-Status response yields every rejected item of the
+// apierror.BatchResponse; a 4xx response yields its single apierror.Error.
+// Returns nil for successful responses or bodies that cannot be decoded.
+func RejectedItems(resp *http.Response) []apierror.Error {
+	if resp.StatusCode != http.StatusMultiStatus && (resp.StatusCode < 400 || resp.StatusCode >= 500) {
+		return nil
 	}
 
-	if s.CryptoKey != nil {
-		encryptedPayload, err1 := Encrypt(buf.Bytes(), s.CryptoKey)
-		if err1 != nil {
-			return err1
-		}
-		encryptedBytes, err2 := json.Marshal(encryptedPayload)
+	var reader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzReader, err := gzip.NewReader(resp.Body)
 		if err != nil {
-			return err2
+			return nil
 		}
-		buf = *bytes.NewBuffer(encryptedBytes)
+		defer gzReader.Close()
+		reader = gzReader
 	}
-
-	req, err := http.NewRequest(http.MethodPost, s.BaseURL+path, &buf)
+	body, err := io.ReadAll(reader)
 	if err != nil {
-		return err
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusMultiStatus {
+		var batch apierror.BatchResponse
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil
+		}
+		return batch.Rejected
 	}
-	req.Header = s.Headers.Clone()
-	req.Header.Add("Content-Encoding", "gzip")
-	req.Header.Add("Accept-Encoding", "gzip")
-	if *agent.Key != "" {
-		req.Header.Add("HashSHA256", hashString)
+	var single apierror.Response
+	if err := json.Unmarshal(body, &single); err != nil || single.Error.Code == "" {
+		return nil
 	}
+	return []apierror.Error{single.Error}
+}
 
-	operation := func() (string, error) {
-		var resp *http.Response
-		resp, err = s.Client.Do(req)
-		if err != nil {
-			return "", err
+// logRejected prints every item the server refused to store, with the
+// request ID to look the request up in the server log.
+func logRejected(resp *http.Response) {
+	requestID := resp.Request.Header.Get(utils.RequestIDHeader)
+	for _, e := range RejectedItems(resp) {
+		if e.Index != nil {
+			println("request", requestID, "rejected item", *e.Index, e.Error())
+		} else {
+			println("request", requestID, "rejected", resp.Request.URL.Path, e.Error())
 		}
-		defer resp.Body.Close()
-		return "", err
 	}
+}
 
-	_, err = backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
-	return err
+// newTrace returns a new request ID and span for a request. A valid
+// traceparent among the sender headers is continued, otherwise every
+// request starts a trace of its own.
+func newTrace(headers http.Header) utils.Trace {
+	trace := utils.NewTrace()
+	if parent, ok := utils.ParseTraceparent(headers.Get(utils.TraceparentHeader)); ok {
+		trace.TraceID, trace.Flags = parent.TraceID, parent.Flags
+	}
+	return trace
+}
+
+// setTrace tags req with the request ID and traceparent of trace; retries
+// of a request keep them.
+func setTrace(req *http.Request, trace utils.Trace) {
+	req.Header.Set(utils.TraceparentHeader, trace.Traceparent())
+	req.Header.Set(utils.RequestIDHeader, trace.RequestID)
 }
 
 // SendAll sends all metrics in bulk at the specified interval.
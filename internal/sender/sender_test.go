@@ -3,6 +3,10 @@ package sender
 import (
 	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
@@ -14,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stepanov-ds/ya-metrics/internal/apierror"
 	"github.com/stepanov-ds/ya-metrics/internal/collector"
 	"github.com/stepanov-ds/ya-metrics/internal/config/agent"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
@@ -48,6 +53,30 @@ func TestEncryptDecrypt(t *testing.T) {
 	assert.NotEmpty(t, payload.EncryptedAESKey)
 	assert.NotEmpty(t, payload.CipherText)
 	assert.NotEmpty(t, payload.Nonce)
+
+	keyID, err := utils.KeyID(pub)
+	require.NoError(t, err)
+	assert.Equal(t, utils.PayloadV2, payload.Version)
+	assert.Equal(t, utils.AlgRSAOAEP256, payload.Alg)
+	assert.Equal(t, keyID, payload.KeyID)
+}
+
+func TestEncrypt_ECKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, pub := range []crypto.PublicKey{&ecKey.PublicKey, x25519.PublicKey()} {
+		payload, err := Encrypt([]byte("secret_data"), pub)
+		require.NoError(t, err)
+		assert.Equal(t, utils.AlgECDHES, payload.Alg)
+		assert.NotEmpty(t, payload.EphemeralKey)
+		assert.Empty(t, payload.EncryptedAESKey)
+	}
+
+	_, err = Encrypt([]byte("secret_data"), "not a key")
+	assert.Error(t, err)
 }
 
 type roundTripFunc func(req *http.Request) (*http.Response, error)
@@ -87,6 +116,12 @@ func TestSendMetric(t *testing.T) {
 func TestSendMetricWithHash(t *testing.T) {
 	key := "test_secret_key"
 	agent.Key = &key
+	keyID := "agent-1"
+	agent.KeyID = &keyID
+	t.Cleanup(func() {
+		empty := ""
+		agent.KeyID = &empty
+	})
 
 	metric := utils.Metrics{
 		ID:    "test_counter",
@@ -98,9 +133,13 @@ func TestSendMetricWithHash(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		hash := r.Header.Get("HashSHA256")
 		body, _ := io.ReadAll(r.Body)
-		expectedHash := utils.CalculateHashWithKey(body, key)
+		timestamp, nonce := r.Header.Get(utils.TimestampHeader), r.Header.Get(utils.NonceHeader)
+		assert.NotEmpty(t, timestamp)
+		assert.NotEmpty(t, nonce)
+		expectedHash := utils.CalculateHashWithKey(utils.SignedMessage(timestamp, nonce, body), key)
 
 		assert.Equal(t, expectedHash, hash)
+		assert.Equal(t, keyID, r.Header.Get("Key-Id"))
 		w.WriteHeader(http.StatusOK)
 	}
 
@@ -153,6 +192,241 @@ func TestSendMetricGzip(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSendMetricGzip_Compression(t *testing.T) {
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, utils.EncodingZstd, r.Header.Get("Content-Encoding"))
+		decoder, err := utils.NewDecoder(utils.EncodingZstd, r.Body, 1<<20)
+		require.NoError(t, err)
+		defer decoder.Close()
+		var received utils.Metrics
+		require.NoError(t, json.NewDecoder(decoder).Decode(&received))
+		assert.Equal(t, metric.ID, received.ID)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	sender := NewHTTPSender(5*time.Second, headers, createTestServer(http.HandlerFunc(handler)), 1, nil)
+	sender.Compression = utils.EncodingZstd
+
+	assert.NoError(t, sender.SendMetricGzip(metric, "/update"))
+}
+
+func TestSendMetric_EncryptedContentType(t *testing.T) {
+	_, pub := generateRSAKeys(t)
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, utils.EncryptedContentType, r.Header.Get("Content-Type"))
+		var payload utils.EncryptedPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, utils.PayloadV2, payload.Version)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	sender := NewHTTPSender(5*time.Second, headers, createTestServer(http.HandlerFunc(handler)), 1, pub)
+
+	assert.NoError(t, sender.SendMetric(metric, "/update"))
+	assert.NoError(t, sender.SendMetricGzip(metric, "/updates"))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+}
+
+func TestSendMetric_EncryptedSigned(t *testing.T) {
+	// The server verifies the signature once the body is decrypted
+	_, pub := generateRSAKeys(t)
+	key := "test_secret_key"
+	oldKey := agent.Key
+	agent.Key = &key
+	t.Cleanup(func() { agent.Key = oldKey })
+
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+	plain, err := json.Marshal(metric)
+	require.NoError(t, err)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		message := func(body []byte) []byte {
+			return utils.SignedMessage(r.Header.Get(utils.TimestampHeader), r.Header.Get(utils.NonceHeader), body)
+		}
+		assert.Equal(t, utils.CalculateHashWithKey(message(plain), key), r.Header.Get("HashSHA256"))
+		assert.NotEqual(t, utils.CalculateHashWithKey(message(body), key), r.Header.Get("HashSHA256"))
+		w.WriteHeader(http.StatusOK)
+	}
+	sender := NewHTTPSender(5*time.Second, make(http.Header), createTestServer(http.HandlerFunc(handler)), 1, pub)
+
+	assert.NoError(t, sender.SendMetric(metric, "/updates"))
+}
+
+func TestSendMetric_Trace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var requestIDs []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		trace, ok := utils.ParseTraceparent(r.Header.Get(utils.TraceparentHeader))
+		require.True(t, ok)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceID)
+		assert.NotEqual(t, "00f067aa0ba902b7", trace.SpanID)
+		requestIDs = append(requestIDs, r.Header.Get(utils.RequestIDHeader))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	headers := make(http.Header)
+	headers.Set(utils.TraceparentHeader, parent)
+	sender := NewHTTPSender(5*time.Second, headers, createTestServer(http.HandlerFunc(handler)), 1, nil)
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+
+	require.NoError(t, sender.SendMetric(metric, "/update"))
+	require.NoError(t, sender.SendMetricGzip(metric, "/update"))
+	require.Len(t, requestIDs, 2)
+	assert.True(t, utils.ValidRequestID(requestIDs[0]))
+	assert.NotEqual(t, requestIDs[0], requestIDs[1])
+	assert.Equal(t, parent, headers.Get(utils.TraceparentHeader))
+}
+
+func TestSendMetric_RetryAfter(t *testing.T) {
+	var bodies []string
+	var calls int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		calls++
+		switch {
+		case r.URL.Path == "/slow":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case calls == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	sender := NewHTTPSender(5*time.Second, make(http.Header), createTestServer(http.HandlerFunc(handler)), 1, nil)
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+
+	start := time.Now()
+	require.NoError(t, sender.SendMetric(metric, "/update"))
+	assert.Less(t, time.Since(start), time.Second, "Retry-After: 0 must replace the 1s backoff")
+	require.Len(t, bodies, 2)
+	assert.NotEmpty(t, bodies[1], "the retry must resend the body")
+	assert.Equal(t, bodies[0], bodies[1])
+
+	calls = 0
+	err := sender.SendMetric(metric, "/slow")
+	assert.ErrorContains(t, err, "429")
+	assert.Equal(t, 1, calls, "a Retry-After beyond maxRetryAfter must not be waited for")
+}
+
+func TestSendMetric_RetryFreshNonce(t *testing.T) {
+	_, pub := generateRSAKeys(t)
+	key := "test_secret_key"
+	oldKey := agent.Key
+	agent.Key = &key
+	t.Cleanup(func() { agent.Key = oldKey })
+
+	var nonces, requestNonces, requestIDs []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var payload utils.EncryptedPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		nonces = append(nonces, r.Header.Get(utils.NonceHeader))
+		requestNonces = append(requestNonces, payload.RequestNonce)
+		requestIDs = append(requestIDs, r.Header.Get(utils.RequestIDHeader))
+		if len(nonces) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	sender := NewHTTPSender(5*time.Second, make(http.Header), createTestServer(http.HandlerFunc(handler)), 1, pub)
+	metric := utils.Metrics{ID: "test_gauge", MType: "gauge", Value: new(float64)}
+
+	for _, send := range []func(interface{}, string) error{sender.SendMetric, sender.SendMetricGzip} {
+		nonces, requestNonces, requestIDs = nil, nil, nil
+		require.NoError(t, send(metric, "/updates"))
+		require.Len(t, nonces, 2)
+		assert.NotEqual(t, nonces[0], nonces[1], "a retry must be signed with a new nonce")
+		assert.NotEqual(t, requestNonces[0], requestNonces[1], "a retry must carry a new envelope")
+		assert.Equal(t, requestIDs[0], requestIDs[1], "a retry keeps the request ID")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "5", want: 5 * time.Second, ok: true},
+		{value: "-1", want: 0, ok: true},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, ok: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
+
+func TestRejectedItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []apierror.Error
+		status   int
+	}{
+		{
+			name:     "ok response",
+			status:   http.StatusOK,
+			expected: nil,
+		},
+		{
+			name:   "partial batch",
+			status: http.StatusMultiStatus,
+			body:   `{"applied":[0],"rejected":[{"index":1,"code":"invalid_name","message":"bad name"}]}`,
+			expected: []apierror.Error{
+				apierror.NewItem(1, apierror.CodeInvalidName, "bad name"),
+			},
+		},
+		{
+			name:   "rejected batch",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"index":3,"code":"missing_value","message":"value is required for gauge"}}`,
+			expected: []apierror.Error{
+				apierror.NewItem(3, apierror.CodeMissingValue, "value is required for gauge"),
+			},
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			body:     `{"error":{"code":"internal","message":"oops"}}`,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}
+			serverURL := createTestServer(http.HandlerFunc(handler))
+
+			resp, err := http.Post(serverURL+"/updates", "application/json", nil)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expected, RejectedItems(resp))
+		})
+	}
+}
+
 func TestSendAll(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
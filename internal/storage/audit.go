@@ -0,0 +1,88 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains AuditStorage — a decorator that reports every stored
+// metric to an audit.Auditor.
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/audit"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// AuditStorage wraps a Storage and audits every SetMetric that succeeds.
+// Inside a transaction the metric is audited once the transaction commits.
+//
+// If ctx carries an audit.Pending the metric is added to it and the whole
+// request is logged as one event by whoever created it; otherwise the
+// metric is logged as an event of its own.
+type AuditStorage struct {
+	Storage
+	auditor *audit.Auditor
+	now     func() time.Time
+}
+
+// NewAuditStorage creates an AuditStorage reporting the updates of st to a.
+func NewAuditStorage(st Storage, a *audit.Auditor) *AuditStorage {
+	return &AuditStorage{
+		Storage: st,
+		auditor: a,
+		now:     time.Now,
+	}
+}
+
+// Unwrap returns the decorated Storage.
+func (s *AuditStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// Auditor returns the Auditor the updates are reported to.
+func (s *AuditStorage) Auditor() *audit.Auditor {
+	return s.auditor
+}
+
+// SetMetric stores the metric in the wrapped Storage, see WriteMetric.
+func (s *AuditStorage) SetMetric(ctx context.Context, key string, value interface{}, counter bool) {
+	_ = s.WriteMetric(ctx, key, value, counter)
+}
+
+// WriteMetric stores the metric in the wrapped Storage and audits the
+// update as it was received, before it is added to a counter, unless the
+// write fails. In a transaction begun by DBStorage the update is audited
+// after the commit, see OnCommit. Returns the error of the write.
+func (s *AuditStorage) WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error {
+	if err := WriteMetric(ctx, s.Storage, key, value, counter); err != nil {
+		return err
+	}
+
+	m := utils.NewMetrics(key, value, counter)
+	record := func() {
+		if p := audit.FromContext(ctx); p != nil {
+			p.Add(m)
+			return
+		}
+		s.auditor.Log(audit.Event{Time: s.now().UTC(), Metrics: []utils.Metrics{m}})
+	}
+	if !OnCommit(ctx, record) {
+		record()
+	}
+	return nil
+}
+
+// Audited returns the AuditStorage found in the decorator chain of st.
+//
+// Returns false if the updates of st are not audited.
+func Audited(st Storage) (*AuditStorage, bool) {
+	for {
+		if a, ok := st.(*AuditStorage); ok {
+			return a, true
+		}
+		w, ok := st.(interface{ Unwrap() Storage })
+		if !ok {
+			return nil, false
+		}
+		st = w.Unwrap()
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/audit"
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditStorage(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.OpenFileSink(path)
+	require.NoError(t, err)
+	a := audit.NewAuditor(16, sink)
+
+	mem := NewMemStorage(&sync.Map{})
+	st := NewAuditStorage(NewHistoryStorage(mem, time.Hour), a)
+	assert.Same(t, mem, Unwrap(st))
+	audited, ok := Audited(st)
+	assert.True(t, ok && audited == st)
+	_, ok = Audited(mem)
+	assert.False(t, ok)
+
+	// Without a pending request every update is an event of its own
+	st.SetMetric(context.Background(), "PollCount", int64(5), true)
+
+	ctx, pending := audit.NewContext(context.Background(), audit.Event{ClientIP: "10.0.0.1"})
+	v := 1.5
+	st.SetMetric(ctx, "Alloc", &v, false)
+	st.SetMetric(ctx, "PollCount", int64(2), true)
+	a.Log(pending.Event())
+	require.NoError(t, a.Close())
+
+	stored, _ := st.GetMetric("PollCount")
+	assert.Equal(t, int64(7), *stored.Delta)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var single, request audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &single))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &request))
+	assert.Equal(t, int64(5), *single.Metrics[0].Delta)
+	assert.False(t, single.Time.IsZero())
+	assert.Equal(t, "10.0.0.1", request.ClientIP)
+	require.Len(t, request.Metrics, 2)
+	assert.Equal(t, 1.5, *request.Metrics[0].Value)
+	// Counters are audited as sent, not as accumulated
+	assert.Equal(t, int64(2), *request.Metrics[1].Delta)
+}
+
+func TestAuditStorage_FailedOrUncommitted(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	a := audit.NewAuditor(16)
+	defer a.Close()
+
+	// A failed write is not audited
+	ctx, pending := audit.NewContext(context.Background(), audit.Event{})
+	st := NewAuditStorage(failingWriter{NewMemStorage(&sync.Map{})}, a)
+	assert.Error(t, st.WriteMetric(ctx, "Alloc", 1.5, false))
+	assert.Empty(t, pending.Event().Metrics)
+
+	// In a transaction the update is audited once it commits
+	hooks := &commitHooks{}
+	st = NewAuditStorage(NewMemStorage(&sync.Map{}), a)
+	require.NoError(t, st.WriteMetric(context.WithValue(ctx, utils.CommitHooks, hooks), "Alloc", 1.5, false))
+	assert.Empty(t, pending.Event().Metrics)
+	for _, fn := range hooks.fns {
+		fn()
+	}
+	assert.Len(t, pending.Event().Metrics, 1)
+}
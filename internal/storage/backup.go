@@ -0,0 +1,139 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains Clear and Import — loading a dump of metrics into any
+// Storage, as used to back up and restore a server.
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// ErrClearUnsupported is returned by Clear when no storage in the decorator
+// chain can remove metrics.
+var ErrClearUnsupported = errors.New("storage cannot remove metrics")
+
+// Clearer is implemented by storages that can remove every metric.
+//
+// Decorators that keep per-metric state implement it to drop that state
+// and pass the call on with Clear.
+type Clearer interface {
+	// Clear removes every metric.
+	Clear(ctx context.Context) error
+}
+
+// Clear removes every metric from st through the first Clearer found in
+// its decorator chain.
+func Clear(ctx context.Context, st Storage) error {
+	for {
+		if c, ok := st.(Clearer); ok {
+			return c.Clear(ctx)
+		}
+		w, ok := st.(interface{ Unwrap() Storage })
+		if !ok {
+			return ErrClearUnsupported
+		}
+		st = w.Unwrap()
+	}
+}
+
+// Import stores metrics, a dump such as the one GetAllMetrics returns, in
+// st and returns the number of metrics stored; metrics of an unknown type
+// or without a value are skipped. Metrics are stored in name order, inside
+// a transaction when the underlying storage is a DBStorage.
+//
+// Imported values replace the stored ones: a counter is set to the dumped
+// value rather than increased by it. With replace set, every metric of st
+// is removed first; otherwise metrics missing from the dump are kept.
+//
+// The series of the dump are checked against the series limits, for the
+// client carried by ctx, before anything is changed: the new ones are
+// admitted as a whole, see AdmitSeries, or with replace set the dump must
+// fit alone, see FitSeries. If it does not, nothing is imported and the
+// error is ErrSeriesLimit or ErrClientSeriesLimit.
+func Import(ctx context.Context, st Storage, metrics []utils.Metrics, replace bool) (int, error) {
+	var sorted []utils.Metrics
+	for _, m := range metrics {
+		if (m.MType == "counter" && m.Delta != nil) || (m.MType == "gauge" && m.Value != nil) {
+			sorted = append(sorted, m)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	names := make([]string, len(sorted))
+	for i, m := range sorted {
+		names[i] = m.ID
+	}
+
+	if replace {
+		if err := FitSeries(ctx, st, names); err != nil {
+			return 0, err
+		}
+		return importMetrics(ctx, st, sorted, true)
+	}
+	for _, err := range AdmitSeries(ctx, st, names, true) {
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := importMetrics(ctx, st, sorted, false)
+	if err != nil {
+		ReleaseSeries(ctx, st, names)
+	}
+	return n, err
+}
+
+// importMetrics stores the sorted metrics of Import in st. The stored
+// counters are read inside the transaction, with their rows locked, so
+// that a concurrent update cannot change them before they are set.
+func importMetrics(ctx context.Context, st Storage, sorted []utils.Metrics, replace bool) (int, error) {
+	db, isDB := Unwrap(st).(*DBStorage)
+	if isDB {
+		var err error
+		if ctx, err = db.BeginTransaction(ctx); err != nil {
+			return 0, err
+		}
+		defer db.RollbackTransaction(ctx)
+	}
+
+	current := make(map[string]utils.Metrics)
+	switch {
+	case replace:
+		if err := Clear(ctx, st); err != nil {
+			return 0, err
+		}
+	case isDB:
+		var err error
+		if current, err = db.LockMetrics(ctx); err != nil {
+			return 0, err
+		}
+	default:
+		current = st.GetAllMetrics()
+	}
+
+	for _, m := range sorted {
+		var err error
+		if m.MType == "counter" {
+			delta := *m.Delta
+			if old, ok := current[m.ID]; ok && old.Delta != nil {
+				delta -= *old.Delta
+			}
+			err = WriteMetric(ctx, st, m.ID, delta, true)
+		} else {
+			err = WriteMetric(ctx, st, m.ID, *m.Value, false)
+		}
+		if err != nil {
+			return 0, err
+		}
+		current[m.ID] = m
+	}
+
+	if isDB {
+		if err := db.CommitTransaction(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(sorted), nil
+}
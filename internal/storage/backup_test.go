@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	dump := []utils.Metrics{
+		utils.NewMetrics("PollCount", int64(10), true),
+		utils.NewMetrics("Alloc", 2.5, false),
+		{ID: "Broken", MType: "gauge"},
+	}
+
+	mem := NewMemStorage(&sync.Map{})
+	mem.SetMetric(ctx, "PollCount", int64(4), true)
+	mem.SetMetric(ctx, "Kept", 1.0, false)
+	st := NewCardinalityStorage(NewHistoryStorage(mem, time.Hour), 0, 0)
+
+	// Counters are set to the dumped value, not increased by it
+	n, err := Import(ctx, st, dump, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]utils.Metrics{
+		"PollCount": utils.NewMetrics("PollCount", int64(10), true),
+		"Alloc":     utils.NewMetrics("Alloc", 2.5, false),
+		"Kept":      utils.NewMetrics("Kept", 1.0, false),
+	}, st.GetAllMetrics())
+	assert.Equal(t, 3, st.Len())
+
+	// Importing the same dump again changes nothing
+	_, err = Import(ctx, st, dump, false)
+	require.NoError(t, err)
+	m, _ := st.GetMetric("PollCount")
+	assert.Equal(t, int64(10), *m.Delta)
+
+	_, err = Import(ctx, st, dump, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]utils.Metrics{
+		"PollCount": utils.NewMetrics("PollCount", int64(10), true),
+		"Alloc":     utils.NewMetrics("Alloc", 2.5, false),
+	}, st.GetAllMetrics())
+	assert.Equal(t, 2, st.Len())
+	h, _ := History(st)
+	_, samples, _ := h.Samples("Kept", time.Hour)
+	assert.Empty(t, samples)
+}
+
+func TestImport_SeriesLimit(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStorage(&sync.Map{})
+	mem.SetMetric(ctx, "Kept", 1.0, false)
+	st := NewCardinalityStorage(mem, 2, 0)
+
+	// The dump is admitted as a whole
+	_, err := Import(ctx, st, []utils.Metrics{
+		utils.NewMetrics("Alloc", 2.5, false),
+		utils.NewMetrics("PollCount", int64(10), true),
+	}, false)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	assert.Equal(t, map[string]utils.Metrics{"Kept": utils.NewMetrics("Kept", 1.0, false)}, st.GetAllMetrics())
+	assert.Equal(t, 1, st.Len())
+
+	// A dump that does not fit alone does not remove anything
+	_, err = Import(ctx, st, []utils.Metrics{
+		utils.NewMetrics("Alloc", 2.5, false),
+		utils.NewMetrics("HeapAlloc", 1.0, false),
+		utils.NewMetrics("PollCount", int64(10), true),
+	}, true)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	assert.Equal(t, map[string]utils.Metrics{"Kept": utils.NewMetrics("Kept", 1.0, false)}, st.GetAllMetrics())
+
+	// Replacing frees the series of the removed metrics first
+	n, err := Import(ctx, st, []utils.Metrics{
+		utils.NewMetrics("Alloc", 2.5, false),
+		utils.NewMetrics("PollCount", int64(10), true),
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, st.Len())
+}
+
+// importTx is a transaction that records its statements, answers queries
+// with stored and fails to commit with commitErr.
+type importTx struct {
+	pgx.Tx
+	commitErr error
+	stored    []utils.Metrics
+	execs     []string
+	args      [][]interface{}
+	queries   []string
+}
+
+func (tx *importTx) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.execs = append(tx.execs, strings.TrimSpace(sql))
+	tx.args = append(tx.args, args)
+	return pgconn.CommandTag{}, nil
+}
+
+func (tx *importTx) Query(_ context.Context, sql string, _ ...interface{}) (pgx.Rows, error) {
+	tx.queries = append(tx.queries, sql)
+	return &metricRows{metrics: tx.stored}, nil
+}
+
+func (tx *importTx) Commit(context.Context) error {
+	return tx.commitErr
+}
+
+func (tx *importTx) Rollback(context.Context) error {
+	return nil
+}
+
+// importPool begins tx and accepts every statement outside it.
+type importPool struct {
+	PgxPooler
+	tx *importTx
+}
+
+func (p *importPool) Begin(context.Context) (pgx.Tx, error) {
+	return p.tx, nil
+}
+
+func (p *importPool) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (p *importPool) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return &metricRows{}, nil
+}
+
+// metricRows is a query result with a row per metric.
+type metricRows struct {
+	pgx.Rows
+	metrics []utils.Metrics
+	next    int
+}
+
+func (r *metricRows) Next() bool {
+	r.next++
+	return r.next <= len(r.metrics)
+}
+
+func (r *metricRows) Scan(dest ...interface{}) error {
+	m := r.metrics[r.next-1]
+	*dest[0].(*string), *dest[1].(*string) = m.ID, m.MType
+	*dest[2].(**int64), *dest[3].(**float64) = m.Delta, m.Value
+	return nil
+}
+
+func (r *metricRows) Close()     {}
+func (r *metricRows) Err() error { return nil }
+
+func TestImport_MergeLocksCounters(t *testing.T) {
+	// Stored counters are read inside the transaction, with their rows locked
+	pool := &importPool{tx: &importTx{stored: []utils.Metrics{utils.NewMetrics("PollCount", int64(4), true)}}}
+	n, err := Import(context.Background(), &DBStorage{Pool: pool}, []utils.Metrics{
+		utils.NewMetrics("PollCount", int64(10), true),
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, pool.tx.queries, 1)
+	assert.Contains(t, pool.tx.queries[0], "FOR UPDATE")
+	require.Len(t, pool.tx.args, 1)
+	assert.Equal(t, int64(6), pool.tx.args[0][2])
+}
+
+func TestImport_ReplaceRolledBack(t *testing.T) {
+	ctx := context.Background()
+	pool := &importPool{tx: &importTx{commitErr: errors.New("connection reset")}}
+	st := NewCardinalityStorage(NewHistoryStorage(&DBStorage{Pool: pool}, time.Hour), 2, 0)
+	st.SetMetric(ctx, "Kept", 1.0, false)
+	dump := []utils.Metrics{utils.NewMetrics("Alloc", 2.5, false)}
+
+	// The decorators forget the removed metrics only once the import commits
+	_, err := Import(ctx, st, dump, true)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(pool.tx.execs[0], "DELETE"))
+	assert.Equal(t, 1, st.Len())
+	h, _ := History(st)
+	_, _, found := h.Samples("Kept", time.Hour)
+	assert.True(t, found)
+
+	pool.tx.commitErr = nil
+	n, err := Import(ctx, st, dump, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, st.Len())
+	_, _, found = h.Samples("Kept", time.Hour)
+	assert.False(t, found)
+	_, _, found = h.Samples("Alloc", time.Hour)
+	assert.True(t, found)
+}
+
+func TestClear(t *testing.T) {
+	assert.ErrorIs(t, Clear(context.Background(), struct{ Storage }{}), ErrClearUnsupported)
+
+	mockPool := new(MockPool)
+	mockPool.On("Exec", mock.Anything, `DELETE FROM public.metrics;`, mock.Anything).Return(pgconn.CommandTag{}, nil)
+	require.NoError(t, Clear(context.Background(), NewHistoryStorage(&DBStorage{Pool: mockPool}, time.Hour)))
+	mockPool.AssertExpectations(t)
+}
@@ -0,0 +1,193 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains CacheStorage — a read-through cache of single metrics
+// in front of a slow Storage such as DBStorage.
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// cacheRequests counts the lookups of CacheStorage.GetMetric, by result.
+var cacheRequests = telemetry.Default.Counter("storage_cache_requests_total",
+	"Metric lookups served by the storage cache, by result: hit or miss.", "result")
+
+// cacheEntry is a cached metric.
+type cacheEntry struct {
+	key     string
+	metric  utils.Metrics
+	expires time.Time
+}
+
+// CacheStorage wraps a Storage and caches the results of GetMetric for a
+// TTL, keeping at most size metrics and evicting the least recently used.
+//
+// Gauges are written through to the cache by SetMetric. Counters are
+// dropped from it instead, as their sum is only known to the wrapped
+// Storage, and so is every metric set inside a transaction, which may be
+// rolled back. Updates made by other servers sharing the database are
+// seen once the TTL expires.
+//
+// Missing metrics are not cached, as DBStorage reports a failed query as
+// a missing metric. GetAllMetrics is not cached either.
+//
+// A lookup that reads the wrapped Storage while any metric is written
+// returns what it read but does not cache it, as the value may predate the
+// write. A metric set inside a transaction is dropped again once the
+// transaction ends, as a lookup meanwhile reads the value before it.
+type CacheStorage struct {
+	Storage
+	now     func() time.Time
+	entries map[string]*list.Element
+	lru     *list.List // от недавно прочитанных к давно прочитанным
+	epoch   uint64     // число записей и очисток
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+}
+
+// NewCacheStorage creates a CacheStorage over st keeping lookups for ttl
+// and at most size metrics; a non-positive size means 1.
+func NewCacheStorage(st Storage, ttl time.Duration, size int) *CacheStorage {
+	c := &CacheStorage{
+		Storage: st,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		ttl:     ttl,
+		size:    max(size, 1),
+	}
+	telemetry.Default.GaugeFunc("storage_cache_entries", "Metrics held by the storage cache.", func() float64 {
+		return float64(c.Len())
+	})
+	return c
+}
+
+// Unwrap returns the decorated Storage.
+func (c *CacheStorage) Unwrap() Storage {
+	return c.Storage
+}
+
+// Len returns the number of cached metrics, including expired ones not evicted yet.
+func (c *CacheStorage) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// GetMetric returns the cached metric, or reads it from the wrapped Storage
+// and caches it if it is not cached or has expired. The metric read is not
+// cached if a metric was written or the cache was cleared during the read.
+func (c *CacheStorage) GetMetric(key string) (utils.Metrics, bool) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			cacheRequests.Inc("hit")
+			return e.metric, true
+		}
+	}
+	epoch := c.epoch
+	c.mu.Unlock()
+	cacheRequests.Inc("miss")
+
+	m, found := c.Storage.GetMetric(key)
+	if found {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.epoch == epoch {
+			c.put(key, m)
+		}
+	}
+	return m, found
+}
+
+// SetMetric stores the metric in the wrapped Storage, see WriteMetric.
+func (c *CacheStorage) SetMetric(ctx context.Context, key string, value interface{}, counter bool) {
+	_ = c.WriteMetric(ctx, key, value, counter)
+}
+
+// WriteMetric stores the metric in the wrapped Storage, then caches a gauge
+// or drops a counter from the cache. A metric whose write failed is dropped
+// as well, and one written inside a transaction is dropped both now and
+// once the transaction commits or rolls back. Returns the error of the write.
+func (c *CacheStorage) WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error {
+	err := WriteMetric(ctx, c.Storage, key, value, counter)
+
+	if _, inTx := ctx.Value(utils.Transaction).(pgx.Tx); inTx {
+		drop := func() { c.drop(key) }
+		OnCommit(ctx, drop)
+		OnRollback(ctx, drop)
+		drop()
+		return err
+	}
+	if err != nil || counter {
+		c.drop(key)
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.put(key, utils.NewMetrics(key, value, false))
+	return nil
+}
+
+// drop removes key from the cache and keeps lookups in flight from
+// caching what they read.
+func (c *CacheStorage) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.remove(key)
+}
+
+// Clear removes the metrics of the wrapped Storage and empties the cache;
+// inside a transaction the cache is emptied once it commits.
+func (c *CacheStorage) Clear(ctx context.Context) error {
+	if err := Clear(ctx, c.Storage); err != nil {
+		return err
+	}
+	empty := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		c.epoch++
+	}
+	if !OnCommit(ctx, empty) {
+		empty()
+	}
+	return nil
+}
+
+// put caches m under key and evicts the least recently used metrics
+// over the size. It is called with c.mu held.
+func (c *CacheStorage) put(key string, m utils.Metrics) {
+	e := &cacheEntry{key: key, metric: m, expires: c.now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops key from the cache. It is called with c.mu held.
+func (c *CacheStorage) remove(key string) {
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the lookups that reach the wrapped Storage.
+type countingStorage struct {
+	Storage
+	gets int
+}
+
+func (s *countingStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *countingStorage) GetMetric(key string) (utils.Metrics, bool) {
+	s.gets++
+	return s.Storage.GetMetric(key)
+}
+
+func TestCacheStorage(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingStorage{Storage: NewMemStorage(&sync.Map{})}
+	inner.Storage.SetMetric(ctx, "Alloc", 1.5, false)
+	inner.Storage.SetMetric(ctx, "PollCount", int64(2), true)
+
+	now := time.Unix(0, 0)
+	c := NewCacheStorage(inner, time.Minute, 2)
+	c.now = func() time.Time { return now }
+	assert.Same(t, inner, c.Unwrap())
+	hits := cacheRequests.Value("hit")
+
+	m, ok := c.GetMetric("Alloc")
+	require.True(t, ok)
+	assert.Equal(t, 1.5, *m.Value)
+	m, _ = c.GetMetric("Alloc")
+	assert.Equal(t, 1.5, *m.Value)
+	assert.Equal(t, 1, inner.gets)
+	assert.Equal(t, hits+1, cacheRequests.Value("hit"))
+
+	// Missing metrics are not cached
+	_, ok = c.GetMetric("Missing")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	// Gauges are written through, counters are read back
+	c.SetMetric(ctx, "Alloc", 3.0, false)
+	m, _ = c.GetMetric("Alloc")
+	assert.Equal(t, 3.0, *m.Value)
+	m, _ = c.GetMetric("PollCount")
+	assert.Equal(t, int64(2), *m.Delta)
+	c.SetMetric(ctx, "PollCount", int64(3), true)
+	m, _ = c.GetMetric("PollCount")
+	assert.Equal(t, int64(5), *m.Delta)
+	assert.Equal(t, 4, inner.gets)
+
+	// Expired metrics are read again
+	now = now.Add(time.Minute)
+	c.GetMetric("Alloc")
+	assert.Equal(t, 5, inner.gets)
+
+	require.NoError(t, c.Clear(ctx))
+	assert.Zero(t, c.Len())
+	assert.Empty(t, c.GetAllMetrics())
+}
+
+func TestCacheStorage_Evict(t *testing.T) {
+	ctx := context.Background()
+	c := NewCacheStorage(NewMemStorage(&sync.Map{}), time.Minute, 3)
+	for i := range 5 {
+		c.SetMetric(ctx, "g"+strconv.Itoa(i), float64(i), false)
+	}
+	assert.Equal(t, 3, c.Len())
+	c.mu.Lock()
+	_, oldest := c.entries["g1"]
+	_, newest := c.entries["g4"]
+	c.mu.Unlock()
+	assert.False(t, oldest)
+	assert.True(t, newest)
+}
+
+func TestCacheStorage_Transaction(t *testing.T) {
+	// Metrics set inside a transaction are dropped, as it may be rolled back
+	ctx := context.WithValue(context.Background(), utils.Transaction, struct{ pgx.Tx }{})
+	c := NewCacheStorage(NewMemStorage(&sync.Map{}), time.Minute, 10)
+	c.SetMetric(context.Background(), "Alloc", 1.0, false)
+	c.SetMetric(ctx, "Alloc", 2.0, false)
+	assert.Zero(t, c.Len())
+
+	// A metric read before the transaction ends is dropped once it commits or rolls back
+	for name, run := range map[string]func(*commitHooks){
+		"commit":   func(h *commitHooks) { h.finish(h.fns) },
+		"rollback": func(h *commitHooks) { h.finish(h.rollback) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			hooks := &commitHooks{}
+			c.SetMetric(context.WithValue(ctx, utils.CommitHooks, hooks), "Alloc", 3.0, false)
+			_, ok := c.GetMetric("Alloc")
+			require.True(t, ok)
+			assert.Equal(t, 1, c.Len())
+			run(hooks)
+			assert.Zero(t, c.Len())
+		})
+	}
+}
+
+// blockingStorage holds GetMetric until read is closed.
+type blockingStorage struct {
+	Storage
+	started chan struct{}
+	read    chan struct{}
+}
+
+func (s *blockingStorage) GetMetric(key string) (utils.Metrics, bool) {
+	m, ok := s.Storage.GetMetric(key)
+	close(s.started)
+	<-s.read
+	return m, ok
+}
+
+func TestCacheStorage_WriteDuringRead(t *testing.T) {
+	// A metric read before a write finished is not cached over it
+	ctx := context.Background()
+	inner := &blockingStorage{Storage: NewMemStorage(&sync.Map{}), started: make(chan struct{}), read: make(chan struct{})}
+	inner.Storage.SetMetric(ctx, "Alloc", 1.0, false)
+	c := NewCacheStorage(inner, time.Minute, 10)
+
+	done := make(chan utils.Metrics)
+	go func() {
+		m, _ := c.GetMetric("Alloc")
+		done <- m
+	}()
+	<-inner.started
+	require.NoError(t, c.WriteMetric(ctx, "Alloc", 2.0, false))
+	close(inner.read)
+	assert.Equal(t, 1.0, *(<-done).Value)
+
+	m, ok := c.GetMetric("Alloc")
+	require.True(t, ok)
+	assert.Equal(t, 2.0, *m.Value)
+}
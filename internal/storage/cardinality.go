@@ -0,0 +1,352 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains CardinalityStorage — a decorator that bounds the
+// number of distinct series, globally and per client.
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Errors returned by CardinalityStorage.Admit.
+var (
+	ErrSeriesLimit       = errors.New("series limit of the server reached")
+	ErrClientSeriesLimit = errors.New("series limit of the client reached")
+)
+
+// warnInterval is the least time between two warnings about the same limit.
+const warnInterval = time.Minute
+
+// seriesRejected counts the new series rejected by CardinalityStorage, by limit.
+var seriesRejected = telemetry.Default.Counter("series_rejected_total",
+	"New series rejected because a series limit was reached, by limit.", "limit")
+
+// CardinalityStorage wraps a Storage and bounds the number of distinct
+// metric names it holds: maxSeries in total and maxPerClient created by
+// one client. A zero limit is unlimited.
+//
+// Writers create series through Admit, which rejects the new names over
+// a limit. SetMetric never rejects: names stored without Admit, such as
+// the metrics of the server itself, count against the global limit only.
+// Series that exist when the storage is wrapped have no owner.
+type CardinalityStorage struct {
+	Storage
+	series       map[string]string // имя ряда → клиент, создавший его
+	perClient    map[string]int    // число рядов, созданных клиентом
+	now          func() time.Time
+	warned       map[string]time.Time // время последнего предупреждения по лимиту
+	maxSeries    int
+	maxPerClient int
+	mu           sync.Mutex
+}
+
+// NewCardinalityStorage creates a CardinalityStorage over st, which
+// already holds the series returned by st.GetAllMetrics.
+func NewCardinalityStorage(st Storage, maxSeries, maxPerClient int) *CardinalityStorage {
+	s := &CardinalityStorage{
+		Storage:      st,
+		series:       make(map[string]string),
+		perClient:    make(map[string]int),
+		now:          time.Now,
+		warned:       make(map[string]time.Time),
+		maxSeries:    maxSeries,
+		maxPerClient: maxPerClient,
+	}
+	for name := range st.GetAllMetrics() {
+		s.series[name] = ""
+	}
+	telemetry.Default.GaugeFunc("series", "Distinct series in the storage.", func() float64 {
+		return float64(s.Len())
+	})
+	return s
+}
+
+// Unwrap returns the decorated Storage.
+func (s *CardinalityStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// Len returns the number of distinct series.
+func (s *CardinalityStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.series)
+}
+
+// Admit reserves for client the names that are not stored yet. It
+// returns one error per name: nil if the name exists or was reserved,
+// ErrSeriesLimit or ErrClientSeriesLimit if it would exceed a limit.
+//
+// If atomic is set, either every name is admitted or none is reserved.
+// Rejections are logged as a warning at most once per warnInterval and limit.
+func (s *CardinalityStorage) Admit(client string, names []string, atomic bool) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(names))
+	reserved := make(map[string]bool)
+	rejected := make(map[string]error)
+	total, owned := len(s.series), s.perClient[client]
+	for i, name := range names {
+		if _, ok := s.series[name]; ok || reserved[name] {
+			continue
+		}
+		if err, ok := rejected[name]; ok {
+			errs[i] = err
+			continue
+		}
+		switch {
+		case s.maxSeries > 0 && total >= s.maxSeries:
+			errs[i] = ErrSeriesLimit
+		case s.maxPerClient > 0 && client != "" && owned >= s.maxPerClient:
+			errs[i] = ErrClientSeriesLimit
+		default:
+			reserved[name] = true
+			total++
+			owned++
+			continue
+		}
+		rejected[name] = errs[i]
+		s.reject(client, errs[i])
+	}
+	if len(rejected) > 0 && atomic {
+		return errs
+	}
+	for name := range reserved {
+		s.series[name] = client
+	}
+	if client != "" {
+		s.perClient[client] = owned
+	}
+	return errs
+}
+
+// Release gives up the series reserved by client with Admit among names
+// that the wrapped Storage does not hold, as when the write they were
+// admitted for failed. Series of other clients are kept.
+func (s *CardinalityStorage) Release(client string, names []string) {
+	s.mu.Lock()
+	var owned []string
+	for _, name := range names {
+		if owner, ok := s.series[name]; ok && owner == client {
+			owned = append(owned, name)
+		}
+	}
+	s.mu.Unlock()
+
+	var missing []string
+	for _, name := range owned {
+		if _, stored := s.Storage.GetMetric(name); !stored {
+			missing = append(missing, name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range missing {
+		if owner, ok := s.series[name]; !ok || owner != client {
+			continue
+		}
+		delete(s.series, name)
+		if client != "" {
+			s.perClient[client]--
+		}
+	}
+}
+
+// reject counts a rejected series and reports it, with the client, unless
+// the limit was reported less than warnInterval ago; seriesRejected counts
+// the rejections in between. It is called with s.mu held.
+func (s *CardinalityStorage) reject(client string, err error) {
+	limit, maxSeries := "client", s.maxPerClient
+	if errors.Is(err, ErrSeriesLimit) {
+		limit, maxSeries = "global", s.maxSeries
+	}
+	seriesRejected.Inc(limit)
+	if now := s.now(); now.Sub(s.warned[limit]) >= warnInterval {
+		s.warned[limit] = now
+		logger.Log.Warn("CardinalityStorage", zap.String("limit", limit), zap.Int("max_series", maxSeries),
+			zap.String("client", client), zap.String("status", "new series are rejected"))
+	}
+}
+
+// SetMetric stores the metric in the wrapped Storage, see WriteMetric.
+func (s *CardinalityStorage) SetMetric(ctx context.Context, key string, value interface{}, counter bool) {
+	_ = s.WriteMetric(ctx, key, value, counter)
+}
+
+// WriteMetric stores the metric in the wrapped Storage and records its
+// series if it is new, unless the write fails; inside a transaction the
+// series is recorded once it commits. Returns the error of the write.
+func (s *CardinalityStorage) WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error {
+	if err := WriteMetric(ctx, s.Storage, key, value, counter); err != nil {
+		return err
+	}
+	record := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.series[key]; !ok {
+			s.series[key] = ""
+		}
+	}
+	if !OnCommit(ctx, record) {
+		record()
+	}
+	return nil
+}
+
+// Clear removes the metrics of the wrapped Storage and forgets every
+// series and its owner; inside a transaction they are forgotten once it
+// commits.
+func (s *CardinalityStorage) Clear(ctx context.Context) error {
+	if err := Clear(ctx, s.Storage); err != nil {
+		return err
+	}
+	forget := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.series = make(map[string]string)
+		s.perClient = make(map[string]int)
+	}
+	if !OnCommit(ctx, forget) {
+		forget()
+	}
+	return nil
+}
+
+// Fits reports whether names alone, as stored by client after a Clear,
+// are within the limits: nil, ErrSeriesLimit or ErrClientSeriesLimit.
+// Nothing is reserved.
+func (s *CardinalityStorage) Fits(client string, names []string) error {
+	unique := make(map[string]bool, len(names))
+	for _, name := range names {
+		unique[name] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	switch {
+	case s.maxSeries > 0 && len(unique) > s.maxSeries:
+		err = ErrSeriesLimit
+	case s.maxPerClient > 0 && client != "" && len(unique) > s.maxPerClient:
+		err = ErrClientSeriesLimit
+	default:
+		return nil
+	}
+	s.reject(client, err)
+	return err
+}
+
+// Cardinality returns the CardinalityStorage found in the decorator chain of st.
+//
+// Returns false if the series of st are not limited.
+func Cardinality(st Storage) (*CardinalityStorage, bool) {
+	for {
+		if c, ok := st.(*CardinalityStorage); ok {
+			return c, true
+		}
+		w, ok := st.(interface{ Unwrap() Storage })
+		if !ok {
+			return nil, false
+		}
+		st = w.Unwrap()
+	}
+}
+
+// AdmitSeries admits names for the client carried by ctx (see WithClient)
+// with the CardinalityStorage in the decorator chain of st; see
+// CardinalityStorage.Admit. Returns nil if the series of st are not limited.
+func AdmitSeries(ctx context.Context, st Storage, names []string, atomic bool) []error {
+	c, ok := Cardinality(st)
+	if !ok {
+		return nil
+	}
+	return c.Admit(ClientFrom(ctx), names, atomic)
+}
+
+// FitSeries checks names against the limits of the CardinalityStorage in
+// the decorator chain of st, if any, as if they replaced every stored
+// series; see CardinalityStorage.Fits.
+func FitSeries(ctx context.Context, st Storage, names []string) error {
+	c, ok := Cardinality(st)
+	if !ok {
+		return nil
+	}
+	return c.Fits(ClientFrom(ctx), names)
+}
+
+// ReleaseSeries releases names for the client carried by ctx with the
+// CardinalityStorage in the decorator chain of st, if any; see
+// CardinalityStorage.Release.
+func ReleaseSeries(ctx context.Context, st Storage, names []string) {
+	if c, ok := Cardinality(st); ok {
+		c.Release(ClientFrom(ctx), names)
+	}
+}
+
+// WithClient returns a copy of ctx carrying the key of the client whose
+// request is served.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, utils.ClientKey, client)
+}
+
+// ClientFrom returns the client key carried by ctx, or "".
+func ClientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(utils.ClientKey).(string)
+	return client
+}
+
+// PrefixCount is the number of series sharing a name prefix.
+type PrefixCount struct {
+	Prefix string `json:"prefix"` // префикс имени
+	Series int    `json:"series"` // число рядов с этим префиксом
+}
+
+// TopPrefixes groups names by their first depth segments, separated by
+// any of "._:-", and returns the n largest groups, largest first.
+// A non-positive n returns all groups.
+func TopPrefixes(names []string, depth, n int) []PrefixCount {
+	counts := make(map[string]int)
+	for _, name := range names {
+		counts[namePrefix(name, depth)]++
+	}
+	top := make([]PrefixCount, 0, len(counts))
+	for prefix, c := range counts {
+		top = append(top, PrefixCount{Prefix: prefix, Series: c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Series != top[j].Series {
+			return top[i].Series > top[j].Series
+		}
+		return top[i].Prefix < top[j].Prefix
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// namePrefix returns name up to its depth-th separator.
+func namePrefix(name string, depth int) string {
+	depth = max(depth, 1)
+	from := 0
+	for range depth {
+		i := strings.IndexAny(name[from:], "._:-")
+		if i < 0 {
+			return name
+		}
+		from += i + 1
+	}
+	return name[:from-1]
+}
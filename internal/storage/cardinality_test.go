@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCardinalityStorage(t *testing.T) {
+	require.NoError(t, logger.Initialize("fatal"))
+	mem := NewMemStorage(&sync.Map{})
+	mem.SetMetric(context.Background(), "Existing", int64(1), true)
+
+	st := NewCardinalityStorage(mem, 4, 2)
+	assert.Same(t, mem, Unwrap(st))
+	c, ok := Cardinality(NewHistoryStorage(st, 0))
+	assert.True(t, ok && c == st)
+	_, ok = Cardinality(mem)
+	assert.False(t, ok)
+	assert.Equal(t, 1, st.Len())
+
+	// Known names are always admitted, duplicates share the outcome of their first occurrence
+	errs := st.Admit("a", []string{"Existing", "A1", "A1", "A2", "A3", "A3"}, false)
+	assert.Equal(t, []error{nil, nil, nil, nil, ErrClientSeriesLimit, ErrClientSeriesLimit}, errs)
+	assert.Equal(t, 3, st.Len())
+
+	// An atomic batch over a limit reserves nothing
+	errs = st.Admit("b", []string{"B1", "B2"}, true)
+	assert.Equal(t, []error{nil, ErrSeriesLimit}, errs)
+	assert.Equal(t, 3, st.Len())
+
+	assert.Equal(t, []error{nil}, st.Admit("b", []string{"B1"}, true))
+	assert.Equal(t, []error{ErrSeriesLimit}, st.Admit("", []string{"C1"}, true))
+
+	// SetMetric never rejects and records names stored without Admit
+	st.SetMetric(context.Background(), "_server.up", 1.0, false)
+	assert.Equal(t, 5, st.Len())
+}
+
+func TestAdmitSeries(t *testing.T) {
+	mem := NewMemStorage(&sync.Map{})
+	assert.Nil(t, AdmitSeries(context.Background(), mem, []string{"A"}, true))
+
+	st := NewCardinalityStorage(mem, 0, 1)
+	ctx := WithClient(context.Background(), "ip:10.0.0.1")
+	assert.Equal(t, "ip:10.0.0.1", ClientFrom(ctx))
+	assert.Equal(t, []error{nil, ErrClientSeriesLimit}, AdmitSeries(ctx, st, []string{"A", "B"}, false))
+	// Clients that cannot be identified are bound by the global limit only
+	assert.Equal(t, []error{nil}, AdmitSeries(context.Background(), st, []string{"B"}, false))
+}
+
+func TestCardinalityStorage_Release(t *testing.T) {
+	mem := NewMemStorage(&sync.Map{})
+	st := NewCardinalityStorage(mem, 0, 2)
+
+	require.Equal(t, []error{nil, nil}, st.Admit("a", []string{"A1", "A2"}, true))
+	require.Equal(t, []error{nil}, st.Admit("b", []string{"B1"}, true))
+	st.SetMetric(context.Background(), "A1", 1.0, false)
+
+	// Only unstored series of the client are released
+	st.Release("a", []string{"A1", "A2", "B1"})
+	assert.Equal(t, 2, st.Len())
+	assert.Equal(t, []error{nil, ErrClientSeriesLimit}, st.Admit("a", []string{"A3", "A4"}, false))
+
+	ReleaseSeries(WithClient(context.Background(), "b"), st, []string{"B1"})
+	assert.Equal(t, 2, st.Len())
+}
+
+func TestTopPrefixes(t *testing.T) {
+	names := []string{"http.requests.total", "http.requests.errors", "http.latency", "db:queries", "db-pool", "Alloc"}
+
+	assert.Equal(t, []PrefixCount{
+		{Prefix: "http", Series: 3},
+		{Prefix: "db", Series: 2},
+		{Prefix: "Alloc", Series: 1},
+	}, TopPrefixes(names, 1, 0))
+	assert.Equal(t, []PrefixCount{
+		{Prefix: "http.requests", Series: 2},
+		{Prefix: "Alloc", Series: 1},
+	}, TopPrefixes(names, 2, 2))
+	assert.Empty(t, TopPrefixes(nil, 1, 10))
+}
+
+func TestCardinalityStorage_WarnInterval(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	old := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() { logger.Log = old })
+
+	now := time.Unix(0, 0)
+	st := NewCardinalityStorage(NewMemStorage(&sync.Map{}), 1, 0)
+	st.now = func() time.Time { return now }
+	require.Equal(t, []error{nil}, st.Admit("a", []string{"A"}, true))
+
+	// Rejections of any number of clients keep one warning time per limit
+	for i := range 100 {
+		st.Admit("client-"+strconv.Itoa(i), []string{"B"}, true)
+	}
+	assert.Len(t, st.warned, 1)
+	assert.Equal(t, 1, logs.Len())
+
+	now = now.Add(warnInterval)
+	st.Admit("b", []string{"B"}, true)
+	assert.Equal(t, 2, logs.Len())
+}
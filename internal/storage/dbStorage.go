@@ -9,6 +9,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/jackc/pgerrcode"
@@ -16,6 +19,7 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 	"go.uber.org/zap"
 )
@@ -48,6 +52,27 @@ func NewDBPool(ctx context.Context, dsn string) *pgxpool.Pool {
 	return pool
 }
 
+// RegisterPoolStats registers the connection pool statistics returned by
+// stat, such as pgxpool.Pool.Stat, in r.
+func RegisterPoolStats(r *telemetry.Registry, stat func() *pgxpool.Stat) {
+	r.GaugeFunc("db_pool_acquired_conns", "Connections currently in use.",
+		func() float64 { return float64(stat().AcquiredConns()) })
+	r.GaugeFunc("db_pool_idle_conns", "Idle connections in the pool.",
+		func() float64 { return float64(stat().IdleConns()) })
+	r.GaugeFunc("db_pool_total_conns", "Connections in the pool, including those being established.",
+		func() float64 { return float64(stat().TotalConns()) })
+	r.GaugeFunc("db_pool_max_conns", "Maximum size of the pool.",
+		func() float64 { return float64(stat().MaxConns()) })
+	r.CounterFunc("db_pool_acquires_total", "Connections acquired from the pool.",
+		func() float64 { return float64(stat().AcquireCount()) })
+	r.CounterFunc("db_pool_empty_acquires_total", "Acquires that waited for a connection because the pool was empty.",
+		func() float64 { return float64(stat().EmptyAcquireCount()) })
+	r.CounterFunc("db_pool_canceled_acquires_total", "Acquires canceled by their context.",
+		func() float64 { return float64(stat().CanceledAcquireCount()) })
+	r.CounterFunc("db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections, in seconds.",
+		func() float64 { return stat().AcquireDuration().Seconds() })
+}
+
 // NewDBStorage initializes a DBStorage instance and ensures the metrics table exists.
 //
 // Creates the metrics table if not exists using idempotent query.
@@ -68,7 +93,7 @@ func NewDBStorage(ctx context.Context, p *pgxpool.Pool) *DBStorage {
 		return "", retriableHelper(err)
 	}
 
-	_, err := backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
+	_, err := retry("create_table", operation)
 	if err != nil {
 		logger.Log.Error("NewDBStorage", zap.String("error while creating table in DB", err.Error()))
 	}
@@ -92,7 +117,7 @@ func (st *DBStorage) GetMetric(key string) (utils.Metrics, bool) {
 		return m, retriableHelper(err)
 	}
 
-	metric, err := backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
+	metric, err := retry("get", operation)
 	if err != nil {
 		logger.Log.Error("GetMetric", zap.String("error while select from DB", err.Error()))
 		return metric, false
@@ -114,7 +139,7 @@ func (st *DBStorage) GetAllMetrics() map[string]utils.Metrics {
 		return rows, retriableHelper(err)
 	}
 
-	rows, err := backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
+	rows, err := retry("get_all", operation)
 	if err != nil {
 		logger.Log.Error("GetAllMetric", zap.String("error while select from DB", err.Error()))
 	}
@@ -130,10 +155,92 @@ func (st *DBStorage) GetAllMetrics() map[string]utils.Metrics {
 	return metrics
 }
 
-// SetMetric stores or updates a metric in the database.
+// ListMetrics retrieves the metrics that pass f from the database.
 //
-// Supports both gauge and counter types and can operate inside a transaction.
+// The name pattern and the type are evaluated by PostgreSQL.
+func (st *DBStorage) ListMetrics(ctx context.Context, f Filter) (map[string]utils.Metrics, error) {
+	query := `
+		SELECT "ID", "MType", "Delta", "Value" FROM public.metrics
+		WHERE "ID" LIKE $1 ESCAPE '\' AND ($2::text = '' OR "MType" = $2::text);
+	`
+
+	query, args := traced(ctx, query, f.likePattern(), f.MType)
+	operation := func() (map[string]utils.Metrics, error) {
+		rows, err := st.Pool.Query(ctx, query, args...)
+		if err != nil {
+			return nil, retriableHelper(err)
+		}
+		defer rows.Close()
+
+		metrics := make(map[string]utils.Metrics)
+		for rows.Next() {
+			var m utils.Metrics
+			if err := rows.Scan(&m.ID, &m.MType, &m.Delta, &m.Value); err != nil {
+				return nil, backoff.Permanent(err)
+			}
+			metrics[m.ID] = m
+		}
+		return metrics, retriableHelper(rows.Err())
+	}
+
+	metrics, err := retry("list", operation)
+	if err != nil {
+		logger.Log.Error("ListMetrics", zap.String("error while select from DB", err.Error()))
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// LockMetrics retrieves every metric inside the transaction of ctx and
+// locks their rows until it ends, so that concurrent writes wait for it.
+//
+// Returns error if no transaction is found in context or the query fails.
+func (st *DBStorage) LockMetrics(ctx context.Context) (map[string]utils.Metrics, error) {
+	tx, ok := ctx.Value(utils.Transaction).(pgx.Tx)
+	if !ok {
+		return nil, fmt.Errorf("no transaction found in context")
+	}
+	query, args := traced(ctx, `SELECT "ID", "MType", "Delta", "Value" FROM public.metrics FOR UPDATE;`)
+	operation := func() (map[string]utils.Metrics, error) {
+		rows, err := tx.Query(ctx, query, args...)
+		if err != nil {
+			return nil, retriableHelper(err)
+		}
+		defer rows.Close()
+
+		metrics := make(map[string]utils.Metrics)
+		for rows.Next() {
+			var m utils.Metrics
+			if err := rows.Scan(&m.ID, &m.MType, &m.Delta, &m.Value); err != nil {
+				return nil, backoff.Permanent(err)
+			}
+			metrics[m.ID] = m
+		}
+		return metrics, retriableHelper(rows.Err())
+	}
+
+	metrics, err := retry("lock", operation)
+	if err != nil {
+		logger.Log.Error("LockMetrics", zap.String("error while select from DB", err.Error()))
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// SetMetric stores or updates a metric in the database, see WriteMetric.
+// A failed write is logged.
 func (st *DBStorage) SetMetric(ctx context.Context, key string, value interface{}, counter bool) {
+	if err := st.WriteMetric(ctx, key, value, counter); err != nil {
+		logger.Log.Error("SetMetric", zap.String("error while insert in DB", err.Error()))
+	}
+}
+
+// WriteMetric stores or updates a metric in the database and returns the
+// error of the write.
+//
+// Supports both gauge and counter types and can operate inside a transaction.
+// The query is tagged with the trace of ctx, if any (see traced).
+func (st *DBStorage) WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error {
 	var query string
 	var metricType string
 	if counter {
@@ -158,22 +265,42 @@ func (st *DBStorage) SetMetric(ctx context.Context, key string, value interface{
 		metricType = "gauge"
 	}
 
+	query, args := traced(ctx, query, key, metricType, value)
+	operation := func() (string, error) {
+		tx, ok := ctx.Value(utils.Transaction).(pgx.Tx)
+		if ok {
+			_, err := tx.Exec(ctx, query, args...)
+			return "", retriableHelper(err)
+		}
+
+		_, err := st.Pool.Exec(ctx, query, args...)
+		return "", retriableHelper(err)
+	}
+
+	_, err := retry("set", operation)
+	return err
+}
+
+// Clear removes every metric from the database, inside the transaction
+// of ctx if any.
+func (st *DBStorage) Clear(ctx context.Context) error {
+	query, args := traced(ctx, `DELETE FROM public.metrics;`)
 	operation := func() (string, error) {
 		tx, ok := ctx.Value(utils.Transaction).(pgx.Tx)
 		if ok {
-			_, err := tx.Exec(ctx, query, key, metricType, value)
+			_, err := tx.Exec(ctx, query, args...)
 			return "", retriableHelper(err)
 		}
 
-		_, err := st.Pool.Exec(ctx, query, key, metricType, value)
+		_, err := st.Pool.Exec(ctx, query, args...)
 		return "", retriableHelper(err)
 	}
 
-	_, err := backoff.RetryWithData(operation, utils.NewOneThreeFiveBackOff())
+	_, err := retry("clear", operation)
 	if err != nil {
-		logger.Log.Error("SetMetric", zap.String("error while insert in DB", err.Error()))
-		return
+		logger.Log.Error("Clear", zap.String("error while delete from DB", err.Error()))
 	}
+	return err
 }
 
 // BeginTransaction starts a new database transaction and stores it in the context.
@@ -185,9 +312,55 @@ func (st *DBStorage) BeginTransaction(ctx context.Context) (context.Context, err
 		return nil, err
 	}
 	ctx = context.WithValue(ctx, utils.Transaction, tx)
+	ctx = context.WithValue(ctx, utils.CommitHooks, &commitHooks{})
 	return ctx, nil
 }
 
+// commitHooks are the functions registered with OnCommit and OnRollback
+// for a transaction.
+type commitHooks struct {
+	fns      []func() // выполняются после фиксации
+	rollback []func() // выполняются после отката
+	done     bool     // транзакция завершена и функции выполнены
+}
+
+// finish runs fns once, when the transaction has ended.
+func (h *commitHooks) finish(fns []func()) {
+	if h.done {
+		return
+	}
+	h.done = true
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// OnCommit registers fn to run once the transaction of ctx, begun by
+// BeginTransaction, has been committed. It is not run on rollback.
+//
+// Returns false if ctx carries no such transaction; fn is not registered then.
+func OnCommit(ctx context.Context, fn func()) bool {
+	hooks, ok := ctx.Value(utils.CommitHooks).(*commitHooks)
+	if !ok {
+		return false
+	}
+	hooks.fns = append(hooks.fns, fn)
+	return true
+}
+
+// OnRollback registers fn to run once the transaction of ctx, begun by
+// BeginTransaction, has been rolled back, including by a failed commit.
+//
+// Returns false if ctx carries no such transaction; fn is not registered then.
+func OnRollback(ctx context.Context, fn func()) bool {
+	hooks, ok := ctx.Value(utils.CommitHooks).(*commitHooks)
+	if !ok {
+		return false
+	}
+	hooks.rollback = append(hooks.rollback, fn)
+	return true
+}
+
 // CommitTransaction commits a previously started transaction.
 //
 // Returns error if no transaction is found in context or commit fails.
@@ -196,9 +369,16 @@ func (st *DBStorage) CommitTransaction(ctx context.Context) error {
 	if !ok {
 		return fmt.Errorf("no transaction found in context")
 	}
+	hooks, _ := ctx.Value(utils.CommitHooks).(*commitHooks)
 	if err := tx.Commit(ctx); err != nil {
+		if hooks != nil {
+			hooks.finish(hooks.rollback)
+		}
 		return err
 	}
+	if hooks != nil {
+		hooks.finish(hooks.fns)
+	}
 	return nil
 }
 
@@ -210,12 +390,44 @@ func (st *DBStorage) RollbackTransaction(ctx context.Context) error {
 	if !ok {
 		return fmt.Errorf("no transaction found in context")
 	}
-	if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+	err := tx.Rollback(ctx)
+	if hooks, ok := ctx.Value(utils.CommitHooks).(*commitHooks); ok {
+		hooks.finish(hooks.rollback)
+	}
+	if err != nil && err != pgx.ErrTxClosed {
 		return err
 	}
 	return nil
 }
 
+// traced tags query with the request ID and traceparent carried by ctx as
+// an sqlcommenter-style comment, so that pg_stat_activity and the server
+// log of slow queries can be matched with the request that caused them.
+//
+// Traced queries run in pgx.QueryExecModeExec: every comment makes a new
+// statement text, which must not fill the prepared statement cache.
+func traced(ctx context.Context, query string, args ...any) (string, []any) {
+	t, ok := utils.TraceFrom(ctx)
+	if !ok {
+		return query, args
+	}
+	comment := "/*request_id='" + url.QueryEscape(t.RequestID) + "',traceparent='" + url.QueryEscape(t.Traceparent()) + "'*/"
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";") + " " + comment
+	return query, append([]any{pgx.QueryExecModeExec}, args...)
+}
+
+// retry runs operation with utils.NewOneThreeFiveBackOff and records its
+// latency and the number of retries under op in telemetry.Default.
+func retry[T any](op string, operation backoff.OperationWithData[T]) (T, error) {
+	start := time.Now()
+	defer func() {
+		telemetry.StorageDuration.Observe(telemetry.Since(start), op)
+	}()
+	return backoff.RetryNotifyWithData(operation, utils.NewOneThreeFiveBackOff(), func(error, time.Duration) {
+		telemetry.StorageRetries.Inc(op)
+	})
+}
+
 // retriableHelper determines whether an error should trigger a retry.
 //
 // Returns permanent error if error is unrecoverable.
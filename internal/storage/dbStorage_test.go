@@ -3,15 +3,18 @@ package storage
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stepanov-ds/ya-metrics/internal/logger"
+	"github.com/stepanov-ds/ya-metrics/internal/telemetry"
 	"github.com/stepanov-ds/ya-metrics/internal/utils"
 )
 
@@ -106,6 +109,13 @@ func (m *MockRows) Close() {
 	m.Called()
 }
 
+func (m *MockRows) Err() error                                   { return nil }
+func (m *MockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (m *MockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (m *MockRows) Values() ([]any, error)                       { return nil, nil }
+func (m *MockRows) RawValues() [][]byte                          { return nil }
+func (m *MockRows) Conn() *pgx.Conn                              { return nil }
+
 func TestDBStorage_GetMetric_Success(t *testing.T) {
 	mockPool := new(MockPool)
 	dbStorage := &DBStorage{
@@ -131,11 +141,30 @@ func TestDBStorage_GetMetric_Success(t *testing.T) {
 		"SELECT \"ID\", \"MType\", \"Delta\", \"Value\" FROM public.metrics WHERE \"ID\" = $1;",
 		[]interface{}{key}).Return(mockRow)
 
+	observed := telemetry.StorageDuration.Count("get")
 	metric, found := dbStorage.GetMetric(key)
 	require.True(t, found)
 	assert.Equal(t, key, metric.ID)
 	assert.Equal(t, "gauge", metric.MType)
 	assert.InDelta(t, 3.14, *metric.Value, 0.001)
+	assert.Equal(t, observed+1, telemetry.StorageDuration.Count("get"))
+}
+
+func TestRegisterPoolStats(t *testing.T) {
+	// The pool connects lazily, so no server is needed
+	p, err := pgxpool.New(context.Background(), "postgres://localhost:1/metrics?pool_max_conns=7")
+	require.NoError(t, err)
+	defer p.Close()
+	r := telemetry.NewRegistry()
+	RegisterPoolStats(r, p.Stat)
+
+	var b strings.Builder
+	require.NoError(t, r.WriteText(&b))
+	assert.Contains(t, b.String(), "\ndb_pool_max_conns 7\n")
+	for _, name := range []string{"db_pool_acquired_conns", "db_pool_idle_conns", "db_pool_total_conns",
+		"db_pool_acquires_total", "db_pool_acquire_duration_seconds_total"} {
+		assert.Contains(t, b.String(), "\n"+name+" 0\n")
+	}
 }
 
 func TestDBStorage_GetMetric_NotFound(t *testing.T) {
@@ -202,3 +231,59 @@ func TestDBStorage_SetMetric_Counter(t *testing.T) {
 
 	mockPool.AssertExpectations(t)
 }
+
+func TestDBStorage_SetMetric_Traced(t *testing.T) {
+	mockPool := new(MockPool)
+	dbStorage := &DBStorage{
+		Pool: mockPool,
+	}
+
+	trace, ok := utils.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	trace.RequestID = "batch-42"
+	ctx := utils.WithTrace(context.Background(), trace)
+
+	mockPool.On("Exec",
+		ctx,
+		mock.MatchedBy(func(sql string) bool {
+			return strings.HasSuffix(sql, "/*request_id='batch-42',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/")
+		}),
+		mock.MatchedBy(func(args []interface{}) bool {
+			return len(args) == 4 && args[0] == pgx.QueryExecModeExec && args[1] == "test_gauge"
+		}),
+	).Return(pgconn.CommandTag{}, nil)
+
+	dbStorage.SetMetric(ctx, "test_gauge", 3.14, false)
+
+	mockPool.AssertExpectations(t)
+}
+
+func TestDBStorage_ListMetrics(t *testing.T) {
+	mockPool := new(MockPool)
+	dbStorage := &DBStorage{
+		Pool: mockPool,
+	}
+	met := utils.NewMetrics("CPU_utilization1", 42.5, false)
+
+	mockRows := new(MockRows)
+	mockRows.On("Next").Return(true).Once()
+	mockRows.On("Next").Return(false).Once()
+	mockRows.On("Scan", mock.MatchedBy(func(dest []interface{}) bool {
+		*(dest[0].(*string)) = met.ID
+		*(dest[1].(*string)) = met.MType
+		*(dest[2].(**int64)) = met.Delta
+		*(dest[3].(**float64)) = met.Value
+		return true
+	})).Return(nil)
+	mockRows.On("Close").Return()
+
+	mockPool.On("Query", context.Background(), mock.AnythingOfType("string"),
+		[]interface{}{`CPU\_utilization%`, "gauge"}).Return(mockRows, nil)
+
+	metrics, err := dbStorage.ListMetrics(context.Background(), Filter{Pattern: "CPU_utilization*", MType: "gauge"})
+	require.NoError(t, err)
+	require.Contains(t, metrics, met.ID)
+	assert.InDelta(t, 42.5, *metrics[met.ID].Value, 0.001)
+	mockPool.AssertExpectations(t)
+	mockRows.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains Filter and List — filtered listing of metrics that is
+// pushed down to the backend when it supports it.
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// Filter selects metrics by name and type.
+type Filter struct {
+	// Pattern is a glob on the metric name where "*" matches any run of
+	// characters. An empty Pattern matches every name.
+	Pattern string
+	// MType restricts the result to one metric type. Empty matches both.
+	MType string
+}
+
+// Match reports whether m passes the filter.
+func (f Filter) Match(m utils.Metrics) bool {
+	if f.MType != "" && f.MType != m.MType {
+		return false
+	}
+	if f.Pattern == "" {
+		return true
+	}
+	ok, err := path.Match(f.Pattern, m.ID)
+	return err == nil && ok
+}
+
+// likePattern converts Pattern into an SQL LIKE pattern with "\" as the escape character.
+func (f Filter) likePattern() string {
+	if f.Pattern == "" {
+		return "%"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return r.Replace(f.Pattern)
+}
+
+// FilterLister is implemented by storages that can filter metrics at the source.
+type FilterLister interface {
+	// ListMetrics returns the metrics that pass f as a map of name to value.
+	ListMetrics(ctx context.Context, f Filter) (map[string]utils.Metrics, error)
+}
+
+// List returns the metrics of st that pass f.
+//
+// If the underlying storage implements FilterLister the filter is pushed down
+// to it, otherwise GetAllMetrics is filtered in memory.
+func List(ctx context.Context, st Storage, f Filter) (map[string]utils.Metrics, error) {
+	if l, ok := Unwrap(st).(FilterLister); ok {
+		return l.ListMetrics(ctx, f)
+	}
+	result := make(map[string]utils.Metrics)
+	for name, m := range st.GetAllMetrics() {
+		if f.Match(m) {
+			result[name] = m
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	gauge := utils.NewMetrics("CPUutilization1", 1.0, false)
+	counter := utils.NewMetrics("PollCount", 1, true)
+
+	assert.True(t, Filter{}.Match(gauge))
+	assert.True(t, Filter{Pattern: "CPU*"}.Match(gauge))
+	assert.False(t, Filter{Pattern: "CPU*"}.Match(counter))
+	assert.True(t, Filter{MType: "counter"}.Match(counter))
+	assert.False(t, Filter{Pattern: "CPU*", MType: "counter"}.Match(gauge))
+}
+
+func TestFilter_likePattern(t *testing.T) {
+	assert.Equal(t, "%", Filter{}.likePattern())
+	assert.Equal(t, `CPU\_util%`, Filter{Pattern: "CPU_util*"}.likePattern())
+}
+
+func TestList_InMemory(t *testing.T) {
+	st := NewHistoryStorage(NewMemStorage(&sync.Map{}), 0)
+	ctx := context.Background()
+	st.SetMetric(ctx, "CPUutilization1", 10.0, false)
+	st.SetMetric(ctx, "CPUutilization2", 20.0, false)
+	st.SetMetric(ctx, "PollCount", 1, true)
+
+	metrics, err := List(ctx, st, Filter{Pattern: "CPUutilization*"})
+	require.NoError(t, err)
+	assert.Len(t, metrics, 2)
+	assert.NotContains(t, metrics, "PollCount")
+}
@@ -0,0 +1,259 @@
+// Package storage provides interfaces and implementations for storing and retrieving metrics.
+//
+// This file contains HistoryStorage — a decorator that keeps timestamped
+// samples of every metric so that rates and deltas can be derived on read.
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+)
+
+// MaxSamplesPerMetric bounds the number of samples kept for a single metric.
+const MaxSamplesPerMetric = 4096
+
+// Sample is the value of a metric observed at a point in time.
+//
+// For counters V is the cumulative value after the update.
+type Sample struct {
+	T time.Time
+	V float64
+}
+
+// series holds the samples of one metric in chronological order.
+type series struct {
+	mType   string
+	samples []Sample
+}
+
+// HistoryStorage wraps a Storage and records a Sample on every SetMetric
+// that succeeds. Inside a transaction the sample is recorded once the
+// transaction commits.
+//
+// Samples older than the retention period are discarded, except the newest
+// of them, which is kept as a baseline for windows as long as the retention.
+type HistoryStorage struct {
+	Storage
+	now       func() time.Time
+	series    map[string]*series
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+// NewHistoryStorage creates a HistoryStorage that keeps samples of st
+// for the given retention period.
+func NewHistoryStorage(st Storage, retention time.Duration) *HistoryStorage {
+	return &HistoryStorage{
+		Storage:   st,
+		retention: retention,
+		series:    make(map[string]*series),
+		now:       time.Now,
+	}
+}
+
+// Unwrap returns the decorated Storage.
+func (h *HistoryStorage) Unwrap() Storage {
+	return h.Storage
+}
+
+// Retention returns how long samples are kept.
+func (h *HistoryStorage) Retention() time.Duration {
+	return h.retention
+}
+
+// Clear removes the metrics of the wrapped Storage and drops every series;
+// inside a transaction the series are dropped once it commits.
+func (h *HistoryStorage) Clear(ctx context.Context) error {
+	if err := Clear(ctx, h.Storage); err != nil {
+		return err
+	}
+	drop := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.series = make(map[string]*series)
+	}
+	if !OnCommit(ctx, drop) {
+		drop()
+	}
+	return nil
+}
+
+// SetMetric stores the metric in the wrapped Storage, see WriteMetric.
+func (h *HistoryStorage) SetMetric(ctx context.Context, key string, value interface{}, counter bool) {
+	_ = h.WriteMetric(ctx, key, value, counter)
+}
+
+// WriteMetric stores the metric in the wrapped Storage and records a
+// sample, unless the write fails. In a transaction begun by DBStorage the
+// sample is recorded after the commit, see OnCommit; the cumulative value
+// of a counter is then read back from the wrapped Storage, as the
+// transaction may have updated the counter more than once. Returns the
+// error of the write.
+func (h *HistoryStorage) WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error {
+	if err := WriteMetric(ctx, h.Storage, key, value, counter); err != nil {
+		return err
+	}
+	if !OnCommit(ctx, func() { h.record(key, value, counter, counter) }) {
+		h.record(key, value, counter, false)
+	}
+	return nil
+}
+
+// record appends a sample of the stored metric to its series.
+//
+// The cumulative value of a counter is derived from the previous sample;
+// only the first update of a series, or any with reread set, reads it back
+// from the wrapped Storage, without holding h.mu. A change of metric type
+// starts a new series.
+func (h *HistoryStorage) record(key string, value interface{}, counter, reread bool) {
+	mType := "gauge"
+	if counter {
+		mType = "counter"
+	}
+
+	var v float64
+	h.mu.Lock()
+	s, found := h.series[key]
+	derive := counter && !reread && found && s.mType == mType && len(s.samples) > 0
+	h.mu.Unlock()
+	if counter && !derive {
+		stored, ok := h.Storage.GetMetric(key)
+		if !ok || stored.Delta == nil {
+			return
+		}
+		v = float64(*stored.Delta)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, found = h.series[key]
+	if derive && (!found || s.mType != mType || len(s.samples) == 0) {
+		// The series was dropped meanwhile and there is no value to add to
+		return
+	}
+	if !found || s.mType != mType {
+		s = &series{mType: mType}
+		h.series[key] = s
+	}
+
+	if derive {
+		var m utils.Metrics
+		m.Set(value, true)
+		v = s.samples[len(s.samples)-1].V + float64(*m.Delta)
+	} else if !counter {
+		var m utils.Metrics
+		m.Set(value, false)
+		v = *m.Value
+	}
+
+	now := h.now()
+	s.samples = append(s.samples, Sample{T: now, V: v})
+	h.prune(s, now)
+}
+
+// prune drops samples beyond the retention period and the per-metric limit.
+func (h *HistoryStorage) prune(s *series, now time.Time) {
+	cutoff := now.Add(-h.retention)
+	drop := 0
+	for drop+1 < len(s.samples) && !s.samples[drop+1].T.After(cutoff) {
+		drop++
+	}
+	if over := len(s.samples) - drop - MaxSamplesPerMetric; over > 0 {
+		drop += over
+	}
+	if drop > 0 {
+		s.samples = append(s.samples[:0], s.samples[drop:]...)
+	}
+}
+
+// Samples returns the samples of the metric key that fall into the window
+// ending now, preceded by the newest earlier sample as a baseline.
+//
+// Returns the metric type and the samples, or false if the metric has no history.
+func (h *HistoryStorage) Samples(key string, window time.Duration) (string, []Sample, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, found := h.series[key]
+	if !found || len(s.samples) == 0 {
+		return "", nil, false
+	}
+
+	since := h.now().Add(-window)
+	start := len(s.samples) - 1
+	for start > 0 && s.samples[start].T.After(since) {
+		start--
+	}
+	result := make([]Sample, len(s.samples)-start)
+	copy(result, s.samples[start:])
+	return s.mType, result, true
+}
+
+// Increase returns how much a counter grew over the samples.
+//
+// A decrease between two samples is treated as a counter reset: the counter
+// is assumed to have restarted from zero, so the later value is added in full.
+func Increase(samples []Sample) float64 {
+	var total float64
+	for i := 1; i < len(samples); i++ {
+		d := samples[i].V - samples[i-1].V
+		if d < 0 {
+			d = samples[i].V
+		}
+		total += d
+	}
+	return total
+}
+
+// Rate returns the per-second increase of a counter over the samples.
+//
+// Returns false if there are fewer than two samples or they share a timestamp.
+func Rate(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	elapsed := samples[len(samples)-1].T.Sub(samples[0].T).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return Increase(samples) / elapsed, true
+}
+
+// Delta returns the difference between the last and the first gauge sample.
+func Delta(samples []Sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	return samples[len(samples)-1].V - samples[0].V
+}
+
+// Unwrap strips every decorator from st and returns the underlying Storage.
+func Unwrap(st Storage) Storage {
+	for {
+		w, ok := st.(interface{ Unwrap() Storage })
+		if !ok {
+			return st
+		}
+		st = w.Unwrap()
+	}
+}
+
+// History returns the HistoryStorage found in the decorator chain of st.
+//
+// Returns false if st keeps no history.
+func History(st Storage) (*HistoryStorage, bool) {
+	for {
+		if h, ok := st.(*HistoryStorage); ok {
+			return h, true
+		}
+		w, ok := st.(interface{ Unwrap() Storage })
+		if !ok {
+			return nil, false
+		}
+		st = w.Unwrap()
+	}
+}
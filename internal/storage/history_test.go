@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stepanov-ds/ya-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestHistory(retention time.Duration) (*HistoryStorage, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	h := NewHistoryStorage(NewMemStorage(&sync.Map{}), retention)
+	h.now = clock.now
+	return h, clock
+}
+
+func TestHistoryStorage_CounterSamples(t *testing.T) {
+	h, clock := newTestHistory(time.Hour)
+	ctx := context.Background()
+
+	h.SetMetric(ctx, "PollCount", 5, true)
+	clock.t = clock.t.Add(10 * time.Second)
+	h.SetMetric(ctx, "PollCount", 3, true)
+	clock.t = clock.t.Add(10 * time.Second)
+	h.SetMetric(ctx, "PollCount", int64(2), true)
+
+	mType, samples, found := h.Samples("PollCount", time.Minute)
+	require.True(t, found)
+	assert.Equal(t, "counter", mType)
+	assert.Equal(t, []float64{5, 8, 10}, values(samples))
+
+	stored, ok := h.GetMetric("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), *stored.Delta)
+
+	rate, ok := Rate(samples)
+	require.True(t, ok)
+	assert.InDelta(t, 0.25, rate, 1e-9)
+}
+
+func TestHistoryStorage_WindowBaselineAndRetention(t *testing.T) {
+	h, clock := newTestHistory(time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		h.SetMetric(ctx, "g", float64(i), false)
+		clock.t = clock.t.Add(15 * time.Second)
+	}
+
+	_, samples, found := h.Samples("g", 20*time.Second)
+	require.True(t, found)
+	assert.Equal(t, []float64{8, 9}, values(samples), "window must start with the newest sample before it")
+
+	_, samples, _ = h.Samples("g", time.Hour)
+	assert.Equal(t, []float64{5, 6, 7, 8, 9}, values(samples), "samples beyond retention must be dropped")
+}
+
+func TestHistoryStorage_TypeChangeStartsNewSeries(t *testing.T) {
+	h, _ := newTestHistory(time.Hour)
+	ctx := context.Background()
+
+	h.SetMetric(ctx, "m", 1.5, false)
+	h.SetMetric(ctx, "m", 2, true)
+
+	mType, samples, found := h.Samples("m", time.Minute)
+	require.True(t, found)
+	assert.Equal(t, "counter", mType)
+	assert.Len(t, samples, 1)
+}
+
+func TestIncrease_CounterReset(t *testing.T) {
+	base := time.Now()
+	samples := []Sample{
+		{T: base, V: 10},
+		{T: base.Add(time.Second), V: 15},
+		{T: base.Add(2 * time.Second), V: 3},
+		{T: base.Add(3 * time.Second), V: 7},
+	}
+	assert.Equal(t, 12.0, Increase(samples))
+	assert.Equal(t, -3.0, Delta(samples))
+
+	_, ok := Rate(samples[:1])
+	assert.False(t, ok)
+}
+
+func TestUnwrapAndHistory(t *testing.T) {
+	mem := NewMemStorage(&sync.Map{})
+	h := NewHistoryStorage(mem, time.Minute)
+
+	assert.Same(t, mem, Unwrap(h))
+	assert.Same(t, mem, Unwrap(mem))
+
+	found, ok := History(h)
+	assert.True(t, ok)
+	assert.Same(t, h, found)
+
+	_, ok = History(mem)
+	assert.False(t, ok)
+}
+
+func values(samples []Sample) []float64 {
+	result := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		result = append(result, s.V)
+	}
+	return result
+}
+
+// failingWriter is a Storage whose writes always fail.
+type failingWriter struct {
+	Storage
+}
+
+func (failingWriter) WriteMetric(context.Context, string, interface{}, bool) error {
+	return errors.New("connection refused")
+}
+
+func TestHistoryStorage_FailedWriteRecordsNothing(t *testing.T) {
+	h := NewHistoryStorage(failingWriter{NewMemStorage(&sync.Map{})}, time.Hour)
+
+	h.SetMetric(context.Background(), "g", 1.5, false)
+
+	_, _, found := h.Samples("g", time.Minute)
+	assert.False(t, found)
+}
+
+func TestHistoryStorage_TransactionRecordsOnCommit(t *testing.T) {
+	h, _ := newTestHistory(time.Hour)
+	hooks := &commitHooks{}
+	ctx := context.WithValue(context.Background(), utils.CommitHooks, hooks)
+
+	h.SetMetric(ctx, "PollCount", 5, true)
+	h.SetMetric(ctx, "PollCount", 3, true)
+	h.SetMetric(ctx, "g", 1.5, false)
+	_, _, found := h.Samples("PollCount", time.Minute)
+	assert.False(t, found, "no sample before the commit")
+
+	for _, fn := range hooks.fns {
+		fn()
+	}
+	_, samples, found := h.Samples("PollCount", time.Minute)
+	require.True(t, found)
+	assert.Equal(t, float64(8), samples[len(samples)-1].V)
+	_, samples, found = h.Samples("g", time.Minute)
+	require.True(t, found)
+	assert.Equal(t, []float64{1.5}, values(samples))
+}
+
+// readHookStorage calls onGet before every lookup.
+type readHookStorage struct {
+	Storage
+	onGet func()
+}
+
+func (s *readHookStorage) GetMetric(key string) (utils.Metrics, bool) {
+	s.onGet()
+	return s.Storage.GetMetric(key)
+}
+
+func TestHistoryStorage_ReadsWithoutLock(t *testing.T) {
+	// The wrapped Storage may be slow, so it is read without holding the lock
+	inner := &readHookStorage{Storage: NewMemStorage(&sync.Map{})}
+	h := NewHistoryStorage(inner, time.Hour)
+	reads := 0
+	inner.onGet = func() {
+		reads++
+		h.Samples("PollCount", time.Minute)
+	}
+
+	h.SetMetric(context.Background(), "PollCount", 5, true)
+	h.SetMetric(context.Background(), "PollCount", 3, true)
+
+	_, samples, found := h.Samples("PollCount", time.Minute)
+	require.True(t, found)
+	assert.Equal(t, []float64{5, 8}, values(samples))
+	assert.Equal(t, 1, reads)
+}
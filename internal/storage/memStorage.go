@@ -69,3 +69,9 @@ func (s *MemStorage) GetAllMetrics() map[string]utils.Metrics {
 	})
 	return result
 }
+
+// Clear removes every metric from memory.
+func (s *MemStorage) Clear(ctx context.Context) error {
+	s.storage.Clear()
+	return nil
+}
@@ -22,3 +22,19 @@ type Storage interface {
 	// SetMetric stores or updates a metric with given type (counter/gauge).
 	SetMetric(ctx context.Context, key string, value interface{}, counter bool)
 }
+
+// MetricWriter is implemented by storages whose writes can fail, such as
+// DBStorage. WriteMetric behaves as SetMetric but returns the error.
+type MetricWriter interface {
+	WriteMetric(ctx context.Context, key string, value interface{}, counter bool) error
+}
+
+// WriteMetric stores the metric in st and returns the error of the write
+// if st is a MetricWriter; other storages do not fail.
+func WriteMetric(ctx context.Context, st Storage, key string, value interface{}, counter bool) error {
+	if w, ok := st.(MetricWriter); ok {
+		return w.WriteMetric(ctx, key, value, counter)
+	}
+	st.SetMetric(ctx, key, value, counter)
+	return nil
+}
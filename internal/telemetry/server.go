@@ -0,0 +1,84 @@
+// Package telemetry implements the metrics the server records about itself.
+//
+// This file declares the instruments of the server and exports them into
+// the metric storage.
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ReservedPrefix starts the names of the metrics the server writes into
+// its own storage. Clients may not update metrics with this prefix.
+const ReservedPrefix = "_server."
+
+// Default is the registry of the server instruments.
+var Default = NewRegistry()
+
+// Server instruments.
+var (
+	// HTTPRequests counts served requests by route pattern, method and status.
+	HTTPRequests = Default.Counter("http_requests_total",
+		"Requests served, by route, method and status.", "route", "method", "status")
+	// HTTPDuration observes request latencies by route pattern, method and status.
+	HTTPDuration = Default.Histogram("http_request_duration_seconds",
+		"Request latency in seconds, by route, method and status.", nil, "route", "method", "status")
+	// RequestFailures counts requests rejected with an API error code, such
+	// as invalid_body, decrypt_failed or hash_mismatch.
+	RequestFailures = Default.Counter("http_request_failures_total",
+		"Requests rejected with an API error, by route and error code.", "route", "code")
+	// StorageDuration observes storage operation latencies by operation.
+	StorageDuration = Default.Histogram("storage_operation_duration_seconds",
+		"Storage operation latency in seconds, by operation.", nil, "op")
+	// StorageRetries counts retried database operations by operation.
+	StorageRetries = Default.Counter("storage_retries_total",
+		"Database operations retried after a transient error, by operation.", "op")
+	// SnapshotDuration observes the time it takes to save the storage file.
+	SnapshotDuration = Default.Histogram("snapshot_duration_seconds",
+		"Time in seconds to save the metrics to the storage file.", nil)
+	// SnapshotFailures counts failed saves of the storage file.
+	SnapshotFailures = Default.Counter("snapshot_failures_total",
+		"Failed saves of the metrics to the storage file.")
+)
+
+// Since returns the seconds elapsed since start, for Observe.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// StorageName returns the name under which s is written into the metric
+// storage: ReservedPrefix, the metric name and the label values, joined
+// by dots. Characters not allowed in metric names are replaced with "_".
+func StorageName(s Sample) string {
+	parts := append([]string{s.Name}, s.Labels...)
+	for i, p := range parts {
+		parts[i] = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == ':':
+				return r
+			}
+			return '_'
+		}, p)
+	}
+	return ReservedPrefix + strings.Join(parts, ".")
+}
+
+// Export writes the samples of r every interval with set, named by
+// StorageName, until ctx is done. Every sample is written as a gauge
+// holding its current value.
+func Export(ctx context.Context, r *Registry, interval time.Duration, set func(ctx context.Context, name string, value float64)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, s := range r.Samples() {
+				set(ctx, StorageName(s), s.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Package telemetry implements the metrics the server records about itself.
+//
+// A Registry holds labelled counters, histograms and gauges read from a
+// callback, and renders them in the Prometheus text exposition format.
+// The instruments of the server are registered in Default.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the upper bounds, in seconds, of latency histograms.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is one value of a registered metric, as exported to storage.
+type Sample struct {
+	Name   string   // имя метрики, с суффиксом _count или _sum для гистограмм
+	Labels []string // значения меток в порядке их объявления
+	Value  float64  // текущее значение
+}
+
+// collector is a registered metric.
+type collector interface {
+	// writeText appends the HELP, TYPE and sample lines of the metric to b.
+	writeText(b *strings.Builder)
+	// samples calls fn for every sample of the metric.
+	samples(fn func(Sample))
+}
+
+// Registry is a set of metrics. It is safe for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds c under name, replacing a metric registered before.
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[name] = c
+}
+
+// sorted returns the registered metrics ordered by name.
+func (r *Registry) sorted() []collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cs := make([]collector, len(names))
+	for i, name := range names {
+		cs[i] = r.collectors[name]
+	}
+	return cs
+}
+
+// WriteText writes all metrics to w in the Prometheus text exposition
+// format 0.0.4, ordered by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, c := range r.sorted() {
+		c.writeText(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Samples returns the current value of every metric. Histograms are
+// reported by their _count and _sum.
+func (r *Registry) Samples() []Sample {
+	var out []Sample
+	for _, c := range r.sorted() {
+		c.samples(func(s Sample) { out = append(out, s) })
+	}
+	return out
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]*series)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram with the given bucket upper bounds and
+// label names. Nil buckets mean DefBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogram)}
+	r.register(name, h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose value is returned by fn at collection time.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help}, typ: "gauge", fn: fn})
+}
+
+// CounterFunc registers a counter whose value is returned by fn at
+// collection time. fn must never return a smaller value than before.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help}, typ: "counter", fn: fn})
+}
+
+// desc describes a metric.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// header appends the HELP and TYPE lines of the metric to b.
+func (d desc) header(b *strings.Builder, typ string) {
+	if d.help != "" {
+		b.WriteString("# HELP " + d.name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help) + "\n")
+	}
+	b.WriteString("# TYPE " + d.name + " " + typ + "\n")
+}
+
+// line appends a sample line of the metric to b. extra is a preformatted
+// label pair appended after the metric labels, such as le="0.5".
+func (d desc) line(b *strings.Builder, suffix string, values []string, extra string, v float64) {
+	b.WriteString(d.name + suffix)
+	if len(values) > 0 || extra != "" {
+		b.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(d.labels[i] + `="` + escapeLabel(value) + `"`)
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extra)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// key joins label values into a map key.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("telemetry: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series is the value of a counter for one set of label values.
+type series struct {
+	labels []string
+	value  float64
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*series
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter with the given
+// label values.
+func (c *CounterVec) Add(v float64, labels ...string) {
+	k := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.values[k]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		c.values[k] = s
+	}
+	s.value += v
+}
+
+// Value returns the counter with the given label values.
+func (c *CounterVec) Value(labels ...string) float64 {
+	k := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.values[k]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// snapshot returns the series ordered by label values.
+func (c *CounterVec) snapshot() []series {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]series, 0, len(c.values))
+	for _, s := range c.values {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return lessLabels(out[i].labels, out[j].labels) })
+	return out
+}
+
+func (c *CounterVec) writeText(b *strings.Builder) {
+	c.header(b, "counter")
+	for _, s := range c.snapshot() {
+		c.line(b, "", s.labels, "", s.value)
+	}
+}
+
+func (c *CounterVec) samples(fn func(Sample)) {
+	for _, s := range c.snapshot() {
+		fn(Sample{Name: c.name, Labels: s.labels, Value: s.value})
+	}
+}
+
+// histogram is the state of a histogram for one set of label values.
+type histogram struct {
+	labels []string
+	counts []uint64 // число наблюдений в каждом интервале, последний — +Inf
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labels ...string) {
+	k := h.key(labels)
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[k]
+	if !ok {
+		s = &histogram{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets)+1)}
+		h.values[k] = s
+	}
+	s.counts[i]++
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations with the given label values.
+func (h *HistogramVec) Count(labels ...string) uint64 {
+	k := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.values[k]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// snapshot returns copies of the histograms ordered by label values.
+func (h *HistogramVec) snapshot() []histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]histogram, 0, len(h.values))
+	for _, s := range h.values {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return lessLabels(out[i].labels, out[j].labels) })
+	return out
+}
+
+func (h *HistogramVec) writeText(b *strings.Builder) {
+	h.header(b, "histogram")
+	for _, s := range h.snapshot() {
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			h.line(b, "_bucket", s.labels, `le="`+le+`"`, float64(cumulative))
+		}
+		h.line(b, "_sum", s.labels, "", s.sum)
+		h.line(b, "_count", s.labels, "", float64(s.count))
+	}
+}
+
+func (h *HistogramVec) samples(fn func(Sample)) {
+	for _, s := range h.snapshot() {
+		fn(Sample{Name: h.name + "_count", Labels: s.labels, Value: float64(s.count)})
+		fn(Sample{Name: h.name + "_sum", Labels: s.labels, Value: s.sum})
+	}
+}
+
+// funcMetric is an unlabelled metric read from a callback.
+type funcMetric struct {
+	desc
+	typ string
+	fn  func() float64
+}
+
+func (f *funcMetric) writeText(b *strings.Builder) {
+	f.header(b, f.typ)
+	f.line(b, "", nil, "", f.fn())
+}
+
+func (f *funcMetric) samples(fn func(Sample)) {
+	fn(Sample{Name: f.name, Value: f.fn()})
+}
+
+// lessLabels orders label value lists lexicographically.
+func lessLabels(a, b []string) bool {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats v the way Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,93 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	latency := r.Histogram("latency_seconds", "", []float64{0.1, 1}, "route")
+	r.GaugeFunc("conns", "Open connections.", func() float64 { return 3 })
+
+	requests.Inc("/update", "200")
+	requests.Add(2, "/update", "200")
+	requests.Inc(`/a"b`, "400")
+	latency.Observe(0.05, "/update")
+	latency.Observe(0.5, "/update")
+	latency.Observe(5, "/update")
+
+	var b strings.Builder
+	assert.NoError(t, r.WriteText(&b))
+	assert.Equal(t, `# HELP conns Open connections.
+# TYPE conns gauge
+conns 3
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/update",le="0.1"} 1
+latency_seconds_bucket{route="/update",le="1"} 2
+latency_seconds_bucket{route="/update",le="+Inf"} 3
+latency_seconds_sum{route="/update"} 5.55
+latency_seconds_count{route="/update"} 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b",status="400"} 1
+requests_total{route="/update",status="200"} 3
+`, b.String())
+
+	assert.Equal(t, float64(3), requests.Value("/update", "200"))
+	assert.Equal(t, uint64(3), latency.Count("/update"))
+	assert.Panics(t, func() { requests.Inc("/update") })
+}
+
+func TestRegistry_Samples(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ops_total", "", "op").Inc("get")
+	r.Histogram("op_seconds", "", nil).Observe(0.25)
+	r.CounterFunc("acquires_total", "", func() float64 { return 7 })
+
+	assert.Equal(t, []Sample{
+		{Name: "acquires_total", Value: 7},
+		{Name: "op_seconds_count", Value: 1},
+		{Name: "op_seconds_sum", Value: 0.25},
+		{Name: "ops_total", Labels: []string{"get"}, Value: 1},
+	}, r.Samples())
+}
+
+func TestStorageName(t *testing.T) {
+	assert.Equal(t, "_server.snapshot_duration_seconds_count",
+		StorageName(Sample{Name: "snapshot_duration_seconds_count"}))
+	assert.Equal(t, "_server.http_requests_total._update_:metric_type.POST.200",
+		StorageName(Sample{Name: "http_requests_total", Labels: []string{"/update/:metric_type", "POST", "200"}}))
+}
+
+func TestExport(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ops_total", "", "op").Inc("get")
+
+	var mu sync.Mutex
+	got := make(map[string]float64)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Export(ctx, r, time.Millisecond, func(_ context.Context, name string, value float64) {
+			mu.Lock()
+			defer mu.Unlock()
+			got[name] = value
+		})
+	}()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return got["_server.ops_total.get"] == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
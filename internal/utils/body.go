@@ -0,0 +1,56 @@
+// Package utils contains utility functions and shared types used across the application.
+//
+// This file provides Body, the in-memory request body passed between
+// middlewares and handlers.
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// Body is a request body held in memory.
+//
+// Middlewares that need the whole body replace http.Request.Body with a
+// Body, so that later stages and handlers get the bytes through ReadBody
+// without reading and copying them again.
+type Body struct {
+	*bytes.Reader
+	data []byte
+}
+
+// NewBody returns a Body reading data.
+func NewBody(data []byte) *Body {
+	return &Body{Reader: bytes.NewReader(data), data: data}
+}
+
+// Bytes returns the unread part of the body without copying it.
+func (b *Body) Bytes() []byte {
+	return b.data[len(b.data)-b.Len():]
+}
+
+// Close implements io.Closer; it does nothing.
+func (b *Body) Close() error {
+	return nil
+}
+
+// ReadBody returns the whole body of r without consuming it.
+//
+// If r.Body is already a *Body its bytes are returned without copying;
+// otherwise the body is read and r.Body is replaced with a Body over it,
+// so the next caller gets the same bytes for free.
+func ReadBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if b, ok := r.Body.(*Body); ok {
+		return b.Bytes(), nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = NewBody(data)
+	return data, nil
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+
+	body, err := ReadBody(req)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(body))
+	require.IsType(t, &Body{}, req.Body)
+
+	again, err := ReadBody(req)
+	require.NoError(t, err)
+	assert.Same(t, &body[0], &again[0], "a Body must be returned without copying")
+
+	head := make([]byte, 3)
+	_, err = io.ReadFull(req.Body, head)
+	require.NoError(t, err)
+	rest, err := ReadBody(req)
+	require.NoError(t, err)
+	assert.Equal(t, "load", string(rest), "ReadBody returns the unread part")
+
+	empty, err := ReadBody(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Nil(t, empty)
+}
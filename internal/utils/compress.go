@@ -0,0 +1,114 @@
+// Package utils contains utility functions and shared types used across the application.
+//
+// This file provides the request body encodings shared by the agent and the server.
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content-Encoding values supported for request bodies.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate" // zlib-поток по RFC 9110
+	EncodingZstd    = "zstd"
+	EncodingBrotli  = "br"
+)
+
+// SupportedEncoding reports whether encoding is one of the supported Content-Encoding values.
+func SupportedEncoding(encoding string) bool {
+	switch encoding {
+	case EncodingGzip, EncodingDeflate, EncodingZstd, EncodingBrotli:
+		return true
+	}
+	return false
+}
+
+// Compress encodes data with the given Content-Encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case EncodingZstd:
+		w, err = zstd.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipReaders keeps the inflate state of gzip readers, which is tens of
+// kilobytes, between requests.
+var gzipReaders sync.Pool
+
+// pooledGzipReader returns its gzip.Reader to gzipReaders on Close.
+type pooledGzipReader struct {
+	*gzip.Reader
+}
+
+// Close closes the reader and puts it back into the pool.
+func (r pooledGzipReader) Close() error {
+	err := r.Reader.Close()
+	gzipReaders.Put(r.Reader)
+	return err
+}
+
+// NewDecoder returns a reader that decodes r streamed with the given
+// Content-Encoding. The reader must be closed to release its resources.
+//
+// maxWindow bounds the memory zstd may allocate for its window, so a
+// crafted frame header cannot make the decoder reserve gigabytes up front.
+func NewDecoder(encoding string, r io.Reader, maxWindow uint64) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		if zr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+			if err := zr.Reset(r); err != nil {
+				gzipReaders.Put(zr)
+				return nil, err
+			}
+			return pooledGzipReader{zr}, nil
+		}
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return pooledGzipReader{zr}, nil
+	case EncodingDeflate:
+		return zlib.NewReader(r)
+	case EncodingZstd:
+		maxWindow = min(max(maxWindow, zstd.MinWindowSize), zstd.MaxWindowSize)
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case EncodingBrotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
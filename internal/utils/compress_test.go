@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress_RoundTrip(t *testing.T) {
+	data := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate, EncodingZstd, EncodingBrotli} {
+		t.Run(encoding, func(t *testing.T) {
+			assert.True(t, SupportedEncoding(encoding))
+			compressed, err := Compress(encoding, data)
+			require.NoError(t, err)
+
+			r, err := NewDecoder(encoding, bytes.NewReader(compressed), 1<<20)
+			require.NoError(t, err)
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func TestCompress_Unsupported(t *testing.T) {
+	assert.False(t, SupportedEncoding("compress"))
+	assert.False(t, SupportedEncoding(""))
+	_, err := Compress("compress", []byte("data"))
+	assert.Error(t, err)
+	_, err = NewDecoder("compress", bytes.NewReader(nil), 0)
+	assert.Error(t, err)
+}
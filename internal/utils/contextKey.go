@@ -10,4 +10,12 @@ type ContextKey string
 const (
 	// Transaction is a context key for storing an active database transaction.
 	Transaction ContextKey = "transaction"
+	// CommitHooks is a context key for storing the functions to run once the
+	// transaction of the context has been committed.
+	CommitHooks ContextKey = "commit_hooks"
+	// TraceKey is a context key for storing the Trace of the current request.
+	TraceKey ContextKey = "trace"
+	// ClientKey is a context key for storing the key that identifies the
+	// client of the current request for per-client limits.
+	ClientKey ContextKey = "client"
 )
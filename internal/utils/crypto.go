@@ -1,7 +1,140 @@
 package utils
 
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EncryptedContentType marks a request or response body that is an
+// EncryptedPayload. Clients send it as Content-Type to have a request
+// decrypted and in Accept to get the response encrypted.
+const EncryptedContentType = "application/vnd.ya-metrics.encrypted+json"
+
+// Versions of the EncryptedPayload envelope.
+const (
+	// PayloadV1 is the legacy envelope: AES key wrapped with RSA PKCS#1 v1.5,
+	// no algorithm or key ID. It carries no "v" field on the wire.
+	PayloadV1 = 1
+	// PayloadV2 carries Alg and KeyID and authenticates them as GCM additional data.
+	PayloadV2 = 2
+)
+
+// Key wrapping algorithms of a v2 EncryptedPayload.
+const (
+	// AlgRSAOAEP256 wraps the AES-256-GCM key with RSA-OAEP using SHA-256.
+	AlgRSAOAEP256 = "RSA-OAEP-256"
+	// AlgECDHES derives the AES-256-GCM key with ephemeral-static ECDH
+	// (P-256 or X25519) and HKDF-SHA256; see DeriveECDHKey.
+	AlgECDHES = "ECDH-ES-HKDF-256"
+	// AlgDirect seals a response with the AES key of the encrypted request
+	// it answers, which only the client and the server know.
+	AlgDirect = "dir"
+)
+
+// EncryptedPayload is the hybrid encryption envelope of a request body.
 type EncryptedPayload struct {
-	EncryptedAESKey string `json:"aes_key"` // base64
-	CipherText      string `json:"data"`    // base64
-	Nonce           string `json:"nonce"`   // base64
+	Version         int    `json:"v,omitempty"`   // версия конверта; 0 означает PayloadV1
+	Alg             string `json:"alg,omitempty"` // алгоритм обёртки ключа (v2)
+	KeyID           string `json:"kid,omitempty"` // идентификатор ключа получателя (v2)
+	EphemeralKey    string `json:"epk,omitempty"` // эфемерный открытый ключ ECDH, base64 (v2)
+	RequestNonce    string `json:"rn,omitempty"`  // уникальное значение запроса для защиты от повтора (v2)
+	Timestamp       int64  `json:"ts,omitempty"`  // Unix-время отправки в секундах (v2)
+	EncryptedAESKey string `json:"aes_key"`       // base64
+	CipherText      string `json:"data"`          // base64
+	Nonce           string `json:"nonce"`         // base64
+}
+
+// AAD returns the additional authenticated data of a v2 payload, binding
+// the version, algorithm, key ID and, if set, the replay protection values
+// to the ciphertext.
+func (p *EncryptedPayload) AAD() []byte {
+	if p.Version < PayloadV2 {
+		return nil
+	}
+	aad := "v" + strconv.Itoa(p.Version) + ";" + p.Alg + ";" + p.KeyID
+	if p.Timestamp != 0 || p.RequestNonce != "" {
+		aad += ";" + strconv.FormatInt(p.Timestamp, 10) + ";" + p.RequestNonce
+	}
+	return []byte(aad)
+}
+
+// KeyID returns the identifier of a public key: the first 8 bytes of the
+// SHA-256 of its PKIX encoding, hex-encoded.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// DeriveECDHKey derives the AES-256 key of an AlgECDHES payload from the
+// ECDH shared secret, salted with the ephemeral and the recipient public keys.
+func DeriveECDHKey(secret []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, secret, salt, AlgECDHES, 32)
+}
+
+// SealWithKey encrypts plain with AES-256-GCM under aesKey into an
+// AlgDirect payload. requestNonce binds the payload to the request it answers.
+func SealWithKey(plain, aesKey []byte, keyID, requestNonce string) (*EncryptedPayload, error) {
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	payload := &EncryptedPayload{
+		Version:      PayloadV2,
+		Alg:          AlgDirect,
+		KeyID:        keyID,
+		RequestNonce: requestNonce,
+		Timestamp:    time.Now().Unix(),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+	}
+	payload.CipherText = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plain, payload.AAD()))
+	return payload, nil
+}
+
+// OpenWithKey decrypts an AlgDirect payload with aesKey.
+func OpenWithKey(p *EncryptedPayload, aesKey []byte) ([]byte, error) {
+	if p.Version != PayloadV2 || p.Alg != AlgDirect {
+		return nil, fmt.Errorf("payload is not sealed with %q", AlgDirect)
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(p.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	cipherText, err := base64.StdEncoding.DecodeString(p.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("data: %w", err)
+	}
+	return gcm.Open(nil, nonce, cipherText, p.AAD())
+}
+
+// newGCM returns AES-GCM with the given key.
+func newGCM(aesKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
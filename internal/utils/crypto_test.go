@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"os"
 	"testing"
 )
@@ -66,3 +67,27 @@ func TestGetFlagValue(t *testing.T) {
 		})
 	}
 }
+
+func TestSealWithKey(t *testing.T) {
+	aesKey := bytes.Repeat([]byte{7}, 32)
+	payload, err := SealWithKey([]byte("response"), aesKey, "kid", "rn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Alg != AlgDirect || payload.KeyID != "kid" || payload.RequestNonce != "rn" || payload.Timestamp == 0 {
+		t.Fatalf("unexpected envelope %+v", payload)
+	}
+
+	plain, err := OpenWithKey(payload, aesKey)
+	if err != nil || string(plain) != "response" {
+		t.Fatalf("OpenWithKey = %q, %v", plain, err)
+	}
+
+	payload.RequestNonce = "other"
+	if _, err = OpenWithKey(payload, aesKey); err == nil {
+		t.Fatal("tampered envelope opened")
+	}
+	if _, err = OpenWithKey(&EncryptedPayload{Version: PayloadV2, Alg: AlgRSAOAEP256}, aesKey); err == nil {
+		t.Fatal("non-direct envelope opened")
+	}
+}
@@ -22,3 +22,21 @@ func CalculateHashWithKey(data []byte, key string) string {
 
 	return hashString
 }
+
+// Headers that carry the replay protection values of a signed request.
+const (
+	TimestampHeader = "Request-Timestamp" // Unix-время отправки в секундах
+	NonceHeader     = "Request-Nonce"     // уникальное значение запроса
+)
+
+// SignedMessage returns the bytes covered by the HashSHA256 signature of a
+// request with replay protection: the timestamp, the nonce and the body
+// joined by dots.
+func SignedMessage(timestamp, nonce string, body []byte) []byte {
+	msg := make([]byte, 0, len(timestamp)+len(nonce)+2+len(body))
+	msg = append(msg, timestamp...)
+	msg = append(msg, '.')
+	msg = append(msg, nonce...)
+	msg = append(msg, '.')
+	return append(msg, body...)
+}
@@ -16,6 +16,8 @@ type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	ID    string   `json:"id"`              // имя метрики
 	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	Unit  string   `json:"unit,omitempty"`  // единица измерения из объявления метрики
+	Help  string   `json:"help,omitempty"`  // описание из объявления метрики
 }
 
 // NewMetrics creates a new Metrics instance and sets its value based on type.
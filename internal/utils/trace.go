@@ -0,0 +1,117 @@
+// Package utils contains utility functions and shared types used across the application.
+//
+// This file implements request IDs and W3C trace-context propagation.
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Headers carrying the request trace.
+const (
+	// TraceparentHeader is the W3C trace-context header:
+	// "00-<32 hex trace ID>-<16 hex parent span ID>-<2 hex flags>".
+	TraceparentHeader = "traceparent"
+	// RequestIDHeader carries an opaque request ID; when absent the trace ID is used.
+	RequestIDHeader = "X-Request-ID"
+)
+
+// maxRequestID bounds the length of a propagated request ID.
+const maxRequestID = 128
+
+// Trace identifies a request across the agent, the server and the database.
+type Trace struct {
+	RequestID string // идентификатор запроса
+	TraceID   string // идентификатор трассы, 32 hex символа
+	SpanID    string // идентификатор текущего участка, 16 hex символов
+	Flags     string // флаги трассировки, 2 hex символа
+}
+
+// NewTrace starts a new sampled trace whose request ID is its trace ID.
+func NewTrace() Trace {
+	t := Trace{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "01"}
+	t.RequestID = t.TraceID
+	return t
+}
+
+// ParseTraceparent parses a traceparent header value. The request ID of
+// the result is its trace ID.
+//
+// Returns false if s is malformed or has an all-zero trace or span ID.
+func ParseTraceparent(s string) (Trace, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return Trace{}, false
+	}
+	t := Trace{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}
+	if !isHex(parts[0], 2) || !isHex(t.TraceID, 32) || !isHex(t.SpanID, 16) || !isHex(t.Flags, 2) ||
+		strings.Trim(t.TraceID, "0") == "" || strings.Trim(t.SpanID, "0") == "" {
+		return Trace{}, false
+	}
+	t.RequestID = t.TraceID
+	return t, true
+}
+
+// Child returns the trace of the next hop: the same trace and request
+// with a new span ID.
+func (t Trace) Child() Trace {
+	t.SpanID = randomHex(8)
+	return t
+}
+
+// Traceparent formats t as a version 00 traceparent header value.
+func (t Trace) Traceparent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + t.Flags
+}
+
+// ValidRequestID reports whether id may be propagated as a request ID:
+// at most 128 letters, digits and ".-_:" characters.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '-', r == '_', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithTrace returns a copy of ctx carrying t.
+func WithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, TraceKey, t)
+}
+
+// TraceFrom returns the trace carried by ctx.
+func TraceFrom(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(TraceKey).(Trace)
+	return t, ok
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isHex reports whether s is n lowercase hex characters.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	trace, ok := ParseTraceparent(valid)
+	if !ok {
+		t.Fatal("valid traceparent rejected")
+	}
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.SpanID != "00f067aa0ba902b7" ||
+		trace.Flags != "01" || trace.RequestID != trace.TraceID {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if trace.Traceparent() != valid {
+		t.Fatalf("Traceparent() = %q", trace.Traceparent())
+	}
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(s); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", s)
+		}
+	}
+	// Later versions may append fields
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Error("future version rejected")
+	}
+}
+
+func TestNewTrace(t *testing.T) {
+	trace := NewTrace()
+	parsed, ok := ParseTraceparent(trace.Traceparent())
+	if !ok || parsed != trace {
+		t.Fatalf("new trace %+v does not round trip: %+v", trace, parsed)
+	}
+	child := trace.Child()
+	if child.TraceID != trace.TraceID || child.SpanID == trace.SpanID || child.RequestID != trace.RequestID {
+		t.Fatalf("unexpected child %+v of %+v", child, trace)
+	}
+	if NewTrace().TraceID == trace.TraceID {
+		t.Fatal("trace IDs repeat")
+	}
+
+	ctx := WithTrace(context.Background(), trace)
+	if got, ok := TraceFrom(ctx); !ok || got != trace {
+		t.Fatalf("TraceFrom = %+v, %v", got, ok)
+	}
+	if _, ok := TraceFrom(context.Background()); ok {
+		t.Fatal("trace found in empty context")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for _, id := range []string{"abc", "agent-1:batch_42.7", strings.Repeat("a", 128)} {
+		if !ValidRequestID(id) {
+			t.Errorf("ValidRequestID(%q) = false", id)
+		}
+	}
+	for _, id := range []string{"", strings.Repeat("a", 129), "a b", "a'b", "*/", "id\n"} {
+		if ValidRequestID(id) {
+			t.Errorf("ValidRequestID(%q) = true", id)
+		}
+	}
+}